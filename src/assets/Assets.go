@@ -0,0 +1,114 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const STATIC_PREFIX = "/static/"
+
+// Static files are cached for STATIC_MAX_AGE and then revalidated by their
+// ETag, so a new release or a changed theme shows up within a day.
+const STATIC_MAX_AGE = 24 * time.Hour
+
+//go:embed templates/*.html
+//go:embed static
+var embedded embed.FS
+
+// A theme directory may hold templates/<name>.html and static/<file>, each
+// taking the place of the embedded file with the same name.
+type Theme struct {
+	directory string
+	etags     map[string]string
+	mutex     sync.Mutex
+}
+
+// New uses the embedded files where directory has none; an empty directory
+// uses only the embedded ones.
+func New(directory string) *Theme {
+	return &Theme{directory: directory, etags: make(map[string]string)}
+}
+
+func (t *Theme) readFile(name string) ([]byte, error) {
+	if t.directory != "" {
+		content, err := fs.ReadFile(os.DirFS(t.directory), name)
+		if err == nil || !os.IsNotExist(err) {
+			return content, err
+		}
+	}
+
+	return embedded.ReadFile(name)
+}
+
+// Templates parses every embedded template, or the one of the theme in its
+// place, with funcs available to them.
+func (t *Theme) Templates(funcs template.FuncMap) (*template.Template, error) {
+	names, err := fs.Glob(embedded, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	var templates *template.Template = template.New("").Funcs(funcs)
+
+	for _, name := range names {
+		content, err := t.readFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = templates.New(path.Base(name)).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return templates, nil
+}
+
+func (t *Theme) etag(name string, content []byte) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Theme files may change while the server runs, embedded ones cannot.
+	if etag, known := t.etags[name]; known && t.directory == "" {
+		return etag
+	}
+
+	var sum [sha256.Size]byte = sha256.Sum256(content)
+	var etag string = `"` + hex.EncodeToString(sum[:8]) + `"`
+	t.etags[name] = etag
+
+	return etag
+}
+
+// ServeHTTP serves the files below STATIC_PREFIX. Directories are not
+// listed.
+func (t *Theme) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var name string = strings.TrimPrefix(request.URL.Path, STATIC_PREFIX)
+	if name == "" || strings.HasSuffix(name, "/") || !fs.ValidPath(name) {
+		http.NotFound(writer, request)
+		return
+	}
+
+	content, err := t.readFile("static/" + name)
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	writer.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(STATIC_MAX_AGE.Seconds())))
+	writer.Header().Set("ETag", t.etag(name, content))
+
+	http.ServeContent(writer, request, name, time.Time{}, bytes.NewReader(content))
+}
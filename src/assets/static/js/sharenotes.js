@@ -0,0 +1,29 @@
+// Opens and closes the dropdowns of the pages, the one thing they needed the
+// Bootstrap JavaScript for. The handlers are on the document because the
+// note list, dropdown included, is reloaded when notes change.
+$(document).on("click", "[data-toggle=dropdown]", function(event) {
+  "use strict";
+  var dropdown = $(this).parent();
+  var wasOpen = dropdown.hasClass("open");
+
+  event.preventDefault();
+  event.stopPropagation();
+  $(".dropdown.open").removeClass("open");
+  if(!wasOpen)
+  {
+    dropdown.addClass("open");
+  }
+});
+
+$(document).on("click", function() {
+  "use strict";
+  $(".dropdown.open").removeClass("open");
+});
+
+$(document).on("keydown", function(event) {
+  "use strict";
+  if(event.key == "Escape")
+  {
+    $(".dropdown.open").removeClass("open");
+  }
+});
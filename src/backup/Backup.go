@@ -0,0 +1,293 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FILE_PREFIX = "sndb-"
+const FILE_DATE_FORMAT = "20060102-150405"
+const DATABASE_EXTENSION = ".db"
+const GZIP_EXTENSION = ".gz"
+const CHECKSUM_EXTENSION = ".sha256"
+
+// Backups taken within the same second get a counter after the date:
+// sndb-20160101-120000.db, sndb-20160101-120000-2.db and so on.
+var backupFileName = regexp.MustCompile(`^sndb-([0-9]{8}-[0-9]{6})(?:-([0-9]+))?\.db(\.gz)?$`)
+
+// Source writes a consistent copy of the database to path.
+type Source interface {
+	Backup(ctx context.Context, path string) error
+}
+
+type Options struct {
+	Directory string
+	Interval  time.Duration
+	Hourly    int
+	Daily     int
+	Weekly    int
+	Gzip      bool
+	Checksum  bool
+}
+
+type Backup struct {
+	Name       string
+	Date       time.Time
+	Size       int64
+	Compressed bool
+	Checksum   string
+	sequence   int
+}
+
+type Scheduler struct {
+	source  Source
+	options Options
+	mutex   sync.Mutex
+}
+
+func New(source Source, options Options) (*Scheduler, error) {
+	err := os.MkdirAll(options.Directory, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scheduler{source: source, options: options}, nil
+}
+
+func (s *Scheduler) Options() Options {
+	return s.options
+}
+
+// Run takes a backup every interval until stop is closed. The first backup
+// is taken one interval after the start, so restarting the server often
+// does not pile up copies.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	var ticker *time.Ticker = time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		created, err := s.Snapshot(context.Background())
+		if err != nil {
+			slog.Error("Taking scheduled backup.", "error", err)
+			continue
+		}
+		slog.Info("Backed up the database.", "file", created.Name)
+
+		err = s.Prune()
+		if err != nil {
+			slog.Error("Pruning old backups.", "error", err)
+		}
+	}
+}
+
+// Snapshot takes a backup right away. The copy is made under a hidden name
+// and only renamed once it is complete, so List never shows half a backup.
+func (s *Scheduler) Snapshot(ctx context.Context) (Backup, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var date time.Time = time.Now()
+	var name string = s.freeName(date)
+	var partial string = filepath.Join(s.options.Directory, "."+name+".partial")
+
+	err := s.source.Backup(ctx, partial)
+	if err != nil {
+		os.Remove(partial)
+		return Backup{}, err
+	}
+
+	if s.options.Gzip {
+		err = compress(partial)
+		os.Remove(partial)
+		if err != nil {
+			os.Remove(partial + GZIP_EXTENSION)
+			return Backup{}, err
+		}
+		partial += GZIP_EXTENSION
+		name += GZIP_EXTENSION
+	}
+
+	var path string = filepath.Join(s.options.Directory, name)
+
+	err = os.Rename(partial, path)
+	if err != nil {
+		os.Remove(partial)
+		return Backup{}, err
+	}
+
+	var created Backup = Backup{Name: name, Date: date, Compressed: s.options.Gzip}
+
+	if s.options.Checksum {
+		created.Checksum, err = writeChecksum(path)
+		if err != nil {
+			return created, err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		created.Size = info.Size()
+	}
+
+	return created, err
+}
+
+// freeName names a backup taken at date, counting up while a backup of the
+// same second exists, compressed or not.
+func (s *Scheduler) freeName(date time.Time) string {
+	var stem string = FILE_PREFIX + date.Format(FILE_DATE_FORMAT)
+	var name string = stem + DATABASE_EXTENSION
+
+	for sequence := 2; s.exists(name) || s.exists(name+GZIP_EXTENSION); sequence++ {
+		name = stem + "-" + strconv.Itoa(sequence) + DATABASE_EXTENSION
+	}
+
+	return name
+}
+
+func (s *Scheduler) exists(name string) bool {
+	_, err := os.Lstat(filepath.Join(s.options.Directory, name))
+	return !os.IsNotExist(err)
+}
+
+func compress(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(path+GZIP_EXTENSION, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	var writer *gzip.Writer = gzip.NewWriter(destination)
+
+	_, err = io.Copy(writer, source)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = destination.Sync()
+	}
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// writeChecksum writes the SHA-256 of a backup next to it in the format of
+// sha256sum, so "sha256sum -c" can verify a copy.
+func writeChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var hasher = sha256.New()
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		return "", err
+	}
+
+	var checksum string = hex.EncodeToString(hasher.Sum(nil))
+
+	err = ioutil.WriteFile(path+CHECKSUM_EXTENSION, []byte(fmt.Sprintf("%s  %s\n", checksum, filepath.Base(path))), 0600)
+
+	return checksum, err
+}
+
+func readChecksum(path string) string {
+	file, err := os.Open(path + CHECKSUM_EXTENSION)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	var scanner *bufio.Scanner = bufio.NewScanner(file)
+	if scanner.Scan() {
+		return strings.Fields(scanner.Text() + " ")[0]
+	}
+
+	return ""
+}
+
+// List returns the backups in the directory, newest first.
+func (s *Scheduler) List() ([]Backup, error) {
+	entries, err := ioutil.ReadDir(s.options.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		match := backupFileName.FindStringSubmatch(entry.Name())
+		if match == nil || !entry.Mode().IsRegular() {
+			continue
+		}
+
+		date, err := time.ParseInLocation(FILE_DATE_FORMAT, match[1], time.Local)
+		if err != nil {
+			continue
+		}
+
+		var sequence int = 1
+		if match[2] != "" {
+			sequence, err = strconv.Atoi(match[2])
+			if err != nil {
+				continue
+			}
+		}
+
+		backups = append(backups, Backup{
+			Name:       entry.Name(),
+			Date:       date,
+			Size:       entry.Size(),
+			Compressed: match[3] != "",
+			Checksum:   readChecksum(filepath.Join(s.options.Directory, entry.Name())),
+			sequence:   sequence})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].Date.Equal(backups[j].Date) {
+			return backups[i].sequence > backups[j].sequence
+		}
+		return backups[i].Date.After(backups[j].Date)
+	})
+
+	return backups, nil
+}
+
+// Open opens a backup for download. Only names that List returns are
+// accepted.
+func (s *Scheduler) Open(name string) (*os.File, error) {
+	if !backupFileName.MatchString(name) {
+		return nil, os.ErrNotExist
+	}
+
+	return os.Open(filepath.Join(s.options.Directory, name))
+}
@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// A testSource writes the same content for every backup.
+type testSource struct{}
+
+func (testSource) Backup(ctx context.Context, path string) error {
+	return ioutil.WriteFile(path, []byte("database"), 0600)
+}
+
+// TestSnapshotNames takes backups faster than one a second; none may
+// replace another.
+func TestSnapshotNames(t *testing.T) {
+	for _, gzip := range []bool{false, true} {
+		s, err := New(testSource{}, Options{Directory: t.TempDir(), Gzip: gzip, Checksum: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var created []string
+		for i := 0; i < 3; i++ {
+			backup, err := s.Snapshot(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			created = append(created, backup.Name)
+		}
+
+		backups, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var listed []string
+		for _, backup := range backups {
+			listed = append(listed, backup.Name)
+			if backup.Compressed != gzip || backup.Checksum == "" {
+				t.Errorf("%s: compressed %v, checksum %q", backup.Name, backup.Compressed, backup.Checksum)
+			}
+			if file, err := s.Open(backup.Name); err != nil {
+				t.Errorf("%s: %s", backup.Name, err)
+			} else {
+				file.Close()
+			}
+		}
+
+		// Newest first, whether or not the seconds changed in between.
+		var expected []string = []string{created[2], created[1], created[0]}
+		if strings.Join(listed, "|") != strings.Join(expected, "|") {
+			t.Errorf("gzip %v: listed %q, expected %q", gzip, listed, expected)
+		}
+	}
+}
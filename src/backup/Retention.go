@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Keep decides which backups survive: the newest backup of each of the last
+// hourly hours, daily days and weekly weeks that have one. The newest backup
+// is always kept. backups have to be sorted newest first.
+func Keep(backups []Backup, hourly int, daily int, weekly int) map[string]bool {
+	var kept map[string]bool = make(map[string]bool)
+
+	if len(backups) > 0 {
+		kept[backups[0].Name] = true
+	}
+
+	keepNewestPer := func(count int, period func(time.Time) string) {
+		var seen map[string]bool = make(map[string]bool)
+
+		for _, backup := range backups {
+			if len(seen) >= count {
+				return
+			}
+
+			var key string = period(backup.Date)
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			kept[backup.Name] = true
+		}
+	}
+
+	keepNewestPer(hourly, func(date time.Time) string {
+		return date.Format("2006-01-02 15")
+	})
+	keepNewestPer(daily, func(date time.Time) string {
+		return date.Format("2006-01-02")
+	})
+	keepNewestPer(weekly, func(date time.Time) string {
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+
+	return kept
+}
+
+// Prune deletes the backups Keep does not keep, with their checksums.
+func (s *Scheduler) Prune() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	backups, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	var kept map[string]bool = Keep(backups, s.options.Hourly, s.options.Daily, s.options.Weekly)
+
+	for _, backup := range backups {
+		if kept[backup.Name] {
+			continue
+		}
+
+		var path string = filepath.Join(s.options.Directory, backup.Name)
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		os.Remove(path + CHECKSUM_EXTENSION)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKeep(t *testing.T) {
+	var backups []Backup
+	for _, date := range []string{
+		"2024-03-13 12:10",
+		"2024-03-13 12:00",
+		"2024-03-13 11:30",
+		"2024-03-13 09:00",
+		"2024-03-12 23:00",
+		"2024-03-12 08:00",
+		"2024-03-11 10:00", // Monday, ISO week 11
+		"2024-03-10 22:00", // Sunday, ISO week 10
+		"2024-03-06 12:00",
+		"2024-03-01 12:00",
+		"2024-02-20 12:00",
+	} {
+		parsed, err := time.Parse("2006-01-02 15:04", date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, Backup{Name: date, Date: parsed})
+	}
+
+	var tests = []struct {
+		name   string
+		hourly int
+		daily  int
+		weekly int
+		kept   []string
+	}{
+		{"nothing but the newest", 0, 0, 0, []string{"2024-03-13 12:10"}},
+		{"hourly", 3, 0, 0, []string{"2024-03-13 12:10", "2024-03-13 11:30", "2024-03-13 09:00"}},
+		{"daily", 0, 3, 0, []string{"2024-03-13 12:10", "2024-03-12 23:00", "2024-03-11 10:00"}},
+		{"weekly", 0, 0, 3, []string{"2024-03-13 12:10", "2024-03-10 22:00", "2024-03-01 12:00"}},
+		{"combined", 2, 2, 2, []string{"2024-03-13 12:10", "2024-03-13 11:30", "2024-03-12 23:00", "2024-03-10 22:00"}},
+		{"more periods than backups", 0, 0, 10, []string{"2024-03-13 12:10", "2024-03-10 22:00", "2024-03-01 12:00", "2024-02-20 12:00"}},
+	}
+
+	for _, test := range tests {
+		var kept map[string]bool = Keep(backups, test.hourly, test.daily, test.weekly)
+
+		var names []string
+		for name := range kept {
+			names = append(names, name)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+		if len(names) != len(test.kept) {
+			t.Errorf("%s: kept %v, expected %v", test.name, names, test.kept)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.kept[i] {
+				t.Errorf("%s: kept %v, expected %v", test.name, names, test.kept)
+				break
+			}
+		}
+	}
+
+	if kept := Keep(nil, 1, 1, 1); len(kept) != 0 {
+		t.Errorf("kept %v without backups", kept)
+	}
+}
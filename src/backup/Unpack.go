@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Unpack writes the database in a backup to destination, which must not
+// exist yet. A backup with a checksum file is only unpacked if it matches.
+func Unpack(path string, destination string) error {
+	var expected string = readChecksum(path)
+
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	var hasher = sha256.New()
+	var reader io.Reader = io.TeeReader(source, hasher)
+
+	if strings.HasSuffix(path, GZIP_EXTENSION) {
+		unzipped, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer unzipped.Close()
+		reader = unzipped
+	}
+
+	target, err := os.OpenFile(destination, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(target, reader)
+	if err == nil {
+		// Hash whatever gzip did not need to read, e.g. padding.
+		_, err = io.Copy(hasher, source)
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && expected != "" && expected != hex.EncodeToString(hasher.Sum(nil)) {
+		err = fmt.Errorf("%s does not match its checksum.", path)
+	}
+
+	if err != nil {
+		os.Remove(destination)
+	}
+
+	return err
+}
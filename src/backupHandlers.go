@@ -0,0 +1,141 @@
+package main
+
+import (
+	"backup"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"router"
+	"time"
+)
+
+var backupScheduler *backup.Scheduler
+
+type backupsData struct {
+	Enabled   bool
+	Directory string
+	Interval  time.Duration
+	Backups   []htmlBackup
+	Token     synchronizedToken
+}
+
+type htmlBackup struct {
+	Name     string
+	Date     time.Time
+	Size     string
+	Checksum string
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+func (s *server) startBackups() {
+	source, isSource := dbManager.(backup.Source)
+	if !isSource {
+		slog.Warn("Backups need the SQLite storage, not starting them.")
+		return
+	}
+
+	scheduler, err := backup.New(source, backup.Options{
+		Directory: s.settings.BackupDirectory,
+		Interval:  s.settings.BackupInterval,
+		Hourly:    s.settings.BackupKeepHourly,
+		Daily:     s.settings.BackupKeepDaily,
+		Weekly:    s.settings.BackupKeepWeekly,
+		Gzip:      s.settings.BackupGzip,
+		Checksum:  s.settings.BackupChecksum})
+	if err != nil {
+		slog.Error("Starting backups.", "error", err)
+		return
+	}
+
+	backupScheduler = scheduler
+	startBackgroundJob(backupScheduler.Run)
+}
+
+func backupsHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == "POST" {
+		backupNowHandler(writer, request)
+		return
+	}
+
+	var data backupsData = backupsData{Enabled: backupScheduler != nil, Token: sidManager.generateSynchronizedToken()}
+
+	if backupScheduler != nil {
+		data.Directory = backupScheduler.Options().Directory
+		data.Interval = backupScheduler.Options().Interval
+
+		backups, err := backupScheduler.List()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, b := range backups {
+			data.Backups = append(data.Backups, htmlBackup{Name: b.Name, Date: b.Date, Size: formatSize(b.Size), Checksum: b.Checksum})
+		}
+	}
+
+	err := templates.ExecuteTemplate(writer, "Backups.html", data)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func backupNowHandler(writer http.ResponseWriter, request *http.Request) {
+	if !checkSynchronizedToken(writer, request) {
+		return
+	}
+
+	if backupScheduler == nil {
+		http.Error(writer, "Backups are not enabled.", http.StatusNotFound)
+		return
+	}
+
+	_, err := backupScheduler.Snapshot(request.Context())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = backupScheduler.Prune()
+	if err != nil {
+		loggerFor(request).Error("Pruning old backups.", "error", err)
+	}
+
+	http.Redirect(writer, request, routes.URL("backups"), http.StatusFound)
+}
+
+func downloadBackupHandler(writer http.ResponseWriter, request *http.Request) {
+	var name string = router.Param(request, "name")
+
+	if backupScheduler == nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	file, err := backupScheduler.Open(name)
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	http.ServeContent(writer, request, name, info.ModTime(), file)
+}
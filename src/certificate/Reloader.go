@@ -0,0 +1,49 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// A Reloader hands out the certificate loaded from a pair of files and can
+// load them again, e.g. after they were renewed, without a restart.
+type Reloader struct {
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	mutex       sync.RWMutex
+}
+
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	var reloader *Reloader = &Reloader{certFile: certFile, keyFile: keyFile}
+
+	err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Reload reads the files again. If they cannot be read the certificate in
+// use is kept.
+func (r *Reloader) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.certificate = &certificate
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// GetCertificate is meant for tls.Config.
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.certificate, nil
+}
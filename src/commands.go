@@ -0,0 +1,53 @@
+package main
+
+import (
+	"config"
+	"fmt"
+	"os"
+)
+
+const USAGE = `Usage: shareNotes [command] [flags]
+
+Without a command the ShareNotes server is started.
+
+Commands:
+  export    write all notes as JSON, Markdown, a zip of Markdown files or HTML
+  import    read notes exported from Simplenote, Google Keep (Takeout), Evernote (.enex)
+            or a directory of Markdown and text files
+  sync      keep a folder with one Markdown file per note in sync with the notes
+  restore   rebuild the database as it was at a point in time from a backup and the revisions
+  preview   serve a restored database read-only to check it
+  swap      put a restored database in place of sndb.db (stop the server first)
+  keygen    print a new key for encrypting sndb.db
+  config    print the effective configuration, takes the flags of the server
+
+Run "shareNotes <command> -h" for the flags of a command.
+`
+
+// Commands get the settings of the config file and the environment.
+func runCommand(name string, arguments []string, settings config.Config) int {
+	switch name {
+	case "export":
+		return exportCommand(arguments)
+	case "import":
+		return importCommand(arguments)
+	case "sync":
+		return syncCommand(arguments, settings)
+	case "restore":
+		return restoreCommand(arguments, settings)
+	case "preview":
+		return previewCommand(arguments, settings)
+	case "swap":
+		return swapCommand(arguments, settings)
+	case "keygen":
+		return keygenCommand(arguments)
+	case "config":
+		return configCommand(arguments)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(USAGE)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n%s", name, USAGE)
+	return 2
+}
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const STORAGE_SQLITE = "sqlite"
+const STORAGE_GIT = "git"
+
+const DEFAULT_ADDRESS = ":8080"
+const DEFAULT_DATABASE_FILE = "sndb.db"
+const DEFAULT_RATE_LIMIT = time.Microsecond
+const DEFAULT_DPASTE_URL = "http://dpaste.com/api/v2/"
+const DEFAULT_GIT_DIRECTORY = "notes"
+const DEFAULT_PAGE_SIZE = 50
+const MAX_PAGE_SIZE = 500
+
+// The /Admin pages ask for ADMIN_USER and the admin-password.
+const ADMIN_USER = "admin"
+
+const LOG_FORMAT_TEXT = "text"
+const LOG_FORMAT_JSON = "json"
+
+// Edit leases are renewed every 30 seconds while a note is open, a shorter
+// lease would run out in between.
+const MIN_EDIT_LEASE_DURATION = time.Minute
+
+// Config holds the settings of the server and of the commands. Every setting
+// has a name, used as command-line flag, as key in the config file and, in
+// upper case with the ENVIRONMENT_PREFIX, as environment variable.
+type Config struct {
+	Address        string
+	BasePath       string
+	DatabaseFile   string
+	ThemeDirectory string
+	RateLimit      time.Duration
+	DPasteURL      string
+	PageSize       int
+	AdminPassword  string
+
+	LogLevel  string
+	LogFormat string
+
+	TLS             bool
+	TLSCert         string
+	TLSKey          string
+	RedirectAddress string
+
+	Storage      string
+	GitDirectory string
+	KeyFile      string
+
+	BackupDirectory  string
+	BackupInterval   time.Duration
+	BackupKeepHourly int
+	BackupKeepDaily  int
+	BackupKeepWeekly int
+	BackupGzip       bool
+	BackupChecksum   bool
+
+	SyncDirectory string
+	SyncInterval  time.Duration
+
+	EditLeases        bool
+	EditLeaseDuration time.Duration
+
+	// Where each setting came from, see Print.
+	origins map[string]string
+	file    string
+}
+
+// Default returns the settings ShareNotes runs with when nothing is
+// configured.
+func Default() Config {
+	return Config{
+		Address:           DEFAULT_ADDRESS,
+		DatabaseFile:      DEFAULT_DATABASE_FILE,
+		RateLimit:         DEFAULT_RATE_LIMIT,
+		DPasteURL:         DEFAULT_DPASTE_URL,
+		PageSize:          DEFAULT_PAGE_SIZE,
+		LogLevel:          "info",
+		LogFormat:         LOG_FORMAT_TEXT,
+		Storage:           STORAGE_SQLITE,
+		GitDirectory:      DEFAULT_GIT_DIRECTORY,
+		BackupInterval:    time.Hour,
+		BackupKeepHourly:  24,
+		BackupKeepDaily:   7,
+		BackupKeepWeekly:  4,
+		BackupGzip:        true,
+		BackupChecksum:    true,
+		SyncInterval:      2 * time.Second,
+		EditLeases:        true,
+		EditLeaseDuration: 2 * time.Minute,
+		origins:           make(map[string]string)}
+}
+
+// flagSet binds every setting of c to a flag, with the current value as
+// default.
+func (c *Config) flagSet() *flag.FlagSet {
+	var flags *flag.FlagSet = flag.NewFlagSet("shareNotes", flag.ContinueOnError)
+
+	flags.StringVar(&c.Address, "address", c.Address, "address the server listens on")
+	flags.StringVar(&c.BasePath, "base-path", c.BasePath, "path the pages are served below, e.g. /notes behind a reverse proxy")
+	flags.StringVar(&c.DatabaseFile, "database", c.DatabaseFile, "SQLite database file")
+	flags.StringVar(&c.ThemeDirectory, "theme", c.ThemeDirectory, "directory with templates/ and static/ files that replace the built-in ones")
+	flags.DurationVar(&c.RateLimit, "rate-limit", c.RateLimit, "minimum time between two requests, 0 turns the limit off")
+	flags.StringVar(&c.DPasteURL, "dpaste-url", c.DPasteURL, "dPaste API notes are sent to")
+	flags.IntVar(&c.PageSize, "page-size", c.PageSize, "notes on a page of the index, unless the page asks for another size")
+	flags.StringVar(&c.AdminPassword, "admin-password", c.AdminPassword, "password of the user "+ADMIN_USER+" on the /Admin pages; without one they only answer requests from this machine")
+
+	flags.StringVar(&c.LogLevel, "log-level", c.LogLevel, "least severe messages logged: debug, info, warn or error")
+	flags.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log lines as "+LOG_FORMAT_TEXT+" (logfmt) or "+LOG_FORMAT_JSON)
+
+	flags.BoolVar(&c.TLS, "tls", c.TLS, "serve HTTPS, with a self-signed certificate unless tls-cert and tls-key are set")
+	flags.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "certificate file (PEM), reloaded on SIGHUP")
+	flags.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "key file (PEM) of the certificate")
+	flags.StringVar(&c.RedirectAddress, "redirect-address", c.RedirectAddress, "address of a plain HTTP listener that redirects to HTTPS, e.g. :80")
+
+	flags.StringVar(&c.Storage, "storage", c.Storage, "where notes are kept: "+STORAGE_SQLITE+" or "+STORAGE_GIT)
+	flags.StringVar(&c.GitDirectory, "git-directory", c.GitDirectory, "git repository for the "+STORAGE_GIT+" storage")
+	flags.StringVar(&c.KeyFile, "key-file", c.KeyFile, "file with the keys sndb.db is encrypted with")
+
+	flags.StringVar(&c.BackupDirectory, "backup-directory", c.BackupDirectory, "directory for scheduled backups, empty turns them off")
+	flags.DurationVar(&c.BackupInterval, "backup-interval", c.BackupInterval, "time between two backups")
+	flags.IntVar(&c.BackupKeepHourly, "backup-keep-hourly", c.BackupKeepHourly, "hours to keep a backup of")
+	flags.IntVar(&c.BackupKeepDaily, "backup-keep-daily", c.BackupKeepDaily, "days to keep a backup of")
+	flags.IntVar(&c.BackupKeepWeekly, "backup-keep-weekly", c.BackupKeepWeekly, "weeks to keep a backup of")
+	flags.BoolVar(&c.BackupGzip, "backup-gzip", c.BackupGzip, "gzip backups")
+	flags.BoolVar(&c.BackupChecksum, "backup-checksum", c.BackupChecksum, "write a .sha256 file next to every backup")
+
+	flags.StringVar(&c.SyncDirectory, "sync-directory", c.SyncDirectory, "folder the server mirrors the notes to, empty turns it off")
+	flags.DurationVar(&c.SyncInterval, "sync-interval", c.SyncInterval, "how often the folder is checked for changes")
+
+	flags.BoolVar(&c.EditLeases, "edit-leases", c.EditLeases, "warn when a note is being edited on another device")
+	flags.DurationVar(&c.EditLeaseDuration, "edit-lease-duration", c.EditLeaseDuration, "how long an edit lease lasts without being renewed")
+
+	return flags
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c Config) Validate() error {
+	var problems []string
+
+	report := func(format string, arguments ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, arguments...))
+	}
+
+	_, port, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		report("address %q is not host:port: %s", c.Address, err)
+	} else if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		report("address %q has no valid port", c.Address)
+	}
+
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || strings.ContainsAny(c.BasePath, "{}*?#")) {
+		report("base-path %q does not start with / or holds one of {}*?#", c.BasePath)
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		report("tls-cert and tls-key have to be set together")
+	}
+	if c.RedirectAddress != "" {
+		if !c.TLS {
+			report("redirect-address needs tls")
+		}
+		if _, _, err = net.SplitHostPort(c.RedirectAddress); err != nil {
+			report("redirect-address %q is not host:port: %s", c.RedirectAddress, err)
+		}
+	}
+
+	if c.DatabaseFile == "" {
+		report("database is empty")
+	}
+
+	if c.ThemeDirectory != "" {
+		info, err := os.Stat(c.ThemeDirectory)
+		if err != nil || !info.IsDir() {
+			report("theme %q is not a directory", c.ThemeDirectory)
+		}
+	}
+
+	if c.RateLimit < 0 {
+		report("rate-limit %s is negative", c.RateLimit)
+	}
+
+	dpaste, err := url.Parse(c.DPasteURL)
+	if err != nil || (dpaste.Scheme != "http" && dpaste.Scheme != "https") || dpaste.Host == "" {
+		report("dpaste-url %q is not an http or https URL", c.DPasteURL)
+	}
+
+	if c.PageSize < 1 || c.PageSize > MAX_PAGE_SIZE {
+		report("page-size %d is not between 1 and %d", c.PageSize, MAX_PAGE_SIZE)
+	}
+
+	var level slog.Level
+	if level.UnmarshalText([]byte(c.LogLevel)) != nil {
+		report("log-level %q is none of debug, info, warn and error", c.LogLevel)
+	}
+	if c.LogFormat != LOG_FORMAT_TEXT && c.LogFormat != LOG_FORMAT_JSON {
+		report("log-format %q is neither %q nor %q", c.LogFormat, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	}
+
+	if c.Storage != STORAGE_SQLITE && c.Storage != STORAGE_GIT {
+		report("storage %q is neither %q nor %q", c.Storage, STORAGE_SQLITE, STORAGE_GIT)
+	}
+	if c.Storage == STORAGE_GIT && c.GitDirectory == "" {
+		report("git-directory is empty")
+	}
+
+	if c.KeyFile != "" {
+		if _, err = os.Stat(c.KeyFile); err != nil {
+			report("key-file: %s", err)
+		}
+	}
+
+	if c.BackupInterval < time.Minute {
+		report("backup-interval %s is shorter than a minute", c.BackupInterval)
+	}
+	if c.BackupKeepHourly < 0 || c.BackupKeepDaily < 0 || c.BackupKeepWeekly < 0 {
+		report("backup-keep-hourly, backup-keep-daily and backup-keep-weekly cannot be negative")
+	}
+
+	if c.SyncDirectory != "" && c.Storage == STORAGE_GIT {
+		report("sync-directory needs storage %q, %q keeps no change feed to sync from", STORAGE_SQLITE, STORAGE_GIT)
+	}
+	if c.SyncInterval <= 0 {
+		report("sync-interval %s is not positive", c.SyncInterval)
+	}
+
+	if c.EditLeaseDuration < MIN_EDIT_LEASE_DURATION {
+		report("edit-lease-duration %s is shorter than %s", c.EditLeaseDuration, MIN_EDIT_LEASE_DURATION)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// Level is the slog level of LogLevel, info if it is not valid.
+func (c Config) Level() slog.Level {
+	var level slog.Level
+	if level.UnmarshalText([]byte(c.LogLevel)) != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// Print writes the effective settings in the format of a TOML config file,
+// each with where its value came from.
+func (c Config) Print(writer io.Writer) {
+	if c.file != "" {
+		fmt.Fprintf(writer, "# Config file: %s\n", c.file)
+	}
+
+	var current Config = c
+	current.flagSet().VisitAll(func(f *flag.Flag) {
+		var value string = f.Value.String()
+		if _, err := strconv.ParseBool(value); err != nil {
+			if _, err = strconv.ParseInt(value, 10, 64); err != nil {
+				value = strconv.Quote(value)
+			}
+		}
+
+		if f.Name == "admin-password" && c.AdminPassword != "" {
+			value = strconv.Quote("********")
+		}
+
+		var origin string = c.origins[f.Name]
+		if origin == "" {
+			origin = "default"
+		}
+
+		fmt.Fprintf(writer, "%-20s = %-28s # %s\n", f.Name, value, origin)
+	})
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Settings are read from the environment as ENVIRONMENT_PREFIX followed by
+// the name in upper case with underscores, e.g. SHARENOTES_BACKUP_DIRECTORY.
+const ENVIRONMENT_PREFIX = "SHARENOTES_"
+const CONFIG_FILE_VARIABLE = "SHARENOTES_CONFIG"
+
+// Without -config or SHARENOTES_CONFIG the first of these files that exists
+// is read.
+var DEFAULT_CONFIG_FILES = []string{"sharenotes.toml", "sharenotes.json"}
+
+const ORIGIN_FILE = "config file"
+const ORIGIN_ENVIRONMENT = "environment"
+const ORIGIN_FLAG = "flag"
+
+// Load builds the configuration from, in this order, the defaults, the
+// config file, the environment and the command-line arguments; each one
+// overrides the ones before. The result is validated.
+func Load(arguments []string) (Config, error) {
+	// A first pass over the arguments finds -config and tells which
+	// settings the command line sets, so they can be applied last.
+	var probe Config = Default()
+	var probeFlags *flag.FlagSet = probe.flagSet()
+	var path *string = probeFlags.String("config", "", "config file, TOML or JSON")
+	probeFlags.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: shareNotes [flags]\n\nEvery flag can also be set in the config file or as SHARENOTES_<FLAG> in the environment.\n\n")
+		probeFlags.PrintDefaults()
+	}
+
+	err := probeFlags.Parse(arguments)
+	if err != nil {
+		return probe, err
+	}
+	if probeFlags.NArg() > 0 {
+		return probe, fmt.Errorf("Unexpected argument %q, see \"shareNotes -h\".", probeFlags.Arg(0))
+	}
+
+	var c Config = Default()
+	var flags *flag.FlagSet = c.flagSet()
+
+	c.file = *path
+	if c.file == "" {
+		c.file = os.Getenv(CONFIG_FILE_VARIABLE)
+	}
+	if c.file == "" {
+		for _, name := range DEFAULT_CONFIG_FILES {
+			if _, statErr := os.Stat(name); statErr == nil {
+				c.file = name
+				break
+			}
+		}
+	}
+
+	if c.file != "" {
+		settings, err := readFile(c.file)
+		if err != nil {
+			return c, err
+		}
+
+		for name, value := range settings {
+			if flags.Lookup(name) == nil {
+				return c, fmt.Errorf("%s: unknown setting %q", c.file, name)
+			}
+			err = flags.Set(name, value)
+			if err != nil {
+				return c, fmt.Errorf("%s: %s: %s", c.file, name, err)
+			}
+			c.origins[name] = ORIGIN_FILE
+		}
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		var variable string = environmentVariable(f.Name)
+		value, isSet := os.LookupEnv(variable)
+		if !isSet || err != nil {
+			return
+		}
+
+		err = flags.Set(f.Name, value)
+		if err != nil {
+			err = fmt.Errorf("%s: %s", variable, err)
+			return
+		}
+		c.origins[f.Name] = ORIGIN_ENVIRONMENT
+	})
+	if err != nil {
+		return c, err
+	}
+
+	probeFlags.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		flags.Set(f.Name, f.Value.String())
+		c.origins[f.Name] = ORIGIN_FLAG
+	})
+
+	return c, c.Validate()
+}
+
+func environmentVariable(name string) string {
+	return ENVIRONMENT_PREFIX + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// settingName accepts keys written with underscores as well.
+func settingName(key string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(key), "_", "-", -1))
+}
+
+func readFile(path string) (map[string]string, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return readJSON(path)
+	}
+	return readTOML(path)
+}
+
+func readJSON(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var values map[string]interface{}
+	err = json.NewDecoder(file).Decode(&values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var settings map[string]string = make(map[string]string)
+	for key, value := range values {
+		switch v := value.(type) {
+		case string:
+			settings[settingName(key)] = v
+		case bool:
+			settings[settingName(key)] = strconv.FormatBool(v)
+		case float64:
+			settings[settingName(key)] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("%s: %s has to be a string, a number or a boolean", path, key)
+		}
+	}
+
+	return settings, nil
+}
+
+// readTOML reads the flat part of TOML the settings need: key = value lines
+// with strings, numbers and booleans, and comments.
+func readTOML(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var settings map[string]string = make(map[string]string)
+	var scanner *bufio.Scanner = bufio.NewScanner(file)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var line string = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var fields []string = strings.SplitN(line, "=", 2)
+		if len(fields) != 2 || strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNumber)
+		}
+
+		value, err := tomlValue(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, lineNumber, err)
+		}
+
+		settings[settingName(strings.Trim(strings.TrimSpace(fields[0]), `"`))] = value
+	}
+
+	return settings, scanner.Err()
+}
+
+func tomlValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		for i := 1; i < len(value); i++ {
+			if value[i] == '\\' {
+				i++
+			} else if value[i] == '"' {
+				return strconv.Unquote(value[:i+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated string")
+	case strings.HasPrefix(value, "'"):
+		var end int = strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return value[1 : end+1], nil
+	}
+
+	if comment := strings.Index(value, "#"); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	if value == "" {
+		return "", fmt.Errorf("missing value")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	var path string = filepath.Join(t.TempDir(), name)
+
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadTOML(t *testing.T) {
+	var tests = []struct {
+		name     string
+		content  string
+		settings string
+		failed   bool
+	}{
+		{"empty", "", "", false},
+		{"comments and blank lines", "# comment\n\n   # indented comment\n", "", false},
+		{"strings", "address = \":9000\"\ntheme = 'C:\\themes\\dark'\n", `address=:9000|theme=C:\themes\dark`, false},
+		{"escapes", `dpaste-url = "say \"hi\"\t# not a comment" # comment`, "dpaste-url=say \"hi\"\t# not a comment", false},
+		{"numbers and booleans", "page-size = 20 # per page\ntls = true\n", "page-size=20|tls=true", false},
+		{"keys with underscores and quotes", "Backup_Keep_Daily = 3\n\"log-level\" = \"debug\"\n", "backup-keep-daily=3|log-level=debug", false},
+		{"durations", "backup-interval = \"30m\"\nsync-interval = 5s\n", "backup-interval=30m|sync-interval=5s", false},
+		{"table", "[server]\naddress = \":9000\"\n", "", true},
+		{"missing equals sign", "address\n", "", true},
+		{"missing value", "address = # nothing\n", "", true},
+		{"unterminated string", "address = \":9000\n", "", true},
+		{"unterminated literal string", "address = ':9000\n", "", true},
+	}
+
+	for _, test := range tests {
+		settings, err := readTOML(writeConfigFile(t, "sharenotes.toml", test.content))
+		if (err != nil) != test.failed {
+			t.Errorf("%s: error %v, expected failure %v", test.name, err, test.failed)
+			continue
+		}
+
+		var pairs []string
+		for name, value := range settings {
+			pairs = append(pairs, name+"="+value)
+		}
+		sort.Strings(pairs)
+		if strings.Join(pairs, "|") != test.settings {
+			t.Errorf("%s: read %q, expected %q", test.name, strings.Join(pairs, "|"), test.settings)
+		}
+	}
+}
+
+// TestLoad sets the same settings in the config file, the environment and
+// on the command line; the later ones win.
+func TestLoad(t *testing.T) {
+	var path string = writeConfigFile(t, "sharenotes.toml", "address = \":9000\"\npage-size = 20\nbackup-interval = \"30m\"\n")
+
+	t.Setenv(CONFIG_FILE_VARIABLE, "")
+	t.Setenv("SHARENOTES_PAGE_SIZE", "30")
+	t.Setenv("SHARENOTES_LOG_LEVEL", "debug")
+
+	c, err := Load([]string{"-config", path, "-log-level", "warn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		value  interface{}
+		want   interface{}
+		origin string
+	}{
+		{"address", c.Address, ":9000", ORIGIN_FILE},
+		{"page-size", c.PageSize, 30, ORIGIN_ENVIRONMENT},
+		{"backup-interval", c.BackupInterval, 30 * time.Minute, ORIGIN_FILE},
+		{"log-level", c.LogLevel, "warn", ORIGIN_FLAG},
+		{"database", c.DatabaseFile, DEFAULT_DATABASE_FILE, ""},
+	}
+
+	for _, test := range tests {
+		if test.value != test.want {
+			t.Errorf("%s: %v, expected %v", test.name, test.value, test.want)
+		}
+		if c.origins[test.name] != test.origin {
+			t.Errorf("%s: set by %q, expected %q", test.name, c.origins[test.name], test.origin)
+		}
+	}
+
+	var failures = []struct {
+		name      string
+		arguments []string
+		file      string
+	}{
+		{"unknown setting", nil, "colour = \"blue\"\n"},
+		{"invalid value", nil, "page-size = \"many\"\n"},
+		{"invalid setting", nil, "backup-interval = \"10s\"\n"},
+		{"extra argument", []string{"serve"}, ""},
+	}
+
+	for _, failure := range failures {
+		var arguments []string = append([]string{"-config", writeConfigFile(t, "sharenotes.toml", failure.file)}, failure.arguments...)
+		if _, err := Load(arguments); err == nil {
+			t.Errorf("%s: loaded without an error", failure.name)
+		}
+	}
+}
+
+// TestPrint reads the printed settings back as a config file.
+func TestPrint(t *testing.T) {
+	t.Setenv(CONFIG_FILE_VARIABLE, "")
+
+	var path string = writeConfigFile(t, "sharenotes.json", `{"address": ":9000", "page_size": 20, "tls": false, "dpaste_url": "https://example.com/paste?name=\"a b\" #1"}`)
+
+	c, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var printed bytes.Buffer
+	c.Print(&printed)
+
+	reread, err := Load([]string{"-config", writeConfigFile(t, "sharenotes.toml", printed.String())})
+	if err != nil {
+		t.Fatalf("reading back %s: %s", printed.String(), err)
+	}
+
+	var reprinted bytes.Buffer
+	reread.Print(&reprinted)
+
+	// Only the comments naming the file and the origins may differ.
+	var comment *regexp.Regexp = regexp.MustCompile(`(?m)\s*#[^"\n]*$`)
+	if comment.ReplaceAllString(reprinted.String(), "") != comment.ReplaceAllString(printed.String(), "") {
+		t.Errorf("printed\n%s\nread back as\n%s", printed.String(), reprinted.String())
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"config"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// configCommand prints the settings the server would run with for the same
+// flags, config file and environment.
+func configCommand(arguments []string) int {
+	effective, err := config.Load(arguments)
+	if err == flag.ErrHelp {
+		return 0
+	}
+
+	effective.Print(os.Stdout)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,581 @@
+package gitstore
+
+import (
+	"bytes"
+	"context"
+	"database/manager"
+	"database/sql"
+	"errors"
+	"events"
+	"export"
+	"fmt"
+	"frontmatter"
+	"io/ioutil"
+	"log/slog"
+	"note"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AUTHOR_NAME = "ShareNotes"
+const AUTHOR_EMAIL = "sharenotes@localhost"
+
+// NEXT_ID_FILE holds the id the next note gets. It only ever grows, so the
+// id of a deleted note is not handed out again and the history of "<id>.md"
+// stays the history of one note.
+const NEXT_ID_FILE = ".next-id"
+
+var noteFileName = regexp.MustCompile(`^([0-9]+)\.md$`)
+
+var ErrNotSupported = errors.New("This is not supported by the git storage backend.")
+
+// GitStore keeps every note as "<id>.md" with front matter in the working
+// tree of a git repository and commits each change. The file name never
+// changes with the title, so "git log" and "git blame" follow a note over
+// its whole life.
+type GitStore struct {
+	directory   string
+	authorName  string
+	authorEmail string
+	mutex       sync.Mutex
+	packMutex   sync.Mutex
+	packs       map[string]*packIndex
+	leases      map[int]manager.Lease
+	broker      *events.Broker
+}
+
+func New(directory string) *GitStore {
+	return &GitStore{
+		directory:   directory,
+		authorName:  AUTHOR_NAME,
+		authorEmail: AUTHOR_EMAIL,
+		leases:      make(map[int]manager.Lease),
+		broker:      events.New()}
+}
+
+func (s *GitStore) Events() *events.Broker {
+	return s.broker
+}
+
+func (s *GitStore) Open(ctx context.Context) error {
+	err := os.MkdirAll(s.directory, 0755)
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(s.gitPath("HEAD")); os.IsNotExist(err) {
+		slog.Info("Initializing the git repository...", "directory", s.directory)
+		return s.initRepository()
+	}
+
+	return err
+}
+
+func (s *GitStore) Close() {
+}
+
+// Ready reports whether the working tree is still a git repository.
+func (s *GitStore) Ready(ctx context.Context) error {
+	_, err := os.Stat(s.gitPath("HEAD"))
+	return err
+}
+
+func (s *GitStore) CountNotes(ctx context.Context) (int, error) {
+	noteIDs, err := s.noteIDs()
+	return len(noteIDs), err
+}
+
+func (s *GitStore) notePath(noteID int) string {
+	return filepath.Join(s.directory, fmt.Sprintf("%d.md", noteID))
+}
+
+func parseNote(noteID int, content []byte) (note.Note, error) {
+	matter, text, found, err := frontmatter.Parse(string(content))
+	if err != nil {
+		return note.Note{}, err
+	}
+	if !found {
+		return note.Note{}, fmt.Errorf("Note %d has no front matter.", noteID)
+	}
+
+	return note.NewLocal(noteID, matter.Title, text, matter.AddDate, matter.ChangeDate, matter.Version, matter.Tags), nil
+}
+
+func (s *GitStore) readNote(noteID int) (note.Note, error) {
+	content, err := ioutil.ReadFile(s.notePath(noteID))
+	if os.IsNotExist(err) {
+		return note.Note{}, sql.ErrNoRows
+	} else if err != nil {
+		return note.Note{}, err
+	}
+
+	return parseNote(noteID, content)
+}
+
+// noteIDs lists the notes in the working tree in the order of their ids.
+func (s *GitStore) noteIDs() ([]int, error) {
+	entries, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var noteIDs []int
+	for _, entry := range entries {
+		match := noteFileName.FindStringSubmatch(entry.Name())
+		if match == nil || !entry.Mode().IsRegular() {
+			continue
+		}
+		noteID, _ := strconv.Atoi(match[1])
+		noteIDs = append(noteIDs, noteID)
+	}
+
+	sort.Ints(noteIDs)
+
+	return noteIDs, nil
+}
+
+// commit records changes, the new content of files by name or nil for a
+// removed file, on top of the tree of HEAD. Everything else in the
+// repository, other tracked files as well as hand edits in the working
+// tree, is left as it is.
+func (s *GitStore) commit(message string, changes map[string][]byte) error {
+	parent, err := s.headCommit()
+	if err != nil {
+		return err
+	}
+
+	var entries []treeEntry
+	if parent != "" {
+		head, err := s.readCommit(parent)
+		if err != nil {
+			return err
+		}
+
+		headEntries, err := s.readTree(head.tree)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range headEntries {
+			if _, changed := changes[entry.name]; !changed {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	for name, content := range changes {
+		if content == nil {
+			continue
+		}
+
+		hash, err := s.writeObject("blob", content)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, treeEntry{mode: FILE_MODE, name: name, hash: hash})
+	}
+
+	tree, err := s.writeTree(entries)
+	if err != nil {
+		return err
+	}
+
+	commit, err := s.writeCommit(tree, parent, message)
+	if err != nil {
+		return err
+	}
+
+	err = s.updateHead(commit)
+	if err != nil {
+		return err
+	}
+
+	files, err := s.treeFiles(tree, "")
+	if err != nil {
+		return err
+	}
+
+	// The files just written match their blobs, their stat data lets git
+	// skip reading them again.
+	for i := range files {
+		if changes[files[i].name] == nil {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(s.directory, files[i].name))
+		if err != nil {
+			return err
+		}
+		files[i].size = info.Size()
+		files[i].date = info.ModTime()
+	}
+
+	return s.writeIndex(files)
+}
+
+// nextNoteID returns the id the next note gets: the one in NEXT_ID_FILE, or
+// one above the highest note id if that is higher, e.g. in a repository
+// written before the file was kept.
+func (s *GitStore) nextNoteID() (int, error) {
+	noteIDs, err := s.noteIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	var next int = 1
+	if len(noteIDs) > 0 {
+		next = noteIDs[len(noteIDs)-1] + 1
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(s.directory, NEXT_ID_FILE))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if counter, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil && counter > next {
+		next = counter
+	}
+
+	return next, nil
+}
+
+// writeNote writes and commits a note along with the other changes, see
+// commit.
+func (s *GitStore) writeNote(n note.Note, message string, changes map[string][]byte) error {
+	var content bytes.Buffer
+
+	err := export.WriteMarkdown(&content, n)
+	if err != nil {
+		return err
+	}
+
+	var path string = s.notePath(n.NoteID())
+
+	err = writeFileAtomic(path, content.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	if changes == nil {
+		changes = make(map[string][]byte)
+	}
+	changes[filepath.Base(path)] = content.Bytes()
+
+	err = s.commit(message, changes)
+	if err != nil {
+		slog.Error("Committing note.", "error", err)
+	}
+
+	return err
+}
+
+func (s *GitStore) AddNote(ctx context.Context, n note.Note) error {
+	_, err := s.CreateNote(ctx, n)
+	return err
+}
+
+// CreateNote adds a note like AddNote and returns the id it was stored
+// under. A note that already has an id keeps it.
+func (s *GitStore) CreateNote(ctx context.Context, n note.Note) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var noteID int = n.NoteID()
+
+	next, err := s.nextNoteID()
+	if err != nil {
+		return 0, err
+	}
+
+	if noteID > 0 {
+		if _, err := os.Stat(s.notePath(noteID)); err == nil {
+			return 0, fmt.Errorf("Note %d already exists.", noteID)
+		}
+	} else {
+		noteID = next
+	}
+	if noteID >= next {
+		next = noteID + 1
+	}
+
+	var counter []byte = []byte(strconv.Itoa(next) + "\n")
+	err = writeFileAtomic(filepath.Join(s.directory, NEXT_ID_FILE), counter, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	var created note.Note = note.NewLocal(noteID, n.Title(), n.Text(), n.AddDate(), n.ChangeDate(), 1, n.Tags())
+
+	err = s.writeNote(created, fmt.Sprintf("Add note %d: %s", noteID, n.Title()), map[string][]byte{NEXT_ID_FILE: counter})
+	if err != nil {
+		return 0, err
+	}
+
+	s.broker.Publish(events.Event{Type: events.NOTE_CREATED, NoteID: noteID, ChangeDate: n.ChangeDate()})
+
+	return noteID, nil
+}
+
+// UpdateNote saves a note if n.Version() is still the current version, like
+// the SQLite storage does.
+func (s *GitStore) UpdateNote(ctx context.Context, n note.Note) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, err := s.readNote(n.NoteID())
+	if err != nil {
+		return err
+	}
+
+	if current.Version() != n.Version() {
+		return manager.ErrVersionConflict
+	}
+
+	var updated note.Note = note.NewLocal(n.NoteID(), n.Title(), n.Text(), current.AddDate(), n.ChangeDate(), current.Version()+1, n.Tags())
+
+	err = s.writeNote(updated, fmt.Sprintf("Update note %d: %s", n.NoteID(), n.Title()), nil)
+	if err != nil {
+		return err
+	}
+
+	s.broker.Publish(events.Event{Type: events.NOTE_UPDATED, NoteID: n.NoteID(), ChangeDate: n.ChangeDate()})
+
+	return nil
+}
+
+// DeleteNote deletes a note if version is still its current version.
+func (s *GitStore) DeleteNote(ctx context.Context, noteID int, version int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, err := s.readNote(noteID)
+	if err != nil {
+		return err
+	}
+
+	if current.Version() != version {
+		return manager.ErrVersionConflict
+	}
+
+	var path string = s.notePath(noteID)
+
+	err = os.Remove(path)
+	if err != nil {
+		return err
+	}
+
+	err = s.commit(fmt.Sprintf("Delete note %d: %s", noteID, current.Title()), map[string][]byte{filepath.Base(path): nil})
+	if err != nil {
+		slog.Error("Committing note.", "error", err)
+		return err
+	}
+
+	s.broker.Publish(events.Event{Type: events.NOTE_DELETED, NoteID: noteID, ChangeDate: time.Now()})
+
+	return nil
+}
+
+func (s *GitStore) GetNote(ctx context.Context, noteID int) (note.Note, error) {
+	return s.readNote(noteID)
+}
+
+func (s *GitStore) LoadNotes(ctx context.Context) ([]note.Note, error) {
+	var notes []note.Note
+
+	err := s.EachNote(ctx, func(n note.Note) error {
+		notes = append(notes, n)
+		return nil
+	})
+
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].ChangeDate().After(notes[j].ChangeDate()) })
+
+	return notes, err
+}
+
+// ListNotes reads every note, the files hold no index to page by.
+func (s *GitStore) ListNotes(ctx context.Context, query manager.PageQuery) (manager.NotePage, error) {
+	var summaries []manager.NoteSummary
+
+	err := s.EachNote(ctx, func(n note.Note) error {
+		summaries = append(summaries, manager.Summarize(n))
+		return nil
+	})
+	if err != nil {
+		return manager.NotePage{}, err
+	}
+
+	return manager.PageOf(summaries, query), nil
+}
+
+// EachNote calls function for every note in the order of their ids. Files
+// that cannot be read, e.g. after a bad merge, are logged and skipped.
+func (s *GitStore) EachNote(ctx context.Context, function func(note.Note) error) error {
+	noteIDs, err := s.noteIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, noteID := range noteIDs {
+		n, err := s.readNote(noteID)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			slog.Error("Reading note file.", "error", err)
+			continue
+		}
+
+		err = function(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRevision looks for the version of a note in the history of the
+// current branch.
+func (s *GitStore) GetRevision(ctx context.Context, noteID int, version int) (note.Note, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var name string = filepath.Base(s.notePath(noteID))
+
+	hash, err := s.headCommit()
+	for err == nil && hash != "" {
+		var commit commitObject
+		commit, err = s.readCommit(hash)
+		if err != nil {
+			break
+		}
+
+		var tree []treeEntry
+		tree, err = s.readTree(commit.tree)
+		if err != nil {
+			break
+		}
+
+		var blob string
+		for _, entry := range tree {
+			if entry.name == name {
+				blob = entry.hash
+			}
+		}
+
+		if blob != "" {
+			var content []byte
+			_, content, err = s.readObject(blob)
+			if err != nil {
+				break
+			}
+
+			n, parseErr := parseNote(noteID, content)
+			if parseErr == nil && n.Version() == version {
+				return n, nil
+			}
+			if parseErr == nil && n.Version() < version {
+				break
+			}
+		}
+
+		hash = ""
+		if len(commit.parents) > 0 {
+			hash = commit.parents[0]
+		}
+	}
+
+	if err != nil {
+		slog.Error("Reading note history.", "error", err)
+		return note.Note{}, err
+	}
+
+	return note.Note{}, sql.ErrNoRows
+}
+
+func (s *GitStore) FilterNotes(ctx context.Context, filter manager.NoteFilter) ([]note.Note, error) {
+	return manager.FilterNotes(ctx, filter, s.EachNote)
+}
+
+func (s *GitStore) Changes(ctx context.Context, since int64, limit int) ([]manager.Change, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *GitStore) ApplySyncItem(ctx context.Context, item manager.SyncItem) (manager.SyncResult, error) {
+	return manager.SyncResult{}, ErrNotSupported
+}
+
+// Leases only live in memory, they do not belong into the history.
+func (s *GitStore) GetLease(ctx context.Context, noteID int) (*manager.Lease, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	lease, found := s.leases[noteID]
+	if !found || !lease.ExpiryDate.After(time.Now()) {
+		return nil, nil
+	}
+
+	return &lease, nil
+}
+
+func (s *GitStore) AcquireLease(ctx context.Context, noteID int, holder string, holderName string, duration time.Duration) (manager.Lease, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var now time.Time = time.Now()
+	var lease manager.Lease = manager.Lease{NoteID: noteID, Holder: holder, HolderName: holderName, AcquireDate: now, ExpiryDate: now.Add(duration)}
+
+	current, found := s.leases[noteID]
+	if found && current.ExpiryDate.After(now) {
+		if current.Holder != holder {
+			return current, manager.ErrLeaseHeld
+		}
+		lease.AcquireDate = current.AcquireDate
+	}
+
+	s.leases[noteID] = lease
+
+	return lease, nil
+}
+
+func (s *GitStore) ReleaseLease(ctx context.Context, noteID int, holder string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if lease, found := s.leases[noteID]; found && lease.Holder == holder {
+		delete(s.leases, noteID)
+	}
+
+	return nil
+}
+
+func (s *GitStore) BreakLease(ctx context.Context, noteID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.leases, noteID)
+
+	return nil
+}
+
+func (s *GitStore) SweepLeases(ctx context.Context) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var swept int64 = 0
+	var now time.Time = time.Now()
+
+	for noteID, lease := range s.leases {
+		if !lease.ExpiryDate.After(now) {
+			delete(s.leases, noteID)
+			swept++
+		}
+	}
+
+	return swept, nil
+}
@@ -0,0 +1,232 @@
+package gitstore
+
+import (
+	"context"
+	"database/manager"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"note"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func openTestStore(t *testing.T) *GitStore {
+	var s *GitStore = New(filepath.Join(t.TempDir(), "notes"))
+
+	err := s.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// runGit runs git in the repository of the store, or skips the test if git
+// is not installed.
+func runGit(t *testing.T, s *GitStore, arguments ...string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	var command *exec.Cmd = exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@localhost"}, arguments...)...)
+	command.Dir = s.directory
+
+	output, err := command.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(arguments, " "), err, output)
+	}
+	return string(output)
+}
+
+func describeNotes(t *testing.T, s *GitStore) []string {
+	notes, err := s.LoadNotes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var described []string
+	for _, n := range notes {
+		described = append(described, fmt.Sprintf("%d %s v%d %s", n.NoteID(), n.Title(), n.Version(), strings.TrimSpace(n.Text())))
+	}
+	sort.Strings(described)
+	return described
+}
+
+func updateText(ctx context.Context, s *GitStore, noteID int, text string) error {
+	current, err := s.GetNote(ctx, noteID)
+	if err != nil {
+		return err
+	}
+	current.SetText(text)
+	return s.UpdateNote(ctx, current)
+}
+
+func TestGitStore(t *testing.T) {
+	var tests = []struct {
+		name      string
+		operation func(ctx context.Context, s *GitStore) error
+		notes     []string
+	}{
+		{"create", func(ctx context.Context, s *GitStore) error {
+			_, err := s.CreateNote(ctx, note.New("first", "text"))
+			return err
+		}, []string{"1 first v1 text"}},
+		{"update", func(ctx context.Context, s *GitStore) error {
+			noteID, err := s.CreateNote(ctx, note.New("first", "text"))
+			if err != nil {
+				return err
+			}
+			return updateText(ctx, s, noteID, "edited")
+		}, []string{"1 first v2 edited"}},
+		{"stale update", func(ctx context.Context, s *GitStore) error {
+			noteID, err := s.CreateNote(ctx, note.New("first", "text"))
+			if err != nil {
+				return err
+			}
+			stale, err := s.GetNote(ctx, noteID)
+			if err != nil {
+				return err
+			}
+			if err = updateText(ctx, s, noteID, "edited"); err != nil {
+				return err
+			}
+			stale.SetText("stale")
+			if err = s.UpdateNote(ctx, stale); err != manager.ErrVersionConflict {
+				return fmt.Errorf("stale update: %v, expected %v", err, manager.ErrVersionConflict)
+			}
+			return nil
+		}, []string{"1 first v2 edited"}},
+		{"ids are not reused", func(ctx context.Context, s *GitStore) error {
+			for _, title := range []string{"first", "second"} {
+				if _, err := s.CreateNote(ctx, note.New(title, "text")); err != nil {
+					return err
+				}
+			}
+			if err := s.DeleteNote(ctx, 2, 1); err != nil {
+				return err
+			}
+			_, err := s.CreateNote(ctx, note.New("third", "text"))
+			return err
+		}, []string{"1 first v1 text", "3 third v1 text"}},
+		{"stale delete", func(ctx context.Context, s *GitStore) error {
+			noteID, err := s.CreateNote(ctx, note.New("first", "text"))
+			if err != nil {
+				return err
+			}
+			if err = updateText(ctx, s, noteID, "edited"); err != nil {
+				return err
+			}
+			if err = s.DeleteNote(ctx, noteID, 1); err != manager.ErrVersionConflict {
+				return fmt.Errorf("stale delete: %v, expected %v", err, manager.ErrVersionConflict)
+			}
+			return nil
+		}, []string{"1 first v2 edited"}},
+	}
+
+	for _, test := range tests {
+		var ctx context.Context = context.Background()
+		var s *GitStore = openTestStore(t)
+
+		if err := test.operation(ctx, s); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		var notes []string = describeNotes(t, s)
+		if strings.Join(notes, "|") != strings.Join(test.notes, "|") {
+			t.Errorf("%s: notes %q, expected %q", test.name, notes, test.notes)
+		}
+
+		// The objects, trees and the index the store wrote are what git
+		// itself would have written.
+		runGit(t, s, "fsck", "--strict", "--no-dangling")
+		if status := runGit(t, s, "status", "--porcelain"); status != "" {
+			t.Errorf("%s: git status %q, expected a clean working tree", test.name, status)
+		}
+	}
+}
+
+// TestRevisions reads older versions back from the history, before and
+// after git moved the objects into a pack.
+func TestRevisions(t *testing.T) {
+	var ctx context.Context = context.Background()
+	var s *GitStore = openTestStore(t)
+
+	noteID, err := s.CreateNote(ctx, note.New("first", "version 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version := 2; version <= 4; version++ {
+		if err = updateText(ctx, s, noteID, fmt.Sprintf("version %d", version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(when string) {
+		for version := 1; version <= 4; version++ {
+			revision, err := s.GetRevision(ctx, noteID, version)
+			if err != nil {
+				t.Errorf("%s: revision %d: %s", when, version, err)
+				continue
+			}
+			if text := strings.TrimSpace(revision.Text()); text != fmt.Sprintf("version %d", version) {
+				t.Errorf("%s: revision %d reads %q", when, version, text)
+			}
+		}
+		if _, err := s.GetRevision(ctx, noteID, 5); err != sql.ErrNoRows {
+			t.Errorf("%s: revision 5: %v, expected %v", when, err, sql.ErrNoRows)
+		}
+	}
+
+	check("loose objects")
+
+	runGit(t, s, "gc", "--aggressive", "--prune=now")
+	check("packed objects")
+
+	// Writing on top of a packed history keeps the repository whole.
+	if err = updateText(ctx, s, noteID, "version 5"); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, s, "fsck", "--strict", "--no-dangling")
+}
+
+// TestForeignFiles keeps files that were committed with git next to the
+// notes, and the edits to them that are not committed yet.
+func TestForeignFiles(t *testing.T) {
+	var ctx context.Context = context.Background()
+	var s *GitStore = openTestStore(t)
+
+	if _, err := s.CreateNote(ctx, note.New("first", "text")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(s.directory, "README.md"), []byte("committed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, s, "add", "README.md")
+	runGit(t, s, "commit", "-q", "-m", "Add a README")
+
+	if err := ioutil.WriteFile(filepath.Join(s.directory, "README.md"), []byte("edited by hand\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.CreateNote(ctx, note.New("second", "text")); err != nil {
+		t.Fatal(err)
+	}
+
+	var files string = runGit(t, s, "ls-tree", "-r", "--name-only", "HEAD")
+	for _, name := range []string{"1.md", "2.md", "README.md"} {
+		if !strings.Contains(files, name+"\n") {
+			t.Errorf("%s is not in the last commit:\n%s", name, files)
+		}
+	}
+
+	if status := runGit(t, s, "status", "--porcelain"); status != " M README.md\n" {
+		t.Errorf("git status %q, expected only the hand edit", status)
+	}
+	runGit(t, s, "fsck", "--strict", "--no-dangling")
+}
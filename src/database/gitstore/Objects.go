@@ -0,0 +1,396 @@
+package gitstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_BRANCH = "master"
+
+const GIT_CONFIG = "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n"
+
+const FILE_MODE = "100644"
+const TREE_MODE = "40000"
+
+type treeEntry struct {
+	mode string
+	name string
+	hash string
+	size int64
+	date time.Time
+}
+
+type commitObject struct {
+	tree    string
+	parents []string
+	date    time.Time
+	message string
+}
+
+func (s *GitStore) gitPath(elements ...string) string {
+	return filepath.Join(append([]string{s.directory, ".git"}, elements...)...)
+}
+
+func (s *GitStore) initRepository() error {
+	for _, directory := range []string{"objects", "refs/heads", "refs/tags"} {
+		err := os.MkdirAll(s.gitPath(directory), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := ioutil.WriteFile(s.gitPath("HEAD"), []byte("ref: refs/heads/"+DEFAULT_BRANCH+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.gitPath("config"), []byte(GIT_CONFIG), 0644)
+}
+
+func (s *GitStore) writeObject(kind string, content []byte) (string, error) {
+	var header string = fmt.Sprintf("%s %d\x00", kind, len(content))
+
+	var hasher = sha1.New()
+	hasher.Write([]byte(header))
+	hasher.Write(content)
+	var hash string = hex.EncodeToString(hasher.Sum(nil))
+
+	var path string = s.gitPath("objects", hash[:2], hash[2:])
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	var compressed bytes.Buffer
+	var writer *zlib.Writer = zlib.NewWriter(&compressed)
+	writer.Write([]byte(header))
+	writer.Write(content)
+	err := writer.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	// Objects are read-only in git.
+	return hash, writeFileAtomic(path, compressed.Bytes(), 0444)
+}
+
+func (s *GitStore) readObject(hash string) (string, []byte, error) {
+	if len(hash) != 40 {
+		return "", nil, fmt.Errorf("Invalid object id %q.", hash)
+	}
+
+	// Every object this package writes is loose, git may have packed them
+	// since.
+	file, err := os.Open(s.gitPath("objects", hash[:2], hash[2:]))
+	if os.IsNotExist(err) {
+		return s.readPacked(hash)
+	} else if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	reader, err := zlib.NewReader(file)
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var position int = bytes.IndexByte(content, 0)
+	if position < 0 {
+		return "", nil, fmt.Errorf("Object %s has no header.", hash)
+	}
+
+	var kind string = strings.SplitN(string(content[:position]), " ", 2)[0]
+
+	return kind, content[position+1:], nil
+}
+
+// treeOrder is the name git sorts a tree entry by: subtrees sort as if their
+// name ended in a slash.
+func treeOrder(entry treeEntry) string {
+	if entry.mode == TREE_MODE {
+		return entry.name + "/"
+	}
+	return entry.name
+}
+
+func (s *GitStore) writeTree(entries []treeEntry) (string, error) {
+	sort.Slice(entries, func(i, j int) bool { return treeOrder(entries[i]) < treeOrder(entries[j]) })
+
+	var content bytes.Buffer
+	for _, entry := range entries {
+		raw, err := hex.DecodeString(entry.hash)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&content, "%s %s\x00", entry.mode, entry.name)
+		content.Write(raw)
+	}
+
+	return s.writeObject("tree", content.Bytes())
+}
+
+// readTree returns the entries of a tree in the order git stored them.
+func (s *GitStore) readTree(hash string) ([]treeEntry, error) {
+	kind, content, err := s.readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "tree" {
+		return nil, fmt.Errorf("Object %s is a %s, not a tree.", hash, kind)
+	}
+
+	var entries []treeEntry
+
+	for len(content) > 0 {
+		var position int = bytes.IndexByte(content, 0)
+		if position < 0 || len(content) < position+21 {
+			return nil, fmt.Errorf("Tree %s is truncated.", hash)
+		}
+
+		var modeAndName []string = strings.SplitN(string(content[:position]), " ", 2)
+		if len(modeAndName) == 2 {
+			entries = append(entries, treeEntry{mode: modeAndName[0], name: modeAndName[1], hash: hex.EncodeToString(content[position+1 : position+21])})
+		}
+
+		content = content[position+21:]
+	}
+
+	return entries, nil
+}
+
+// treeFiles lists every file below a tree with its path from the top of the
+// repository, the way the index holds them.
+func (s *GitStore) treeFiles(hash string, prefix string) ([]treeEntry, error) {
+	entries, err := s.readTree(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []treeEntry
+	for _, entry := range entries {
+		entry.name = prefix + entry.name
+
+		if entry.mode != TREE_MODE {
+			files = append(files, entry)
+			continue
+		}
+
+		subtree, err := s.treeFiles(entry.hash, entry.name+"/")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subtree...)
+	}
+
+	return files, nil
+}
+
+func (s *GitStore) writeCommit(tree string, parent string, message string) (string, error) {
+	var now time.Time = time.Now()
+	var signature string = fmt.Sprintf("%s <%s> %d %s", s.authorName, s.authorEmail, now.Unix(), now.Format("-0700"))
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "tree %s\n", tree)
+	if parent != "" {
+		fmt.Fprintf(&content, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&content, "author %s\ncommitter %s\n\n%s\n", signature, signature, message)
+
+	return s.writeObject("commit", content.Bytes())
+}
+
+func (s *GitStore) readCommit(hash string) (commitObject, error) {
+	var commit commitObject
+
+	kind, content, err := s.readObject(hash)
+	if err != nil {
+		return commit, err
+	}
+	if kind != "commit" {
+		return commit, fmt.Errorf("Object %s is a %s, not a commit.", hash, kind)
+	}
+
+	var parts []string = strings.SplitN(string(content), "\n\n", 2)
+	if len(parts) == 2 {
+		commit.message = parts[1]
+	}
+
+	for _, line := range strings.Split(parts[0], "\n") {
+		var fields []string = strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "tree":
+			commit.tree = fields[1]
+		case "parent":
+			commit.parents = append(commit.parents, fields[1])
+		case "committer":
+			if len(fields) >= 3 {
+				seconds, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+				if err == nil {
+					commit.date = time.Unix(seconds, 0)
+				}
+			}
+		}
+	}
+
+	return commit, nil
+}
+
+// headRef returns the branch HEAD points to, e.g. "refs/heads/master".
+func (s *GitStore) headRef() (string, error) {
+	content, err := ioutil.ReadFile(s.gitPath("HEAD"))
+	if err != nil {
+		return "", err
+	}
+
+	var head string = strings.TrimSpace(string(content))
+	if !strings.HasPrefix(head, "ref: ") {
+		return "", errors.New("HEAD is detached, check out a branch first.")
+	}
+
+	return strings.TrimPrefix(head, "ref: "), nil
+}
+
+// headCommit returns the commit HEAD points to, or "" in an empty
+// repository. Branches packed by git are looked up in packed-refs.
+func (s *GitStore) headCommit() (string, error) {
+	ref, err := s.headRef()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(s.gitPath(filepath.FromSlash(ref)))
+	if err == nil {
+		return strings.TrimSpace(string(content)), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	packed, err := os.Open(s.gitPath("packed-refs"))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer packed.Close()
+
+	var scanner *bufio.Scanner = bufio.NewScanner(packed)
+	for scanner.Scan() {
+		var fields []string = strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+func (s *GitStore) updateHead(commit string) error {
+	ref, err := s.headRef()
+	if err != nil {
+		return err
+	}
+
+	var path string = s.gitPath(filepath.FromSlash(ref))
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, []byte(commit+"\n"), 0644)
+}
+
+// writeIndex makes the staging area match the commit that was just made,
+// so "git status" in the directory stays clean. Stat fields git does not
+// get from here are zero, git compares the content for those entries. That
+// includes every file but the ones just written, so hand edits still show
+// as changes.
+func (s *GitStore) writeIndex(entries []treeEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	var content bytes.Buffer
+	content.WriteString("DIRC")
+	binary.Write(&content, binary.BigEndian, uint32(2))
+	binary.Write(&content, binary.BigEndian, uint32(len(entries)))
+
+	for _, entry := range entries {
+		raw, err := hex.DecodeString(entry.hash)
+		if err != nil {
+			return err
+		}
+
+		mode, err := strconv.ParseUint(entry.mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid file mode %q of %s.", entry.mode, entry.name)
+		}
+
+		var modified [2]uint32
+		if !entry.date.IsZero() {
+			modified = [2]uint32{uint32(entry.date.Unix()), uint32(entry.date.Nanosecond())}
+		}
+
+		var start int = content.Len()
+		binary.Write(&content, binary.BigEndian, []uint32{
+			0, 0,
+			modified[0], modified[1],
+			0, 0,
+			uint32(mode),
+			0, 0,
+			uint32(entry.size)})
+		content.Write(raw)
+
+		var flags int = len(entry.name)
+		if flags > 0xfff {
+			flags = 0xfff
+		}
+		binary.Write(&content, binary.BigEndian, uint16(flags))
+		content.WriteString(entry.name)
+
+		// Entries are padded with one to eight NUL bytes.
+		var padding int = 8 - (content.Len()-start)%8
+		content.Write(make([]byte, padding))
+	}
+
+	var checksum [20]byte = sha1.Sum(content.Bytes())
+	content.Write(checksum[:])
+
+	return writeFileAtomic(s.gitPath("index"), content.Bytes(), 0644)
+}
+
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	var temporary string = path + ".lock"
+
+	err := ioutil.WriteFile(temporary, content, perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path)
+}
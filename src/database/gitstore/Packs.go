@@ -0,0 +1,337 @@
+package gitstore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Objects that "git gc", "git repack" or a fetch moved into pack files are
+// read from there. Only version 2 pack indexes are understood, which is all
+// git has written since 2007.
+
+const PACK_INDEX_MAGIC = "\377tOc"
+
+const (
+	PACK_COMMIT    = 1
+	PACK_TREE      = 2
+	PACK_BLOB      = 3
+	PACK_TAG       = 4
+	PACK_OFS_DELTA = 6
+	PACK_REF_DELTA = 7
+)
+
+// Delta chains in packs written by git are at most 50 deep by default.
+const MAX_DELTA_DEPTH = 1000
+
+var packKinds = map[int]string{PACK_COMMIT: "commit", PACK_TREE: "tree", PACK_BLOB: "blob", PACK_TAG: "tag"}
+
+var ErrNotFound = errors.New("The git object does not exist.")
+
+type packIndex struct {
+	fanout  [256]uint32
+	hashes  []byte
+	offsets []uint32
+	large   []byte
+}
+
+func readPackIndex(path string) (*packIndex, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < 8+256*4 || string(content[:4]) != PACK_INDEX_MAGIC || binary.BigEndian.Uint32(content[4:8]) != 2 {
+		return nil, fmt.Errorf("%s is not a version 2 pack index.", path)
+	}
+
+	var index *packIndex = &packIndex{}
+	for i := range index.fanout {
+		index.fanout[i] = binary.BigEndian.Uint32(content[8+i*4:])
+	}
+
+	var count int = int(index.fanout[255])
+	var hashesStart int = 8 + 256*4
+	var offsetsStart int = hashesStart + count*20 + count*4
+	var largeStart int = offsetsStart + count*4
+
+	if len(content) < largeStart+40 {
+		return nil, fmt.Errorf("Pack index %s is truncated.", path)
+	}
+
+	index.hashes = content[hashesStart : hashesStart+count*20]
+	index.offsets = make([]uint32, count)
+	for i := range index.offsets {
+		index.offsets[i] = binary.BigEndian.Uint32(content[offsetsStart+i*4:])
+	}
+	index.large = content[largeStart : len(content)-40]
+
+	return index, nil
+}
+
+// offset returns where the object with the raw hash starts in the pack.
+func (index *packIndex) offset(raw []byte) (int64, bool) {
+	var low int = 0
+	if raw[0] > 0 {
+		low = int(index.fanout[raw[0]-1])
+	}
+	var high int = int(index.fanout[raw[0]])
+
+	var position int = low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(index.hashes[(low+i)*20:(low+i+1)*20], raw) >= 0
+	})
+	if position >= high || !bytes.Equal(index.hashes[position*20:(position+1)*20], raw) {
+		return 0, false
+	}
+
+	var offset uint32 = index.offsets[position]
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+
+	var large int = int(offset&0x7fffffff) * 8
+	if large+8 > len(index.large) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(index.large[large:])), true
+}
+
+// packIndexes returns the indexes of the pack files, parsed once per pack.
+// Packs never change, "git gc" writes new ones under new names.
+func (s *GitStore) packIndexes() (map[string]*packIndex, error) {
+	paths, err := filepath.Glob(s.gitPath("objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.packMutex.Lock()
+	defer s.packMutex.Unlock()
+
+	var indexes map[string]*packIndex = make(map[string]*packIndex)
+	for _, path := range paths {
+		index, found := s.packs[path]
+		if !found {
+			index, err = readPackIndex(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		indexes[path] = index
+	}
+	s.packs = indexes
+
+	return indexes, nil
+}
+
+func (s *GitStore) readPacked(hash string) (string, []byte, error) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid object id %q.", hash)
+	}
+
+	indexes, err := s.packIndexes()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for path, index := range indexes {
+		offset, found := index.offset(raw)
+		if !found {
+			continue
+		}
+
+		pack, err := os.Open(strings.TrimSuffix(path, ".idx") + ".pack")
+		if err != nil {
+			return "", nil, err
+		}
+		defer pack.Close()
+
+		kind, content, err := s.readPackEntry(pack, offset, 0)
+		if err != nil {
+			return "", nil, fmt.Errorf("Reading object %s from %s: %s", hash, filepath.Base(path), err)
+		}
+
+		return packKinds[kind], content, nil
+	}
+
+	return "", nil, ErrNotFound
+}
+
+// readPackEntry reads the object at offset, applying deltas to their base.
+func (s *GitStore) readPackEntry(pack *os.File, offset int64, depth int) (int, []byte, error) {
+	if depth > MAX_DELTA_DEPTH {
+		return 0, nil, errors.New("The delta chain is too deep.")
+	}
+
+	var header [32]byte
+	read, err := pack.ReadAt(header[:], offset)
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+
+	var position int = 0
+	var next = func() (byte, error) {
+		if position >= read {
+			return 0, errors.New("The object header is truncated.")
+		}
+		position++
+		return header[position-1], nil
+	}
+
+	current, err := next()
+	if err != nil {
+		return 0, nil, err
+	}
+	var kind int = int(current>>4) & 7
+	var size int64 = int64(current & 0x0f)
+	for shift := uint(4); current&0x80 != 0; shift += 7 {
+		current, err = next()
+		if err != nil {
+			return 0, nil, err
+		}
+		size |= int64(current&0x7f) << shift
+	}
+
+	var baseOffset int64
+	var baseHash string
+
+	switch kind {
+	case PACK_OFS_DELTA:
+		current, err = next()
+		if err != nil {
+			return 0, nil, err
+		}
+		var distance int64 = int64(current & 0x7f)
+		for current&0x80 != 0 {
+			current, err = next()
+			if err != nil {
+				return 0, nil, err
+			}
+			distance = ((distance + 1) << 7) | int64(current&0x7f)
+		}
+		baseOffset = offset - distance
+	case PACK_REF_DELTA:
+		if position+20 > read {
+			return 0, nil, errors.New("The object header is truncated.")
+		}
+		baseHash = hex.EncodeToString(header[position : position+20])
+		position += 20
+	case PACK_COMMIT, PACK_TREE, PACK_BLOB, PACK_TAG:
+	default:
+		return 0, nil, fmt.Errorf("Unknown object type %d.", kind)
+	}
+
+	reader, err := zlib.NewReader(io.NewSectionReader(pack, offset+int64(position), 1<<62))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer reader.Close()
+
+	var content []byte = make([]byte, size)
+	_, err = io.ReadFull(reader, content)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var base []byte
+	switch kind {
+	case PACK_OFS_DELTA:
+		kind, base, err = s.readPackEntry(pack, baseOffset, depth+1)
+	case PACK_REF_DELTA:
+		var baseKind string
+		baseKind, base, err = s.readObject(baseHash)
+		for number, name := range packKinds {
+			if name == baseKind {
+				kind = number
+			}
+		}
+	default:
+		return kind, content, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	content, err = applyDelta(base, content)
+	return kind, content, err
+}
+
+func deltaSize(delta []byte) (int, []byte) {
+	var size int = 0
+	var shift uint = 0
+	for len(delta) > 0 {
+		var current byte = delta[0]
+		delta = delta[1:]
+		size |= int(current&0x7f) << shift
+		shift += 7
+		if current&0x80 == 0 {
+			break
+		}
+	}
+	return size, delta
+}
+
+// applyDelta rebuilds an object from its base and a delta of copy and
+// insert instructions, see "git help format-pack".
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	var baseSize, resultSize int
+	baseSize, delta = deltaSize(delta)
+	resultSize, delta = deltaSize(delta)
+
+	if baseSize != len(base) {
+		return nil, errors.New("The delta does not fit its base.")
+	}
+
+	var result []byte = make([]byte, 0, resultSize)
+	for len(delta) > 0 {
+		var instruction byte = delta[0]
+		delta = delta[1:]
+
+		if instruction&0x80 == 0 {
+			if instruction == 0 || int(instruction) > len(delta) {
+				return nil, errors.New("Invalid delta instruction.")
+			}
+			result = append(result, delta[:instruction]...)
+			delta = delta[instruction:]
+			continue
+		}
+
+		var offset, size int
+		for bit := uint(0); bit < 7; bit++ {
+			if instruction&(1<<bit) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errors.New("The delta is truncated.")
+			}
+			if bit < 4 {
+				offset |= int(delta[0]) << (8 * bit)
+			} else {
+				size |= int(delta[0]) << (8 * (bit - 4))
+			}
+			delta = delta[1:]
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > len(base) {
+			return nil, errors.New("The delta copies beyond its base.")
+		}
+		result = append(result, base[offset:offset+size]...)
+	}
+
+	if len(result) != resultSize {
+		return nil, errors.New("The delta does not produce the expected size.")
+	}
+
+	return result, nil
+}
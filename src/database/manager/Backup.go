@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Pages copied per backup step. Between steps the database is unlocked, so
+// writes go on while a backup runs. A write restarts the copy of the pages
+// that are left.
+const BACKUP_STEP_PAGES = 256
+const BACKUP_STEP_PAUSE = 10 * time.Millisecond
+
+func rawSQLiteConnection(connection *sql.Conn, function func(*sqlite3.SQLiteConn) error) error {
+	return connection.Raw(func(driverConnection interface{}) error {
+		sqliteConnection, isSQLite := driverConnection.(*sqlite3.SQLiteConn)
+		if !isSQLite {
+			return errors.New("The database connection is not a SQLite connection.")
+		}
+		return function(sqliteConnection)
+	})
+}
+
+// Backup copies the live database into a new SQLite file at path with the
+// online backup API of SQLite.
+func (dbm *DatabaseManager) Backup(ctx context.Context, path string) error {
+	defer dbm.observe("Backup", time.Now())
+
+	os.Remove(path)
+
+	destination, err := sql.Open("sqlite3", path)
+	if err != nil {
+		dbm.log().Error("Opening backup file.", "error", err)
+		return err
+	}
+	defer destination.Close()
+
+	destinationConnection, err := destination.Conn(ctx)
+	if err != nil {
+		dbm.log().Error("Connecting to backup file.", "error", err)
+		return err
+	}
+	defer destinationConnection.Close()
+
+	sourceConnection, err := dbm.db.Conn(ctx)
+	if err != nil {
+		dbm.log().Error("Connecting to database for backup.", "error", err)
+		return err
+	}
+	defer sourceConnection.Close()
+
+	return rawSQLiteConnection(destinationConnection, func(destinationSQLite *sqlite3.SQLiteConn) error {
+		return rawSQLiteConnection(sourceConnection, func(sourceSQLite *sqlite3.SQLiteConn) error {
+			backup, err := destinationSQLite.Backup("main", sourceSQLite, "main")
+			if err != nil {
+				dbm.log().Error("Starting backup.", "error", err)
+				return err
+			}
+
+			for {
+				done, err := backup.Step(BACKUP_STEP_PAGES)
+				if err != nil {
+					backup.Close()
+					dbm.log().Error("Backup step.", "error", err)
+					return err
+				}
+				if done {
+					break
+				}
+				time.Sleep(BACKUP_STEP_PAUSE)
+			}
+
+			err = backup.Close()
+			if err != nil {
+				dbm.log().Error("Finishing backup.", "error", err)
+			}
+			return err
+		})
+	})
+}
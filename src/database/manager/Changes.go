@@ -0,0 +1,280 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"events"
+	"note"
+	"time"
+)
+
+const SELECT_CHANGES_QS = `select sequence, noteID, title, text, addDate, changeDate, version, tags, 0
+     from notes
+     where sequence > ?
+     union all
+     select sequence, noteID, '', '', 0, deleteDate, 0, '', 1
+     from tombstones
+     where sequence > ?
+     order by 1
+     limit ?`
+
+const LOOKUP_NOTE_CHANGE_QS = `select sequence, title, text, addDate, changeDate, version, tags
+     from notes
+     where noteID = ?`
+
+const LOOKUP_TOMBSTONE_QS = `select sequence, deleteDate
+     from tombstones
+     where noteID = ?`
+
+const LOOKUP_SYNC_CLIENT_ID_QS = `select noteID, sequence
+     from syncClientIDs
+     where clientID = ?`
+
+const ADD_SYNC_CLIENT_ID_EXEC = `insert into syncClientIDs(clientID, noteID, sequence, createDate) 
+     values(?, ?, ?, ?);`
+
+const SYNC_CREATED = "created"
+const SYNC_UPDATED = "updated"
+const SYNC_DELETED = "deleted"
+const SYNC_CONFLICT = "conflict"
+const SYNC_NOT_FOUND = "not_found"
+
+type Change struct {
+	Sequence int64
+	Deleted  bool
+	Note     note.Note
+}
+
+// A SyncItem is an edit a device made while offline. BaseSequence is the
+// sequence of the note the device last saw; a note id of 0 creates a note.
+// A create that carries a ClientID is only applied once, sending it again
+// returns the result of the first time.
+type SyncItem struct {
+	Note         note.Note
+	BaseSequence int64
+	Deleted      bool
+	ClientID     string
+}
+
+type SyncResult struct {
+	Status   string
+	NoteID   int
+	Sequence int64
+	Current  *Change
+}
+
+func (dbm *DatabaseManager) CurrentSequence(ctx context.Context) (int64, error) {
+	defer dbm.observe("CurrentSequence", time.Now())
+
+	var sequence int64
+
+	err := dbm.queryRow(ctx, nil, CURRENT_SEQUENCE_QS).Scan(&sequence)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", CURRENT_SEQUENCE_QS)
+	}
+
+	return sequence, err
+}
+
+func (dbm *DatabaseManager) Changes(ctx context.Context, since int64, limit int) ([]Change, error) {
+	defer dbm.observe("Changes", time.Now())
+
+	var changes []Change
+
+	rows, err := dbm.query(ctx, nil, SELECT_CHANGES_QS, since, since, limit)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", SELECT_CHANGES_QS)
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sequence int64
+		var noteID int
+		var title string
+		var text string
+		var addDate int64
+		var changeDate int64
+		var version int
+		var tags string
+		var deleted bool
+
+		err = rows.Scan(&sequence, &noteID, &title, &text, &addDate, &changeDate, &version, &tags, &deleted)
+		if err != nil {
+			dbm.log().Error("Scanning change.", "error", err)
+			return changes, err
+		}
+
+		n, err := dbm.openNote(noteID, title, text, addDate, changeDate, version, tags)
+		if err != nil {
+			return changes, err
+		}
+
+		changes = append(changes, Change{
+			Sequence: sequence,
+			Deleted:  deleted,
+			Note:     n})
+	}
+
+	return changes, rows.Err()
+}
+
+func (dbm *DatabaseManager) lookupChange(ctx context.Context, transaction *sql.Tx, noteID int) (*Change, error) {
+	var sequence int64
+	var title string
+	var text string
+	var addDate int64
+	var changeDate int64
+	var version int
+	var tags string
+
+	err := dbm.queryRow(ctx, transaction, LOOKUP_NOTE_CHANGE_QS, noteID).Scan(&sequence, &title, &text, &addDate, &changeDate, &version, &tags)
+	if err == nil {
+		n, err := dbm.openNote(noteID, title, text, addDate, changeDate, version, tags)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Change{
+			Sequence: sequence,
+			Note:     n}, nil
+	} else if err != sql.ErrNoRows {
+		dbm.log().Error("Query failed.", "error", err, "query", LOOKUP_NOTE_CHANGE_QS)
+		return nil, err
+	}
+
+	err = dbm.queryRow(ctx, transaction, LOOKUP_TOMBSTONE_QS, noteID).Scan(&sequence, &changeDate)
+	if err == nil {
+		return &Change{
+			Sequence: sequence,
+			Deleted:  true,
+			Note:     note.NewLocal(noteID, "", "", time.Time{}, time.Unix(changeDate, 0), 0, nil)}, nil
+	} else if err != sql.ErrNoRows {
+		dbm.log().Error("Query failed.", "error", err, "query", LOOKUP_TOMBSTONE_QS)
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// ApplySyncItem applies one offline edit unless the note changed on the
+// server after the device last saw it, in which case the current state of
+// the note is handed back as a conflict.
+func (dbm *DatabaseManager) ApplySyncItem(ctx context.Context, item SyncItem) (SyncResult, error) {
+	defer dbm.observe("ApplySyncItem", time.Now())
+
+	var result SyncResult = SyncResult{NoteID: item.Note.NoteID()}
+	var event events.Event = events.Event{NoteID: item.Note.NoteID(), ChangeDate: item.Note.ChangeDate()}
+
+	transaction, err := dbm.begin(ctx)
+	if err != nil {
+		dbm.log().Error("Initializing sync transaction.", "error", err)
+		return result, err
+	}
+	defer transaction.Rollback()
+
+	if item.Note.NoteID() == 0 {
+		if item.Deleted {
+			result.Status = SYNC_NOT_FOUND
+			return result, nil
+		}
+
+		if item.ClientID != "" {
+			err = dbm.queryRow(ctx, transaction, LOOKUP_SYNC_CLIENT_ID_QS, item.ClientID).Scan(&result.NoteID, &result.Sequence)
+			if err == nil {
+				result.Status = SYNC_CREATED
+				return result, nil
+			} else if err != sql.ErrNoRows {
+				dbm.log().Error("Query failed.", "error", err, "query", LOOKUP_SYNC_CLIENT_ID_QS)
+				return result, err
+			}
+		}
+
+		var sealed note.Note
+
+		sealed, err = dbm.sealNote(item.Note)
+		if err != nil {
+			return result, err
+		}
+
+		result.NoteID, result.Sequence, err = dbm.insertNote(ctx, transaction, sealed)
+		if err == nil && item.ClientID != "" {
+			_, err = dbm.exec(ctx, transaction, ADD_SYNC_CLIENT_ID_EXEC, item.ClientID, result.NoteID, result.Sequence, time.Now().Unix())
+			if err != nil {
+				dbm.log().Error("Remembering the client id of a created note.", "error", err)
+			}
+		}
+		result.Status = SYNC_CREATED
+		event.Type = events.NOTE_CREATED
+		event.NoteID = result.NoteID
+	} else {
+		var current *Change
+
+		current, err = dbm.lookupChange(ctx, transaction, item.Note.NoteID())
+		if err != nil {
+			return result, err
+		}
+
+		if current == nil {
+			result.Status = SYNC_NOT_FOUND
+			return result, nil
+		}
+
+		if current.Sequence > item.BaseSequence {
+			result.Status = SYNC_CONFLICT
+			result.Sequence = current.Sequence
+			result.Current = current
+			return result, nil
+		}
+
+		if current.Deleted {
+			result.Status = SYNC_DELETED
+			result.Sequence = current.Sequence
+			return result, nil
+		}
+
+		if item.Deleted {
+			result.Sequence, err = dbm.removeNote(ctx, transaction, item.Note.NoteID(), current.Note.Version(), item.Note.ChangeDate())
+			result.Status = SYNC_DELETED
+			event.Type = events.NOTE_DELETED
+		} else {
+			// Devices that do not know about tags leave them as they are.
+			var tags []string = item.Note.Tags()
+			if tags == nil {
+				tags = current.Note.Tags()
+			}
+
+			var edited note.Note = note.NewLocal(
+				item.Note.NoteID(),
+				item.Note.Title(),
+				item.Note.Text(),
+				current.Note.AddDate(),
+				item.Note.ChangeDate(),
+				current.Note.Version(),
+				tags)
+
+			edited, err = dbm.sealNote(edited)
+			if err != nil {
+				return result, err
+			}
+
+			result.Sequence, err = dbm.updateNote(ctx, transaction, edited)
+			result.Status = SYNC_UPDATED
+			event.Type = events.NOTE_UPDATED
+		}
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		dbm.log().Error("Committing sync transaction.", "error", err)
+		return result, err
+	}
+
+	dbm.broker.Publish(event)
+
+	return result, nil
+}
@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"context"
+	"note"
+	"testing"
+	"time"
+)
+
+// TestSyncClientID uploads the same creates again, like a device does that
+// lost the response.
+func TestSyncClientID(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+	var ctx context.Context = context.Background()
+
+	create := func(title string, clientID string) SyncResult {
+		result, err := dbm.ApplySyncItem(ctx, SyncItem{
+			Note:     note.NewLocal(0, title, "text", time.Now(), time.Now(), 0, nil),
+			ClientID: clientID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != SYNC_CREATED {
+			t.Fatalf("%s: status %s, expected %s", title, result.Status, SYNC_CREATED)
+		}
+		return result
+	}
+
+	var first SyncResult = create("first", "device-1")
+	var second SyncResult = create("second", "device-2")
+
+	// The note was edited after it was created; the repeated create still
+	// answers with the sequence it was created at.
+	edited, err := dbm.ApplySyncItem(ctx, SyncItem{
+		Note:         note.NewLocal(first.NoteID, "first", "edited", time.Now(), time.Now(), 0, nil),
+		BaseSequence: first.Sequence})
+	if err != nil || edited.Status != SYNC_UPDATED {
+		t.Fatalf("edit: status %s, error %v", edited.Status, err)
+	}
+
+	if repeated := create("first again", "device-1"); repeated != first {
+		t.Errorf("repeated create: %+v, expected %+v", repeated, first)
+	}
+	if repeated := create("second again", "device-2"); repeated != second {
+		t.Errorf("repeated create: %+v, expected %+v", repeated, second)
+	}
+
+	// Creates without a client id are not deduplicated.
+	create("third", "")
+	create("third", "")
+
+	notes, err := dbm.LoadNotes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 4 {
+		t.Errorf("%d notes, expected 4", len(notes))
+	}
+}
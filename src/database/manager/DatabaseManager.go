@@ -1,18 +1,19 @@
 package manager
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"events"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
-	"log"
+	"log/slog"
 	"note"
-	"os"
 	"strconv"
 	"time"
 )
 
-const DB_FILE_NAME = "sndb.db"
-
-const DATE_FORMAT = "1999-12-31 24:12:59"
+const EACH_NOTE_BATCH_SIZE = 100
 
 const INITIALIZE_NOTES_TABLE_EXEC = `create table notes (
         noteID integer not null primary key, 
@@ -22,199 +23,564 @@ const INITIALIZE_NOTES_TABLE_EXEC = `create table notes (
         changeDate time
     );`
 
-const SELECT_NOTES_QS = `select noteID, title, text, addDate, changeDate 
-     from notes
-     order by changeDate desc`
+const NOTES_TABLE_EXISTS_QS = `select count(*)
+     from sqlite_master
+     where type = 'table' and name = 'notes'`
 
-const LOOKUP_NOTE_QS = `select title, text, addDate, changeDate 
+const SELECT_NOTES_QS = `select noteID, title, text, addDate, changeDate, version, tags 
      from notes
-     where noteID = ?`
-
-const SELECT_NOTES_WHERE_TITLE_QS = `select noteID, title, text, addDate, changeDate 
-     from notes
-     where title like ?
      order by changeDate desc`
 
-const SELECT_NOTES_WHERE_TEXT_QS = `select noteID, title, text, addDate, changeDate 
+const SELECT_NOTES_AFTER_ID_QS = `select noteID, title, text, addDate, changeDate, version, tags 
      from notes
-     where text like ?
-     order by changeDate desc`
+     where noteID > ?
+     order by noteID
+     limit ?`
 
-const SELECT_NOTES_WHERE_BOTH_QS = `select noteID, title, text, addDate, changeDate 
+const LOOKUP_NOTE_QS = `select title, text, addDate, changeDate, version, tags 
      from notes
-     where title like ? or text like ?
-     order by changeDate desc`
+     where noteID = ?`
+
+const ADD_NOTE_EXEC = `insert into notes(title, text, addDate, changeDate, sequence, tags) 
+     values(?, ?, ?, ?, ?, ?);`
 
-const ADD_NOTE_EXEC = `insert into notes(title, text, addDate, changeDate) 
-     values(?, ?, ?, ?);`
+const ADD_NOTE_WITH_ID_EXEC = `insert into notes(title, text, addDate, changeDate, sequence, tags, noteID) 
+     values(?, ?, ?, ?, ?, ?, ?);`
 
 const UPDATE_NOTE_EXEC = `update notes 
-     set title = ?, text = ?, changeDate = ?
-     where noteID = ?;`
+     set title = ?, text = ?, changeDate = ?, sequence = ?, tags = ?, version = version + 1
+     where noteID = ? and version = ?;`
+
+const NOTE_EXISTS_QS = `select count(*) 
+     from notes
+     where noteID = ?`
 
 const DELETE_NOTE_EXEC = `delete from notes 
      where noteID = ?;`
 
+const DELETE_NOTE_VERSION_EXEC = `delete from notes 
+     where noteID = ? and version = ?;`
+
+const ADD_TOMBSTONE_EXEC = `insert or replace into tombstones(noteID, sequence, deleteDate) 
+     values(?, ?, ?);`
+
+const DELETE_TOMBSTONE_EXEC = `delete from tombstones 
+     where noteID = ?;`
+
+const INCREMENT_SEQUENCE_EXEC = `update changeSequence 
+     set sequence = sequence + 1;`
+
+const CURRENT_SEQUENCE_QS = `select sequence 
+     from changeSequence`
+
+const SCHEMA_VERSION_QS = `pragma user_version`
+
+const SET_SCHEMA_VERSION_EXEC = `pragma user_version = %d;`
+
+const ADD_CHANGE_SEQUENCE_EXEC = `alter table notes add column sequence integer not null default 0;
+    update notes set sequence = noteID;
+    create index notesSequenceIndex on notes(sequence);
+    create table tombstones (
+        noteID integer not null primary key, 
+        sequence integer not null, 
+        deleteDate time
+    );
+    create index tombstonesSequenceIndex on tombstones(sequence);
+    create table changeSequence (
+        sequence integer not null
+    );
+    insert into changeSequence(sequence) select coalesce(max(sequence), 0) from notes;`
+
+const ADD_VERSION_EXEC = `alter table notes add column version integer not null default 1;`
+
+const ADD_REVISIONS_EXEC = `create table revisions (
+        revisionID integer not null primary key, 
+        noteID integer not null, 
+        version integer not null, 
+        operation text not null, 
+        title text, 
+        text text, 
+        addDate time, 
+        changeDate time, 
+        recordDate time not null
+    );
+    create index revisionsNoteIndex on revisions(noteID, version);
+    insert into revisions(noteID, version, operation, title, text, addDate, changeDate, recordDate)
+        select noteID, version, 'snapshot', title, text, addDate, changeDate, changeDate from notes;`
+
+const ADD_LEASES_EXEC = `create table leases (
+        noteID integer not null primary key, 
+        holder text not null, 
+        holderName text, 
+        acquireDate time not null, 
+        expiryDate time not null
+    );`
+
+const ADD_TAGS_EXEC = `alter table notes add column tags text not null default '';
+    alter table revisions add column tags text not null default '';`
+
+const ADD_CHANGE_DATE_INDEX_EXEC = `create index notesChangeDateIndex on notes(changeDate, noteID);`
+
+const ADD_SYNC_CLIENT_IDS_EXEC = `create table syncClientIDs (
+        clientID text not null primary key, 
+        noteID integer not null, 
+        sequence integer not null, 
+        createDate time not null
+    );`
+
+// Schema changes on top of INITIALIZE_NOTES_TABLE_EXEC. The position in this
+// list is the schema version stored in the database, so only ever append.
+var MIGRATIONS = []string{
+	ADD_CHANGE_SEQUENCE_EXEC,
+	ADD_VERSION_EXEC,
+	ADD_REVISIONS_EXEC,
+	ADD_LEASES_EXEC,
+	ADD_TAGS_EXEC,
+	ADD_CHANGE_DATE_INDEX_EXEC,
+	ADD_SYNC_CLIENT_IDS_EXEC,
+}
+
+var ErrVersionConflict = errors.New("The note was changed by someone else in the meantime.")
+
+// A DatabaseManager can be used by many goroutines at once. Every call
+// returns notes of its own, and the copies WithLogger makes share the pool
+// and its statements.
 type DatabaseManager struct {
-	notes []note.Note
-	db    *sql.DB
+	db          *sql.DB
+	statements  *statementCache
+	broker      *events.Broker
+	path        string
+	readOnly    bool
+	raw         bool
+	keys        *Keys
+	keyFile     string
+	keyVariable string
+	observer    QueryObserver
+	logger      *slog.Logger
 }
 
-func New() DatabaseManager {
-	dbm := DatabaseManager{}
+// New manages the database of the server in the file at path, sndb.db
+// unless configured otherwise.
+func New(path string) DatabaseManager {
+	dbm := DatabaseManager{broker: events.New(), statements: newStatementCache(), path: path}
 
 	return dbm
 }
 
-func (dbm *DatabaseManager) Notes() []note.Note {
-	return dbm.notes
+// NewFile manages another database than the one of the server, e.g. a
+// restored copy. A read-only database is neither rebuilt nor migrated.
+func NewFile(path string, readOnly bool) DatabaseManager {
+	dbm := DatabaseManager{broker: events.New(), statements: newStatementCache(), path: path, readOnly: readOnly}
+
+	return dbm
 }
 
-func (dbm *DatabaseManager) checkDBValidity() error {
-	rows, err := dbm.db.Query(SELECT_NOTES_QS)
+func (dbm *DatabaseManager) dataSourceName() string {
+	var busyTimeout string = strconv.FormatInt(BUSY_TIMEOUT.Milliseconds(), 10)
 
+	if dbm.readOnly {
+		return "file:" + dbm.path + "?mode=ro&_busy_timeout=" + busyTimeout
+	}
+	return dbm.path + "?_busy_timeout=" + busyTimeout + "&_txlock=immediate"
+}
+
+// openPool opens the database in WAL mode, so notes can be read while one
+// is written.
+func (dbm *DatabaseManager) openPool(ctx context.Context) error {
+	var err error
+
+	dbm.db, err = sql.Open("sqlite3", dbm.dataSourceName())
+	if err != nil || dbm.readOnly {
+		return err
+	}
+
+	_, err = dbm.db.ExecContext(ctx, ENABLE_WAL_EXEC)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Rebuilding the database...")
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var noteID int
-			var title string
-			var text string
-			var addDate time.Time
-			var changeDate time.Time
-			rows.Scan(&noteID, &title, &text, &addDate, &changeDate)
-		}
+		dbm.log().Error("Query failed.", "error", err, "query", ENABLE_WAL_EXEC)
 	}
 
 	return err
 }
 
-func (dbm *DatabaseManager) initializeDB() error {
-	var err error
+// WithLogger returns a manager for the same database that logs to logger,
+// e.g. with the id of the request it works for.
+func (dbm *DatabaseManager) WithLogger(logger *slog.Logger) *DatabaseManager {
+	var bound DatabaseManager = *dbm
+	bound.logger = logger
 
-	os.Remove("./foo.db")
+	return &bound
+}
 
-	dbm.db, err = sql.Open("sqlite3", "./"+DB_FILE_NAME)
+func (dbm *DatabaseManager) log() *slog.Logger {
+	if dbm.logger == nil {
+		return slog.Default()
+	}
+	return dbm.logger
+}
 
-	_, err = dbm.db.Exec(INITIALIZE_NOTES_TABLE_EXEC)
+func (dbm *DatabaseManager) Events() *events.Broker {
+	return dbm.broker
+}
+
+// notesTableExists tells a new, empty database from one that holds notes.
+// Any other error, e.g. a busy or unreadable file, is returned as it is and
+// never taken for an empty database.
+func (dbm *DatabaseManager) notesTableExists(ctx context.Context) (bool, error) {
+	var count int
+
+	err := dbm.db.QueryRowContext(ctx, NOTES_TABLE_EXISTS_QS).Scan(&count)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", NOTES_TABLE_EXISTS_QS)
+	}
+
+	return count > 0, err
+}
 
+func (dbm *DatabaseManager) initializeDB(ctx context.Context) error {
+	_, err := dbm.db.ExecContext(ctx, INITIALIZE_NOTES_TABLE_EXEC)
 	if err != nil {
-		log.Printf("%q: %s\n", err, INITIALIZE_NOTES_TABLE_EXEC)
+		dbm.log().Error("Query failed.", "error", err, "query", INITIALIZE_NOTES_TABLE_EXEC)
 	}
 
 	return err
 }
 
-func (dbm *DatabaseManager) Open() error {
-	var err error
+func (dbm *DatabaseManager) Open(ctx context.Context) error {
+	err := dbm.openPool(ctx)
+	if err != nil {
+		return err
+	}
+
+	exists, err := dbm.notesTableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dbm.readOnly {
+		if !exists {
+			return fmt.Errorf("%s holds no notes.", dbm.path)
+		}
+	} else {
+		if !exists {
+			dbm.log().Info("Creating the database...", "path", dbm.path)
+
+			err = dbm.initializeDB(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = dbm.migrate(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dbm.raw {
+		return nil
+	}
+
+	dbm.keys, err = ReadKeys(dbm.keyFile, dbm.keyVariable)
+	if err != nil {
+		return err
+	}
+
+	return dbm.checkKeys(ctx)
+}
 
-	dbm.db, err = sql.Open("sqlite3", "./"+DB_FILE_NAME)
+func (dbm *DatabaseManager) migrate(ctx context.Context) error {
+	var version int
 
+	err := dbm.db.QueryRowContext(ctx, SCHEMA_VERSION_QS).Scan(&version)
 	if err != nil {
-		log.Fatal(err)
+		dbm.log().Error("Reading schema version.", "error", err)
 		return err
 	}
 
-	if dbm.checkDBValidity() != nil {
-		dbm.Close()
+	for ; version < len(MIGRATIONS); version++ {
+		transaction, err := dbm.db.BeginTx(ctx, nil)
 		if err != nil {
-			log.Fatal(err)
-		} else {
-			dbm.initializeDB()
+			dbm.log().Error("Initializing migration transaction.", "error", err)
+			return err
+		}
+
+		_, err = transaction.ExecContext(ctx, MIGRATIONS[version])
+		if err == nil {
+			_, err = transaction.ExecContext(ctx, fmt.Sprintf(SET_SCHEMA_VERSION_EXEC, version+1))
+		}
+
+		if err != nil {
+			dbm.log().Error("Migrating the schema.", "error", err, "version", version+1)
+			transaction.Rollback()
+			return err
+		}
+
+		err = transaction.Commit()
+		if err != nil {
+			dbm.log().Error("Committing migration transaction.", "error", err)
+			return err
 		}
 	}
 
-	return err
+	return nil
 }
 
 func (dbm *DatabaseManager) Close() {
+	dbm.statements.close()
 	dbm.db.Close()
 }
 
-func (dbm *DatabaseManager) AddNote(n note.Note) error {
+func (dbm *DatabaseManager) nextSequence(ctx context.Context, transaction *sql.Tx) (int64, error) {
+	var sequence int64
 
-	transaction, err := dbm.db.Begin()
+	_, err := dbm.exec(ctx, transaction, INCREMENT_SEQUENCE_EXEC)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Initializing add transaction.")
-		return err
+		dbm.log().Error("Query failed.", "error", err, "query", INCREMENT_SEQUENCE_EXEC)
+		return sequence, err
 	}
 
-	stmt, err := transaction.Prepare(ADD_NOTE_EXEC)
+	err = dbm.queryRow(ctx, transaction, CURRENT_SEQUENCE_QS).Scan(&sequence)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Preparing add transaction.")
-		return err
+		dbm.log().Error("Query failed.", "error", err, "query", CURRENT_SEQUENCE_QS)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(n.Title(), n.Text(), n.AddDate().Unix(), n.ChangeDate().Unix())
+	return sequence, err
+}
+
+func (dbm *DatabaseManager) insertNote(ctx context.Context, transaction *sql.Tx, n note.Note) (int, int64, error) {
+	sequence, err := dbm.nextSequence(ctx, transaction)
+	if err != nil {
+		return 0, sequence, err
+	}
+
+	// A note that already has an id, e.g. from an export, keeps it.
+	var statement string = ADD_NOTE_EXEC
+	var parameters []interface{} = []interface{}{n.Title(), n.Text(), n.AddDate().Unix(), n.ChangeDate().Unix(), sequence, note.JoinTags(n.Tags())}
+	if n.NoteID() > 0 {
+		statement = ADD_NOTE_WITH_ID_EXEC
+		parameters = append(parameters, n.NoteID())
+	}
+
+	result, err := dbm.exec(ctx, transaction, statement, parameters...)
+	if err != nil {
+		dbm.log().Error("Add note in add transaction.", "error", err)
+		return 0, sequence, err
+	}
+
+	noteID, err := result.LastInsertId()
+	if err != nil {
+		dbm.log().Error("Reading id of added note.", "error", err)
+		return 0, sequence, err
+	}
+
+	// SQLite may hand out the id of a deleted note again.
+	_, err = dbm.exec(ctx, transaction, DELETE_TOMBSTONE_EXEC, noteID)
+	if err != nil {
+		dbm.log().Error("Clearing tombstone in add transaction.", "error", err)
+		return int(noteID), sequence, err
+	}
+
+	err = dbm.recordRevision(ctx, transaction, int(noteID), REVISION_ADD)
+
+	return int(noteID), sequence, err
+}
+
+func (dbm *DatabaseManager) updateNote(ctx context.Context, transaction *sql.Tx, n note.Note) (int64, error) {
+	sequence, err := dbm.nextSequence(ctx, transaction)
+	if err != nil {
+		return sequence, err
+	}
+
+	result, err := dbm.exec(ctx, transaction, UPDATE_NOTE_EXEC, n.Title(), n.Text(), n.ChangeDate().Unix(), sequence, note.JoinTags(n.Tags()), strconv.Itoa(n.NoteID()), n.Version())
+	if err != nil {
+		dbm.log().Error("Update note in update transaction.", "error", err)
+		return sequence, err
+	}
+
+	updatedRows, err := result.RowsAffected()
+	if err != nil {
+		dbm.log().Error("Counting rows in update transaction.", "error", err)
+		return sequence, err
+	}
+
+	if updatedRows == 0 {
+		return sequence, dbm.missingOrConflict(ctx, transaction, n.NoteID())
+	}
+
+	err = dbm.recordRevision(ctx, transaction, n.NoteID(), REVISION_UPDATE)
+
+	return sequence, err
+}
+
+// missingOrConflict tells why no row of noteID matched the version a
+// statement expected.
+func (dbm *DatabaseManager) missingOrConflict(ctx context.Context, transaction *sql.Tx, noteID int) error {
+	var count int
+
+	err := dbm.queryRow(ctx, transaction, NOTE_EXISTS_QS, noteID).Scan(&count)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Add note in add transaction.")
+		dbm.log().Error("Query failed.", "error", err, "query", NOTE_EXISTS_QS)
 		return err
 	}
 
-	transaction.Commit()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return ErrVersionConflict
+}
+
+func (dbm *DatabaseManager) removeNote(ctx context.Context, transaction *sql.Tx, noteID int, version int, deleteDate time.Time) (int64, error) {
+	sequence, err := dbm.nextSequence(ctx, transaction)
+	if err != nil {
+		return sequence, err
+	}
+
+	err = dbm.recordRevision(ctx, transaction, noteID, REVISION_DELETE)
+	if err != nil {
+		return sequence, err
+	}
+
+	result, err := dbm.exec(ctx, transaction, DELETE_NOTE_VERSION_EXEC, strconv.Itoa(noteID), version)
+	if err != nil {
+		dbm.log().Error("Update note in delete transaction.", "error", err)
+		return sequence, err
+	}
+
+	deletedRows, err := result.RowsAffected()
+	if err != nil {
+		dbm.log().Error("Counting rows in delete transaction.", "error", err)
+		return sequence, err
+	}
+
+	if deletedRows == 0 {
+		return sequence, dbm.missingOrConflict(ctx, transaction, noteID)
+	}
+
+	// SQLite hands the id of a deleted note out again, its lease must not
+	// pass to the next note.
+	_, err = dbm.exec(ctx, transaction, BREAK_LEASE_EXEC, noteID)
+	if err != nil {
+		dbm.log().Error("Remove lease in delete transaction.", "error", err)
+		return sequence, err
+	}
 
+	_, err = dbm.exec(ctx, transaction, ADD_TOMBSTONE_EXEC, noteID, sequence, deleteDate.Unix())
+	if err != nil {
+		dbm.log().Error("Add tombstone in delete transaction.", "error", err)
+	}
+
+	return sequence, err
+}
+
+func (dbm *DatabaseManager) AddNote(ctx context.Context, n note.Note) error {
+	_, err := dbm.CreateNote(ctx, n)
 	return err
 }
 
-func (dbm *DatabaseManager) UpdateNote(n note.Note) error {
-	transaction, err := dbm.db.Begin()
+// CreateNote adds a note like AddNote and returns the id it was stored
+// under.
+func (dbm *DatabaseManager) CreateNote(ctx context.Context, n note.Note) (int, error) {
+	defer dbm.observe("CreateNote", time.Now())
+
+	transaction, err := dbm.begin(ctx)
+	if err != nil {
+		dbm.log().Error("Initializing add transaction.", "error", err)
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	sealed, err := dbm.sealNote(n)
+	if err != nil {
+		return 0, err
+	}
+
+	noteID, _, err := dbm.insertNote(ctx, transaction, sealed)
+	if err != nil {
+		return 0, err
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		dbm.log().Error("Committing add transaction.", "error", err)
+		return 0, err
+	}
+
+	dbm.broker.Publish(events.Event{Type: events.NOTE_CREATED, NoteID: noteID, ChangeDate: n.ChangeDate()})
+
+	return noteID, err
+}
+
+func (dbm *DatabaseManager) UpdateNote(ctx context.Context, n note.Note) error {
+	defer dbm.observe("UpdateNote", time.Now())
+
+	transaction, err := dbm.begin(ctx)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Initializing update transaction.")
+		dbm.log().Error("Initializing update transaction.", "error", err)
 		return err
 	}
+	defer transaction.Rollback()
 
-	updateStatement, err := transaction.Prepare(UPDATE_NOTE_EXEC)
+	sealed, err := dbm.sealNote(n)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Preparing update transaction.")
 		return err
 	}
-	defer updateStatement.Close()
 
-	_, err = updateStatement.Exec(n.Title(), n.Text(), n.ChangeDate().Unix(), strconv.Itoa(n.NoteID()))
+	_, err = dbm.updateNote(ctx, transaction, sealed)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Update note in update transaction.")
 		return err
 	}
 
-	transaction.Commit()
+	err = transaction.Commit()
+	if err != nil {
+		dbm.log().Error("Committing update transaction.", "error", err)
+		return err
+	}
+
+	dbm.broker.Publish(events.Event{Type: events.NOTE_UPDATED, NoteID: n.NoteID(), ChangeDate: n.ChangeDate()})
 
 	return err
 }
 
-func (dbm *DatabaseManager) DeleteNote(noteID int) error {
-	transaction, err := dbm.db.Begin()
+// DeleteNote deletes a note if version is still its current version, like
+// UpdateNote saves one.
+func (dbm *DatabaseManager) DeleteNote(ctx context.Context, noteID int, version int) error {
+	defer dbm.observe("DeleteNote", time.Now())
+
+	transaction, err := dbm.begin(ctx)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Initializing delete transaction.")
+		dbm.log().Error("Initializing delete transaction.", "error", err)
 		return err
 	}
+	defer transaction.Rollback()
+
+	var deleteDate time.Time = time.Now()
 
-	deleteStatement, err := transaction.Prepare(DELETE_NOTE_EXEC)
+	_, err = dbm.removeNote(ctx, transaction, noteID, version, deleteDate)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Preparing delete transaction.")
 		return err
 	}
-	defer deleteStatement.Close()
 
-	_, err = deleteStatement.Exec(strconv.Itoa(noteID))
+	err = transaction.Commit()
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Update note in delete transaction.")
+		dbm.log().Error("Committing delete transaction.", "error", err)
 		return err
 	}
 
-	transaction.Commit()
+	dbm.broker.Publish(events.Event{Type: events.NOTE_DELETED, NoteID: noteID, ChangeDate: deleteDate})
 
 	return err
 }
 
-func (dbm *DatabaseManager) LoadNotes() ([]note.Note, error) {
-	dbm.notes = dbm.notes[0:0]
+func (dbm *DatabaseManager) LoadNotes(ctx context.Context) ([]note.Note, error) {
+	defer dbm.observe("LoadNotes", time.Now())
+
+	var notes []note.Note
 
-	rows, err := dbm.db.Query(SELECT_NOTES_QS)
+	rows, err := dbm.query(ctx, nil, SELECT_NOTES_QS)
 
 	if err != nil {
-		log.Printf("%q: %s\n", err, SELECT_NOTES_QS)
+		dbm.log().Error("Query failed.", "error", err, "query", SELECT_NOTES_QS)
 	} else {
 		defer rows.Close()
 		for rows.Next() {
@@ -223,70 +589,91 @@ func (dbm *DatabaseManager) LoadNotes() ([]note.Note, error) {
 			var text string
 			var addDate int64
 			var changeDate int64
-			rows.Scan(&noteID, &title, &text, &addDate, &changeDate)
-			dbm.notes = append(dbm.notes, note.NewLocal(noteID, title, text, time.Unix(addDate, 0), time.Unix(changeDate, 0)))
+			var version int
+			var tags string
+			rows.Scan(&noteID, &title, &text, &addDate, &changeDate, &version, &tags)
+			n, err := dbm.openNote(noteID, title, text, addDate, changeDate, version, tags)
+			if err != nil {
+				return notes, err
+			}
+			notes = append(notes, n)
 		}
 	}
 
-	return dbm.notes, err
+	return notes, err
 }
 
-func (dbm *DatabaseManager) LoadNotesWhere(whereClause string, whereParameters ...string) ([]note.Note, error) {
-	dbm.notes = dbm.notes[0:0]
+// EachNote calls function for every note in the order of their ids. Notes
+// are read in batches so that no read stays open while function runs.
+func (dbm *DatabaseManager) EachNote(ctx context.Context, function func(note.Note) error) error {
+	var lastNoteID int = 0
 
-	whereQuery, err := dbm.db.Prepare(whereClause)
-	if err != nil {
-		log.Printf("%q: %s\n", err, "Initializing select notes where transaction.")
-		return []note.Note{}, err
-	}
-
-	defer whereQuery.Close()
+	for {
+		var batch []note.Note
 
-        wp := make([]interface{}, len(whereParameters))
-        for i, ps := range whereParameters {
-            wp[i] = ps
-        }
-
-	rows, err := whereQuery.Query(wp...)
+		rows, err := dbm.query(ctx, nil, SELECT_NOTES_AFTER_ID_QS, lastNoteID, EACH_NOTE_BATCH_SIZE)
+		if err != nil {
+			dbm.log().Error("Query failed.", "error", err, "query", SELECT_NOTES_AFTER_ID_QS)
+			return err
+		}
 
-	if err != nil {
-		log.Printf("%q: %s\n", err, "Query select notes where transaction.")
-	} else {
-		defer rows.Close()
 		for rows.Next() {
 			var noteID int
 			var title string
 			var text string
 			var addDate int64
 			var changeDate int64
-			rows.Scan(&noteID, &title, &text, &addDate, &changeDate)
-			dbm.notes = append(dbm.notes, note.NewLocal(noteID, title, text, time.Unix(addDate, 0), time.Unix(changeDate, 0)))
+			var version int
+			var tags string
+			err = rows.Scan(&noteID, &title, &text, &addDate, &changeDate, &version, &tags)
+			if err != nil {
+				rows.Close()
+				dbm.log().Error("Scanning note batch.", "error", err)
+				return err
+			}
+			n, err := dbm.openNote(noteID, title, text, addDate, changeDate, version, tags)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, n)
 		}
-	}
 
-	return dbm.notes, err
-}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
 
-func (dbm *DatabaseManager) GetNote(noteID int) (note.Note, error) {
+		for _, n := range batch {
+			err = function(n)
+			if err != nil {
+				return err
+			}
+			lastNoteID = n.NoteID()
+		}
 
-	lookupQuery, err := dbm.db.Prepare(LOOKUP_NOTE_QS)
-	if err != nil {
-		log.Printf("%q: %s\n", err, LOOKUP_NOTE_QS)
-		return note.Note{}, err
+		if len(batch) < EACH_NOTE_BATCH_SIZE {
+			return nil
+		}
 	}
+}
 
-	defer lookupQuery.Close()
+func (dbm *DatabaseManager) GetNote(ctx context.Context, noteID int) (note.Note, error) {
+	defer dbm.observe("GetNote", time.Now())
 
 	var title string
 	var text string
 	var addDate int64
 	var changeDate int64
+	var version int
+	var tags string
 
-	err = lookupQuery.QueryRow(strconv.Itoa(noteID)).Scan(&title, &text, &addDate, &changeDate)
+	err := dbm.queryRow(ctx, nil, LOOKUP_NOTE_QS, strconv.Itoa(noteID)).Scan(&title, &text, &addDate, &changeDate, &version, &tags)
 	if err != nil {
-		log.Printf("%q: %s\n", err, "Get Note scan failed.")
+		dbm.log().Error("Get Note scan failed.", "error", err)
 		return note.Note{}, err
 	}
 
-	return note.NewLocal(noteID, title, text, time.Unix(addDate, 0), time.Unix(changeDate, 0)), err
+	return dbm.openNote(noteID, title, text, addDate, changeDate, version, tags)
 }
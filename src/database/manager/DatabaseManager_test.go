@@ -0,0 +1,236 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"note"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const STRESS_WORKERS = 8
+const STRESS_ROUNDS = 40
+
+func openTestDatabase(t *testing.T) *DatabaseManager {
+	var dbm DatabaseManager = New(filepath.Join(t.TempDir(), "sndb.db"))
+
+	err := dbm.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dbm.Close)
+
+	return &dbm
+}
+
+// TestConcurrentUse runs writers and readers on the same manager and on the
+// copies WithLogger makes; run it with -race. Every worker
+// filters for its own notes, so results mixed up between calls show as
+// notes of another worker.
+func TestConcurrentUse(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+	var ctx context.Context = context.Background()
+	var workers sync.WaitGroup
+
+	for worker := 0; worker < STRESS_WORKERS; worker++ {
+		workers.Add(1)
+		go func(worker int) {
+			defer workers.Done()
+
+			var store *DatabaseManager = dbm
+			if worker%2 == 1 {
+				store = dbm.WithLogger(slog.Default().With("worker", worker))
+			}
+			var prefix string = fmt.Sprintf("worker %d ", worker)
+
+			for round := 0; round < STRESS_ROUNDS; round++ {
+				noteID, err := store.CreateNote(ctx, note.New(prefix+fmt.Sprint(round), "text"))
+				if err != nil {
+					t.Errorf("%screating a note: %s", prefix, err)
+					return
+				}
+
+				created, err := store.GetNote(ctx, noteID)
+				if err != nil || created.Title() != prefix+fmt.Sprint(round) {
+					t.Errorf("%sgot note %d as %q: %v", prefix, noteID, created.Title(), err)
+					return
+				}
+
+				edited := note.NewLocal(noteID, created.Title(), "edited", created.AddDate(), created.ChangeDate(), created.Version(), []string{"stress"})
+				if err = store.UpdateNote(ctx, edited); err != nil {
+					t.Errorf("%supdating note %d: %s", prefix, noteID, err)
+					return
+				}
+
+				if _, err = store.AcquireLease(ctx, noteID, prefix, prefix, time.Minute); err != nil {
+					t.Errorf("%sleasing note %d: %s", prefix, noteID, err)
+					return
+				}
+
+				if round%4 == 3 {
+					if err = store.DeleteNote(ctx, noteID, created.Version()+1); err != nil {
+						t.Errorf("%sdeleting note %d: %s", prefix, noteID, err)
+						return
+					}
+				}
+
+				own, err := store.FilterNotes(ctx, NoteFilter{Field: FILTER_TITLE, Pattern: prefix})
+				if err != nil {
+					t.Errorf("%sfiltering: %s", prefix, err)
+					return
+				}
+				for _, n := range own {
+					if !strings.HasPrefix(n.Title(), prefix) {
+						t.Errorf("%sgot note %q of another filter", prefix, n.Title())
+						return
+					}
+				}
+
+				if _, err = store.LoadNotes(ctx); err != nil {
+					t.Errorf("%sloading notes: %s", prefix, err)
+					return
+				}
+				if _, err = store.ListNotes(ctx, PageQuery{Limit: 10}); err != nil {
+					t.Errorf("%slisting notes: %s", prefix, err)
+					return
+				}
+			}
+		}(worker)
+	}
+
+	workers.Wait()
+
+	count, err := dbm.CountNotes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := STRESS_WORKERS * (STRESS_ROUNDS - STRESS_ROUNDS/4); count != expected {
+		t.Errorf("%d notes are left, expected %d", count, expected)
+	}
+}
+
+func TestDeleteRemovesLease(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+	var ctx context.Context = context.Background()
+
+	noteID, err := dbm.CreateNote(ctx, note.New("leased", "text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dbm.AcquireLease(ctx, noteID, "device", "Device", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	leased, err := dbm.GetNote(ctx, noteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dbm.DeleteNote(ctx, noteID, leased.Version()); err != nil {
+		t.Fatal(err)
+	}
+
+	reusedID, err := dbm.CreateNote(ctx, note.New("next", "text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reusedID != noteID {
+		t.Logf("note %d got id %d instead of the deleted one", reusedID, noteID)
+	}
+
+	for _, id := range []int{noteID, reusedID} {
+		lease, err := dbm.GetLease(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lease != nil {
+			t.Errorf("note %d is leased to %q after the leased note was deleted", id, lease.Holder)
+		}
+	}
+	if _, err = dbm.AcquireLease(ctx, reusedID, "other", "Other", time.Minute); err != nil {
+		t.Errorf("leasing the new note: %s", err)
+	}
+}
+
+func TestDeleteChecksVersion(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+	var ctx context.Context = context.Background()
+
+	noteID, err := dbm.CreateNote(ctx, note.New("versioned", "text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := dbm.GetNote(ctx, noteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := note.NewLocal(noteID, created.Title(), "edited", created.AddDate(), time.Now(), created.Version(), nil)
+	if err = dbm.UpdateNote(ctx, edited); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dbm.DeleteNote(ctx, noteID, created.Version()); err != ErrVersionConflict {
+		t.Fatalf("deleting an old version: %v, expected %v", err, ErrVersionConflict)
+	}
+	if _, err = dbm.GetNote(ctx, noteID); err != nil {
+		t.Fatalf("the note is gone after a conflicting delete: %s", err)
+	}
+
+	if err = dbm.DeleteNote(ctx, noteID, created.Version()+1); err != nil {
+		t.Fatal(err)
+	}
+	if err = dbm.DeleteNote(ctx, noteID, created.Version()+1); err != sql.ErrNoRows {
+		t.Errorf("deleting a deleted note: %v, expected %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := dbm.CreateNote(canceled, note.New("canceled", "text"))
+	if err == nil {
+		t.Fatal("a note was created with a canceled context")
+	}
+
+	count, err := dbm.CountNotes(context.Background())
+	if err != nil || count != 0 {
+		t.Errorf("%d notes after a canceled create: %v", count, err)
+	}
+}
+
+// TestOpenKeepsNotes opens an existing database with a canceled context: the
+// error must be returned instead of taking the database for a new one.
+func TestOpenKeepsNotes(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+
+	if _, err := dbm.CreateNote(context.Background(), note.New("kept", "text")); err != nil {
+		t.Fatal(err)
+	}
+	dbm.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var reopened DatabaseManager = New(dbm.path)
+	if err := reopened.Open(canceled); err == nil {
+		t.Error("opened the database with a canceled context")
+	}
+	reopened.Close()
+
+	reopened = New(dbm.path)
+	if err := reopened.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	count, err := reopened.CountNotes(context.Background())
+	if err != nil || count != 1 {
+		t.Errorf("%d notes after reopening: %v", count, err)
+	}
+}
@@ -0,0 +1,393 @@
+package manager
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"note"
+	"os"
+	"strings"
+	"time"
+)
+
+// Titles and texts sealed with a master key are stored as SEALED_PREFIX, the
+// id of the key, a colon and the nonce with the sealed value in base64. The
+// key id tells which key opens a value, so older keys keep working until
+// Reencrypt has moved every row to the current one.
+const SEALED_PREFIX = "sndb-sealed:"
+const MASTER_KEY_LENGTH = 32
+const KEY_ID_LENGTH = 8
+
+const REENCRYPT_BATCH_SIZE = 100
+
+// One sample value for every key id found in the database, to check the keys
+// at startup.
+const SELECT_KEY_IDS_QS = `select substr(value, ?, ?), min(value)
+     from (select title as value from notes
+           union all select text from notes
+           union all select title from revisions
+           union all select text from revisions)
+     where substr(value, 1, ?) = ?
+     group by 1`
+
+const SELECT_STALE_NOTES_QS = `select noteID, title, text
+     from notes
+     where substr(title, 1, ?) != ? or substr(text, 1, ?) != ?
+     limit ?`
+
+const SELECT_STALE_REVISIONS_QS = `select revisionID, title, text
+     from revisions
+     where substr(title, 1, ?) != ? or substr(text, 1, ?) != ?
+     limit ?`
+
+// The old values are part of the condition so that a note saved while it is
+// being re-encrypted is left alone, it was sealed with the current key.
+const RESEAL_NOTE_EXEC = `update notes
+     set title = ?, text = ?
+     where noteID = ? and title = ? and text = ?;`
+
+const RESEAL_REVISION_EXEC = `update revisions
+     set title = ?, text = ?
+     where revisionID = ? and title = ? and text = ?;`
+
+var ErrWrongKey = errors.New("The encryption key does not open the database.")
+var ErrNoKey = errors.New("The database is encrypted, but no encryption key was given.")
+
+var keyEncoding = base64.StdEncoding
+
+// Keys are the master keys of a database. The first key seals everything that
+// is written, all of them open what is read.
+type Keys struct {
+	currentID string
+	aeads     map[string]cipher.AEAD
+}
+
+func keyID(key []byte) string {
+	var sum [sha256.Size]byte = sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])[:KEY_ID_LENGTH]
+}
+
+// GenerateKey returns a new random master key in the form ParseKeys reads.
+func GenerateKey() (string, error) {
+	var key []byte = make([]byte, MASTER_KEY_LENGTH)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return keyEncoding.EncodeToString(key), nil
+}
+
+// ParseKeys reads base64 master keys, the current one first.
+func ParseKeys(encodedKeys []string) (*Keys, error) {
+	var keys *Keys = &Keys{aeads: make(map[string]cipher.AEAD)}
+
+	for i, encoded := range encodedKeys {
+		key, err := keyEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != MASTER_KEY_LENGTH {
+			return nil, fmt.Errorf("Encryption key %d is not %d bytes in base64, create one with \"shareNotes keygen\".", i+1, MASTER_KEY_LENGTH)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		var id string = keyID(key)
+		if i == 0 {
+			keys.currentID = id
+		}
+		keys.aeads[id] = gcm
+	}
+
+	if keys.currentID == "" {
+		return nil, errors.New("No encryption key was given.")
+	}
+
+	return keys, nil
+}
+
+// ReadKeys loads the master keys from the key file at path, one key per line
+// with # starting a comment, or else from the environment variable, keys
+// separated by commas. Without either it returns nil, the database is not
+// encrypted.
+func ReadKeys(path string, variable string) (*Keys, error) {
+	var encodedKeys []string
+
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				encodedKeys = append(encodedKeys, line)
+			}
+		}
+	} else if variable != "" && os.Getenv(variable) != "" {
+		for _, value := range strings.Split(os.Getenv(variable), ",") {
+			if strings.TrimSpace(value) != "" {
+				encodedKeys = append(encodedKeys, value)
+			}
+		}
+	} else {
+		return nil, nil
+	}
+
+	return ParseKeys(encodedKeys)
+}
+
+func (k *Keys) currentPrefix() string {
+	return SEALED_PREFIX + k.currentID + ":"
+}
+
+// seal leaves value as it is when there are no keys.
+func (k *Keys) seal(value string) (string, error) {
+	if k == nil {
+		return value, nil
+	}
+
+	var gcm cipher.AEAD = k.aeads[k.currentID]
+	var nonce []byte = make([]byte, gcm.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	return k.currentPrefix() + keyEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// open hands back values that were never sealed, e.g. written before the
+// database was encrypted, as they are.
+func (k *Keys) open(value string) (string, error) {
+	if !strings.HasPrefix(value, SEALED_PREFIX) {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrNoKey
+	}
+
+	var fields []string = strings.SplitN(strings.TrimPrefix(value, SEALED_PREFIX), ":", 2)
+	if len(fields) != 2 {
+		return "", ErrWrongKey
+	}
+
+	gcm, known := k.aeads[fields[0]]
+	if !known {
+		return "", fmt.Errorf("The value was sealed with the encryption key %s, which was not given.", fields[0])
+	}
+
+	sealed, err := keyEncoding.DecodeString(fields[1])
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", ErrWrongKey
+	}
+
+	opened, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+
+	return string(opened), nil
+}
+
+// UseKeys makes Open load the master keys from the key file at path or the
+// environment variable, see ReadKeys.
+func (dbm *DatabaseManager) UseKeys(path string, variable string) {
+	dbm.keyFile = path
+	dbm.keyVariable = variable
+}
+
+// Encrypted tells whether the titles and texts are written sealed.
+func (dbm *DatabaseManager) Encrypted() bool {
+	return dbm.keys != nil
+}
+
+// checkKeys fails unless every key that sealed a value in the database was
+// given and opens it.
+func (dbm *DatabaseManager) checkKeys(ctx context.Context) error {
+	rows, err := dbm.query(ctx, nil, SELECT_KEY_IDS_QS, len(SEALED_PREFIX)+1, KEY_ID_LENGTH, len(SEALED_PREFIX), SEALED_PREFIX)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", SELECT_KEY_IDS_QS)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var sample string
+
+		err = rows.Scan(&id, &sample)
+		if err != nil {
+			return err
+		}
+
+		if dbm.keys == nil {
+			return fmt.Errorf("%s is encrypted, give its encryption key in the key file or the environment.", dbm.path)
+		}
+		if _, known := dbm.keys.aeads[id]; !known {
+			return fmt.Errorf("%s has values sealed with the encryption key %s, which was not given. The given keys are wrong or incomplete.", dbm.path, id)
+		}
+
+		_, err = dbm.keys.open(sample)
+		if err != nil {
+			return fmt.Errorf("%s: %s", dbm.path, err)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (dbm *DatabaseManager) sealNote(n note.Note) (note.Note, error) {
+	title, err := dbm.keys.seal(n.Title())
+	if err != nil {
+		return n, err
+	}
+
+	text, err := dbm.keys.seal(n.Text())
+	if err != nil {
+		return n, err
+	}
+
+	return note.NewLocal(n.NoteID(), title, text, n.AddDate(), n.ChangeDate(), n.Version(), n.Tags()), nil
+}
+
+// openNote builds a note from the values of a row, opening sealed ones.
+func (dbm *DatabaseManager) openNote(noteID int, title string, text string, addDate int64, changeDate int64, version int, tags string) (note.Note, error) {
+	title, err := dbm.keys.open(title)
+	if err != nil {
+		dbm.log().Error("Opening the title of a note.", "error", err, "note", noteID)
+		return note.Note{}, err
+	}
+
+	text, err = dbm.keys.open(text)
+	if err != nil {
+		dbm.log().Error("Opening the text of a note.", "error", err, "note", noteID)
+		return note.Note{}, err
+	}
+
+	return note.NewLocal(noteID, title, text, time.Unix(addDate, 0), time.Unix(changeDate, 0), version, note.SplitTags(tags)), nil
+}
+
+type staleRow struct {
+	id    int64
+	title string
+	text  string
+}
+
+// reencryptBatch seals up to REENCRYPT_BATCH_SIZE rows of the notes or the
+// revisions again with the current key. It returns how many rows were
+// sealed with another key or not at all.
+func (dbm *DatabaseManager) reencryptBatch(ctx context.Context, selectQuery string, updateStatement string) (int, error) {
+	var prefix string = dbm.keys.currentPrefix()
+
+	transaction, err := dbm.begin(ctx)
+	if err != nil {
+		dbm.log().Error("Initializing re-encryption transaction.", "error", err)
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	rows, err := dbm.query(ctx, transaction, selectQuery, len(prefix), prefix, len(prefix), prefix, REENCRYPT_BATCH_SIZE)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", selectQuery)
+		return 0, err
+	}
+
+	var stale []staleRow
+	for rows.Next() {
+		var row staleRow
+		err = rows.Scan(&row.id, &row.title, &row.text)
+		if err != nil {
+			rows.Close()
+			dbm.log().Error("Scanning rows to re-encrypt.", "error", err)
+			return 0, err
+		}
+		stale = append(stale, row)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range stale {
+		var values []string = []string{row.title, row.text}
+
+		for i := range values {
+			values[i], err = dbm.keys.open(values[i])
+			if err == nil {
+				values[i], err = dbm.keys.seal(values[i])
+			}
+			if err != nil {
+				dbm.log().Error("Re-encrypting a row.", "error", err, "row", row.id)
+				return 0, err
+			}
+		}
+
+		_, err = dbm.exec(ctx, transaction, updateStatement, values[0], values[1], row.id, row.title, row.text)
+		if err != nil {
+			dbm.log().Error("Query failed.", "error", err, "query", updateStatement)
+			return 0, err
+		}
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		dbm.log().Error("Committing re-encryption transaction.", "error", err)
+		return 0, err
+	}
+
+	return len(stale), nil
+}
+
+// Reencrypt seals every title and text, of the notes and of their revisions,
+// with the current key, in batches with a pause in between so the server
+// keeps answering. Rows that were not sealed yet get sealed, which encrypts a
+// database that was written without a key. It returns the number of rows
+// re-encrypted. When stop is closed it returns after the current batch, the
+// rest is done on the next call.
+func (dbm *DatabaseManager) Reencrypt(ctx context.Context, pause time.Duration, stop <-chan struct{}) (int, error) {
+	var total int = 0
+
+	if dbm.keys == nil {
+		return total, ErrNoKey
+	}
+
+	for _, table := range [][]string{{SELECT_STALE_NOTES_QS, RESEAL_NOTE_EXEC}, {SELECT_STALE_REVISIONS_QS, RESEAL_REVISION_EXEC}} {
+		for {
+			count, err := dbm.reencryptBatch(ctx, table[0], table[1])
+			if err != nil {
+				return total, err
+			}
+
+			total += count
+			if count < REENCRYPT_BATCH_SIZE {
+				break
+			}
+
+			select {
+			case <-time.After(pause):
+			case <-stop:
+				return total, nil
+			}
+		}
+	}
+
+	return total, nil
+}
@@ -0,0 +1,159 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"note"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const TEST_KEYS_VARIABLE = "SHARENOTES_TEST_KEYS"
+
+func openWithKeys(t *testing.T, path string, keys ...string) (*DatabaseManager, error) {
+	t.Setenv(TEST_KEYS_VARIABLE, strings.Join(keys, ","))
+
+	var dbm DatabaseManager = New(path)
+	dbm.UseKeys("", TEST_KEYS_VARIABLE)
+
+	err := dbm.Open(context.Background())
+	if err != nil {
+		dbm.Close()
+		return nil, err
+	}
+	t.Cleanup(dbm.Close)
+
+	return &dbm, nil
+}
+
+func generateTestKey(t *testing.T) string {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestReencrypt writes notes without a key and with an old key, rotates to a
+// new key and checks that every row of the notes and revisions moved to it.
+func TestReencrypt(t *testing.T) {
+	var ctx context.Context = context.Background()
+	var path string = filepath.Join(t.TempDir(), "sndb.db")
+	var oldKey string = generateTestKey(t)
+	var newKey string = generateTestKey(t)
+
+	plain, err := openWithKeys(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = plain.CreateNote(ctx, note.New("plain", "written without a key")); err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+
+	// More notes than fit into one batch.
+	old, err := openWithKeys(t, path, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < REENCRYPT_BATCH_SIZE+10; i++ {
+		noteID, err := old.CreateNote(ctx, note.New(fmt.Sprintf("note %d", i), "sealed with the old key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%10 == 0 {
+			created, err := old.GetNote(ctx, noteID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = old.UpdateNote(ctx, note.NewLocal(noteID, created.Title(), "edited", created.AddDate(), time.Now(), created.Version(), nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	old.Close()
+
+	rotated, err := openWithKeys(t, path, newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows int
+	err = rotated.db.QueryRow(`select (select count(*) from notes) + (select count(*) from revisions)`).Scan(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := rotated.Reencrypt(ctx, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != rows {
+		t.Errorf("re-encrypted %d rows, expected %d", count, rows)
+	}
+
+	var prefix string = rotated.keys.currentPrefix()
+	var stale int
+	err = rotated.db.QueryRow(`select count(*)
+	     from (select title as value from notes
+	           union all select text from notes
+	           union all select title from revisions
+	           union all select text from revisions)
+	     where substr(value, 1, ?) != ?`, len(prefix), prefix).Scan(&stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale != 0 {
+		t.Errorf("%d values are not sealed with the new key", stale)
+	}
+
+	if count, err = rotated.Reencrypt(ctx, 0, nil); err != nil || count != 0 {
+		t.Errorf("re-encrypted %d rows a second time: %v", count, err)
+	}
+	rotated.Close()
+
+	current, err := openWithKeys(t, path, newKey)
+	if err != nil {
+		t.Fatalf("opening with the new key alone: %s", err)
+	}
+	notes, err := current.LoadNotes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != REENCRYPT_BATCH_SIZE+11 {
+		t.Errorf("%d notes after re-encryption, expected %d", len(notes), REENCRYPT_BATCH_SIZE+11)
+	}
+	for _, n := range notes {
+		if n.Title() == "plain" && n.Text() != "written without a key" {
+			t.Errorf("note %q reads %q", n.Title(), n.Text())
+		}
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	var ctx context.Context = context.Background()
+	var path string = filepath.Join(t.TempDir(), "sndb.db")
+	var key string = generateTestKey(t)
+
+	dbm, err := openWithKeys(t, path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dbm.CreateNote(ctx, note.New("sealed", "text")); err != nil {
+		t.Fatal(err)
+	}
+	dbm.Close()
+
+	if _, err = openWithKeys(t, path); err == nil {
+		t.Error("opened an encrypted database without a key")
+	}
+	if _, err = openWithKeys(t, path, generateTestKey(t)); err == nil {
+		t.Error("opened an encrypted database with a wrong key")
+	}
+	if _, err = openWithKeys(t, path, generateTestKey(t), key); err != nil {
+		t.Errorf("opening with the key as an old key: %s", err)
+	}
+}
@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"context"
+	"encryption"
+	"note"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The patterns of the filter queries are escaped with FILTER_ESCAPE, so % and
+// _ in a filter match themselves. The text of encrypted notes is ciphertext
+// and never matches a filter; the last argument of the text queries is the
+// pattern of encryption.ENCRYPTED_PREFIX.
+const SELECT_NOTES_WHERE_TITLE_QS = `select noteID, title, text, addDate, changeDate, version, tags 
+     from notes
+     where title like ? escape '\'
+     order by changeDate desc`
+
+const SELECT_NOTES_WHERE_TEXT_QS = `select noteID, title, text, addDate, changeDate, version, tags 
+     from notes
+     where text like ? escape '\' and text not like ? escape '\'
+     order by changeDate desc`
+
+const SELECT_NOTES_WHERE_BOTH_QS = `select noteID, title, text, addDate, changeDate, version, tags 
+     from notes
+     where title like ? escape '\' or (text like ? escape '\' and text not like ? escape '\')
+     order by changeDate desc`
+
+const FILTER_ESCAPE = `\`
+
+// A FilterField is the part of a note a NoteFilter looks at.
+type FilterField int
+
+const (
+	FILTER_TITLE FilterField = iota
+	FILTER_TEXT
+	FILTER_BOTH
+)
+
+// A NoteFilter selects the notes whose Field contains Pattern, ignoring the
+// case of ASCII letters like the LIKE of SQLite does.
+type NoteFilter struct {
+	Field   FilterField
+	Pattern string
+}
+
+// Matches filters a note that was read whole, e.g. by a storage that cannot
+// ask SQL.
+func (f NoteFilter) Matches(n note.Note) bool {
+	var pattern string = lowerASCII(f.Pattern)
+
+	var inTitle bool = strings.Contains(lowerASCII(n.Title()), pattern)
+	var inText bool = !encryption.IsEncrypted(n.Text()) && strings.Contains(lowerASCII(n.Text()), pattern)
+
+	switch f.Field {
+	case FILTER_TITLE:
+		return inTitle
+	case FILTER_TEXT:
+		return inText
+	default:
+		return inTitle || inText
+	}
+}
+
+// lowerASCII folds only A to Z, so a filter finds the same notes with and
+// without encryption at rest.
+func lowerASCII(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, value)
+}
+
+// query returns the filter query of f and its arguments.
+func (f NoteFilter) query() (string, []interface{}) {
+	var escaper *strings.Replacer = strings.NewReplacer(FILTER_ESCAPE, FILTER_ESCAPE+FILTER_ESCAPE, "%", FILTER_ESCAPE+"%", "_", FILTER_ESCAPE+"_")
+	var like string = "%" + escaper.Replace(f.Pattern) + "%"
+	var encrypted string = escaper.Replace(encryption.ENCRYPTED_PREFIX) + "%"
+
+	switch f.Field {
+	case FILTER_TITLE:
+		return SELECT_NOTES_WHERE_TITLE_QS, []interface{}{like}
+	case FILTER_TEXT:
+		return SELECT_NOTES_WHERE_TEXT_QS, []interface{}{like, encrypted}
+	default:
+		return SELECT_NOTES_WHERE_BOTH_QS, []interface{}{like, like, encrypted}
+	}
+}
+
+// FilterNotes runs filter through each note, newest change first.
+func FilterNotes(ctx context.Context, filter NoteFilter, eachNote func(ctx context.Context, function func(note.Note) error) error) ([]note.Note, error) {
+	var notes []note.Note
+
+	err := eachNote(ctx, func(n note.Note) error {
+		if filter.Matches(n) {
+			notes = append(notes, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].ChangeDate().After(notes[j].ChangeDate())
+	})
+
+	return notes, nil
+}
+
+// FilterNotes returns the notes filter selects, newest change first. SQL
+// cannot look into sealed values, so with encryption at rest the notes are
+// opened and filtered one by one.
+func (dbm *DatabaseManager) FilterNotes(ctx context.Context, filter NoteFilter) ([]note.Note, error) {
+	defer dbm.observe("FilterNotes", time.Now())
+
+	if dbm.keys != nil {
+		return FilterNotes(ctx, filter, dbm.EachNote)
+	}
+
+	var notes []note.Note
+
+	query, arguments := filter.query()
+
+	rows, err := dbm.query(ctx, nil, query, arguments...)
+	if err != nil {
+		dbm.log().Error("Query select notes where transaction.", "error", err)
+		return notes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var noteID int
+		var title string
+		var text string
+		var addDate int64
+		var changeDate int64
+		var version int
+		var tags string
+		err = rows.Scan(&noteID, &title, &text, &addDate, &changeDate, &version, &tags)
+		if err != nil {
+			dbm.log().Error("Scanning a filtered note.", "error", err)
+			return notes, err
+		}
+		notes = append(notes, note.NewLocal(noteID, title, text, time.Unix(addDate, 0), time.Unix(changeDate, 0), version, note.SplitTags(tags)))
+	}
+
+	return notes, rows.Err()
+}
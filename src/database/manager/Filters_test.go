@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"context"
+	"encryption"
+	"note"
+	"testing"
+)
+
+func TestFilterNotes(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+	var ctx context.Context = context.Background()
+
+	for _, n := range []note.Note{
+		note.New("Groceries", "milk, 100% butter"),
+		note.New("Budget", "100 percent of it"),
+		note.New("snake_case", "groceries later"),
+		note.New("Secret", encryption.ENCRYPTED_PREFIX+"butter"),
+		note.New("Äpfel", "ÖL"),
+	} {
+		if _, err := dbm.CreateNote(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		filter NoteFilter
+		titles []string
+	}{
+		{NoteFilter{Field: FILTER_TITLE, Pattern: "groc"}, []string{"Groceries"}},
+		{NoteFilter{Field: FILTER_TEXT, Pattern: "groc"}, []string{"snake_case"}},
+		{NoteFilter{Field: FILTER_BOTH, Pattern: "GROC"}, []string{"Groceries", "snake_case"}},
+		{NoteFilter{Field: FILTER_TEXT, Pattern: "0%"}, []string{"Groceries"}},
+		{NoteFilter{Field: FILTER_TITLE, Pattern: "e_c"}, []string{"snake_case"}},
+		{NoteFilter{Field: FILTER_TEXT, Pattern: "butter"}, []string{"Groceries"}},
+		{NoteFilter{Field: FILTER_BOTH, Pattern: "nothing"}, nil},
+		{NoteFilter{Field: FILTER_TITLE, Pattern: "Äpfel"}, []string{"Äpfel"}},
+		{NoteFilter{Field: FILTER_TITLE, Pattern: "äpfel"}, nil},
+		{NoteFilter{Field: FILTER_TEXT, Pattern: "Öl"}, []string{"Äpfel"}},
+	}
+
+	all, err := dbm.LoadNotes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		notes, err := dbm.FilterNotes(ctx, test.filter)
+		if err != nil {
+			t.Fatalf("%+v: %s", test.filter, err)
+		}
+
+		var inMemory int
+		for _, n := range all {
+			if test.filter.Matches(n) {
+				inMemory++
+			}
+		}
+
+		var titles = make(map[string]bool)
+		for _, n := range notes {
+			titles[n.Title()] = true
+		}
+		if len(notes) != len(test.titles) || inMemory != len(test.titles) {
+			t.Errorf("%+v: SQL found %d and Matches %d notes, expected %v", test.filter, len(notes), inMemory, test.titles)
+			continue
+		}
+		for _, title := range test.titles {
+			if !titles[title] {
+				t.Errorf("%+v: %q was not found", test.filter, title)
+			}
+		}
+	}
+}
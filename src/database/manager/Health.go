@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const COUNT_ALL_NOTES_QS = `select count(*)
+     from notes`
+
+// A QueryObserver is told how long each call of a DatabaseManager method
+// took, e.g. to export it as a metric.
+type QueryObserver func(method string, duration time.Duration)
+
+func (dbm *DatabaseManager) ObserveQueries(observer QueryObserver) {
+	dbm.observer = observer
+}
+
+// observe is deferred at the start of a method with the time it started.
+func (dbm *DatabaseManager) observe(method string, start time.Time) {
+	if dbm.observer != nil {
+		dbm.observer(method, time.Since(start))
+	}
+}
+
+// Ready reports whether the database can be reached and has all MIGRATIONS
+// applied.
+func (dbm *DatabaseManager) Ready(ctx context.Context) error {
+	defer dbm.observe("Ready", time.Now())
+
+	if dbm.db == nil {
+		return fmt.Errorf("The database is not open.")
+	}
+
+	var version int
+	err := dbm.queryRow(ctx, nil, SCHEMA_VERSION_QS).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	if version != len(MIGRATIONS) {
+		return fmt.Errorf("The database has schema version %d, expected %d.", version, len(MIGRATIONS))
+	}
+
+	return nil
+}
+
+func (dbm *DatabaseManager) CountNotes(ctx context.Context) (int, error) {
+	defer dbm.observe("CountNotes", time.Now())
+
+	var count int
+	err := dbm.queryRow(ctx, nil, COUNT_ALL_NOTES_QS).Scan(&count)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", COUNT_ALL_NOTES_QS)
+	}
+
+	return count, err
+}
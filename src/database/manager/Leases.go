@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const LOOKUP_LEASE_QS = `select holder, holderName, acquireDate, expiryDate
+     from leases
+     where noteID = ?`
+
+const ADD_LEASE_EXEC = `insert or replace into leases(noteID, holder, holderName, acquireDate, expiryDate)
+     values(?, ?, ?, ?, ?);`
+
+const RELEASE_LEASE_EXEC = `delete from leases
+     where noteID = ? and holder = ?;`
+
+const BREAK_LEASE_EXEC = `delete from leases
+     where noteID = ?;`
+
+const SWEEP_LEASES_EXEC = `delete from leases
+     where expiryDate <= ?;`
+
+var ErrLeaseHeld = errors.New("The note is being edited on another device.")
+
+// A Lease marks a note as being edited by one device until it expires or
+// is released. Holder identifies the device, HolderName is shown to others.
+type Lease struct {
+	NoteID      int
+	Holder      string
+	HolderName  string
+	AcquireDate time.Time
+	ExpiryDate  time.Time
+}
+
+func (dbm *DatabaseManager) lookupLease(ctx context.Context, transaction *sql.Tx, noteID int) (*Lease, error) {
+	var lease Lease = Lease{NoteID: noteID}
+	var acquireDate int64
+	var expiryDate int64
+
+	err := dbm.queryRow(ctx, transaction, LOOKUP_LEASE_QS, noteID).Scan(&lease.Holder, &lease.HolderName, &acquireDate, &expiryDate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", LOOKUP_LEASE_QS)
+		return nil, err
+	}
+
+	lease.AcquireDate = time.Unix(acquireDate, 0)
+	lease.ExpiryDate = time.Unix(expiryDate, 0)
+
+	return &lease, nil
+}
+
+// GetLease returns the lease on a note, or nil if nobody is editing it.
+func (dbm *DatabaseManager) GetLease(ctx context.Context, noteID int) (*Lease, error) {
+	defer dbm.observe("GetLease", time.Now())
+
+	lease, err := dbm.lookupLease(ctx, nil, noteID)
+	if err != nil || lease == nil || lease.ExpiryDate.After(time.Now()) {
+		return lease, err
+	}
+
+	return nil, nil
+}
+
+// AcquireLease takes or renews the lease on a note for holder. If another
+// device holds an unexpired lease, that lease is returned with ErrLeaseHeld.
+func (dbm *DatabaseManager) AcquireLease(ctx context.Context, noteID int, holder string, holderName string, duration time.Duration) (Lease, error) {
+	defer dbm.observe("AcquireLease", time.Now())
+
+	var now time.Time = time.Now()
+	var lease Lease = Lease{NoteID: noteID, Holder: holder, HolderName: holderName, AcquireDate: now, ExpiryDate: now.Add(duration)}
+
+	transaction, err := dbm.begin(ctx)
+	if err != nil {
+		dbm.log().Error("Initializing lease transaction.", "error", err)
+		return lease, err
+	}
+	defer transaction.Rollback()
+
+	current, err := dbm.lookupLease(ctx, transaction, noteID)
+	if err != nil {
+		return lease, err
+	}
+
+	if current != nil && current.ExpiryDate.After(now) {
+		if current.Holder != holder {
+			return *current, ErrLeaseHeld
+		}
+		lease.AcquireDate = current.AcquireDate
+	}
+
+	_, err = dbm.exec(ctx, transaction, ADD_LEASE_EXEC, noteID, holder, holderName, lease.AcquireDate.Unix(), lease.ExpiryDate.Unix())
+	if err != nil {
+		dbm.log().Error("Add lease in lease transaction.", "error", err)
+		return lease, err
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		dbm.log().Error("Committing lease transaction.", "error", err)
+	}
+
+	return lease, err
+}
+
+func (dbm *DatabaseManager) ReleaseLease(ctx context.Context, noteID int, holder string) error {
+	defer dbm.observe("ReleaseLease", time.Now())
+
+	_, err := dbm.exec(ctx, nil, RELEASE_LEASE_EXEC, noteID, holder)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", RELEASE_LEASE_EXEC)
+	}
+
+	return err
+}
+
+func (dbm *DatabaseManager) BreakLease(ctx context.Context, noteID int) error {
+	defer dbm.observe("BreakLease", time.Now())
+
+	_, err := dbm.exec(ctx, nil, BREAK_LEASE_EXEC, noteID)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", BREAK_LEASE_EXEC)
+	}
+
+	return err
+}
+
+func (dbm *DatabaseManager) SweepLeases(ctx context.Context) (int64, error) {
+	defer dbm.observe("SweepLeases", time.Now())
+
+	result, err := dbm.exec(ctx, nil, SWEEP_LEASES_EXEC, time.Now().Unix())
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", SWEEP_LEASES_EXEC)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,208 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"note"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// The index shows the first PREVIEW_LENGTH characters of a text.
+const PREVIEW_LENGTH = 300
+
+// Pages list the newest change first. A page after a cursor starts below the
+// note of the cursor, a page before it ends above it. The query asks for one
+// note more than the page holds to tell whether there is another page.
+const SELECT_NOTE_PAGE_QS = `select noteID, title, substr(text, 1, ?), addDate, changeDate, version, tags
+     from notes
+     where changeDate < ? or (changeDate = ? and noteID < ?)
+     order by changeDate desc, noteID desc
+     limit ?`
+
+const SELECT_NOTE_PAGE_BEFORE_QS = `select noteID, title, substr(text, 1, ?), addDate, changeDate, version, tags
+     from notes
+     where changeDate > ? or (changeDate = ? and noteID > ?)
+     order by changeDate, noteID
+     limit ?`
+
+var ErrBadCursor = errors.New("The page cursor is not valid.")
+
+// A NoteSummary is a note as the index lists it, with the start of its text
+// instead of all of it.
+type NoteSummary struct {
+	NoteID     int
+	Title      string
+	Preview    string
+	Truncated  bool
+	AddDate    time.Time
+	ChangeDate time.Time
+	Version    int
+	Tags       []string
+}
+
+// A PageCursor is the position of a note in the index. Its text form is
+// "<change date>-<note id>", the change date in Unix seconds.
+type PageCursor struct {
+	ChangeDate int64
+	NoteID     int
+}
+
+func (c PageCursor) String() string {
+	return fmt.Sprintf("%d-%d", c.ChangeDate, c.NoteID)
+}
+
+func ParsePageCursor(text string) (PageCursor, error) {
+	var cursor PageCursor
+
+	_, err := fmt.Sscanf(text, "%d-%d", &cursor.ChangeDate, &cursor.NoteID)
+	if err != nil || cursor.String() != text {
+		return PageCursor{}, ErrBadCursor
+	}
+
+	return cursor, nil
+}
+
+func (s NoteSummary) Cursor() PageCursor {
+	return PageCursor{ChangeDate: s.ChangeDate.Unix(), NoteID: s.NoteID}
+}
+
+// A PageQuery asks for Limit notes after After, before Before, or from the
+// newest one if neither is set.
+type PageQuery struct {
+	After  *PageCursor
+	Before *PageCursor
+	Limit  int
+}
+
+// A NotePage is a page of the index. A page after a cursor always has a
+// previous page, even if the notes on it were deleted since.
+type NotePage struct {
+	Notes       []NoteSummary
+	HasNext     bool
+	HasPrevious bool
+}
+
+// Summarize cuts the text of n to a preview.
+func Summarize(n note.Note) NoteSummary {
+	var summary NoteSummary = NoteSummary{NoteID: n.NoteID(), Title: n.Title(), Preview: n.Text(), AddDate: n.AddDate(), ChangeDate: n.ChangeDate(), Version: n.Version(), Tags: n.Tags()}
+
+	if utf8.RuneCountInString(summary.Preview) > PREVIEW_LENGTH {
+		summary.Preview = string([]rune(summary.Preview)[:PREVIEW_LENGTH])
+		summary.Truncated = true
+	}
+
+	return summary
+}
+
+// finishPage drops the note that was read to look ahead and puts a page read
+// backwards in order.
+func finishPage(summaries []NoteSummary, query PageQuery) NotePage {
+	var page NotePage
+	var more bool = len(summaries) > query.Limit
+	if more {
+		summaries = summaries[:query.Limit]
+	}
+
+	if query.Before != nil {
+		for i, j := 0, len(summaries)-1; i < j; i, j = i+1, j-1 {
+			summaries[i], summaries[j] = summaries[j], summaries[i]
+		}
+		page.HasNext = true
+		page.HasPrevious = more
+	} else {
+		page.HasNext = more
+		page.HasPrevious = query.After != nil
+	}
+
+	page.Notes = summaries
+	return page
+}
+
+// PageOf pages summaries kept in memory, e.g. by the git storage, like
+// ListNotes pages the database.
+func PageOf(summaries []NoteSummary, query PageQuery) NotePage {
+	sort.SliceStable(summaries, func(i, j int) bool {
+		a, b := summaries[i].Cursor(), summaries[j].Cursor()
+		return a.ChangeDate > b.ChangeDate || (a.ChangeDate == b.ChangeDate && a.NoteID > b.NoteID)
+	})
+
+	var selected []NoteSummary
+	if query.Before != nil {
+		for i := len(summaries) - 1; i >= 0 && len(selected) <= query.Limit; i-- {
+			if c := summaries[i].Cursor(); c.ChangeDate > query.Before.ChangeDate || (c.ChangeDate == query.Before.ChangeDate && c.NoteID > query.Before.NoteID) {
+				selected = append(selected, summaries[i])
+			}
+		}
+	} else {
+		for i := 0; i < len(summaries) && len(selected) <= query.Limit; i++ {
+			if c := summaries[i].Cursor(); query.After == nil || c.ChangeDate < query.After.ChangeDate || (c.ChangeDate == query.After.ChangeDate && c.NoteID < query.After.NoteID) {
+				selected = append(selected, summaries[i])
+			}
+		}
+	}
+
+	return finishPage(selected, query)
+}
+
+// ListNotes returns a page of the index. Only the start of each text is
+// read; with encryption at rest the texts are sealed, so they are read whole
+// and cut after opening them.
+func (dbm *DatabaseManager) ListNotes(ctx context.Context, query PageQuery) (NotePage, error) {
+	defer dbm.observe("ListNotes", time.Now())
+
+	var pageQuery string = SELECT_NOTE_PAGE_QS
+	var cursor PageCursor = PageCursor{ChangeDate: math.MaxInt64, NoteID: math.MaxInt}
+	if query.Before != nil {
+		pageQuery = SELECT_NOTE_PAGE_BEFORE_QS
+		cursor = *query.Before
+	} else if query.After != nil {
+		cursor = *query.After
+	}
+
+	// One character more than the preview tells whether it was cut.
+	var textLength int = PREVIEW_LENGTH + 1
+	if dbm.keys != nil {
+		textLength = math.MaxInt32
+	}
+
+	var summaries []NoteSummary
+
+	rows, err := dbm.query(ctx, nil, pageQuery, textLength, cursor.ChangeDate, cursor.ChangeDate, cursor.NoteID, query.Limit+1)
+	if err != nil {
+		dbm.log().Error("Query failed.", "error", err, "query", pageQuery)
+		return NotePage{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var noteID int
+		var title string
+		var text string
+		var addDate int64
+		var changeDate int64
+		var version int
+		var tags string
+		err = rows.Scan(&noteID, &title, &text, &addDate, &changeDate, &version, &tags)
+		if err != nil {
+			dbm.log().Error("Scanning a page of notes.", "error", err)
+			return NotePage{}, err
+		}
+		n, err := dbm.openNote(noteID, title, text, addDate, changeDate, version, tags)
+		if err != nil {
+			return NotePage{}, err
+		}
+		summaries = append(summaries, Summarize(n))
+	}
+
+	err = rows.Err()
+	if err != nil {
+		dbm.log().Error("Reading a page of notes.", "error", err)
+		return NotePage{}, err
+	}
+
+	return finishPage(summaries, query), nil
+}
@@ -0,0 +1,290 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const LAST_REVISION_QS = `select coalesce(max(revisionID), 0), coalesce(max(recordDate), 0)
+     from revisions`
+
+const REVISION_EXISTS_QS = `select count(*)
+     from revisions
+     where revisionID = ?`
+
+const SELECT_REVISIONS_BETWEEN_QS = `select revisionID, noteID, version, operation, title, text, addDate, changeDate, tags, recordDate
+     from revisions
+     where revisionID > ? and recordDate <= ?
+     order by revisionID`
+
+const RESTORE_NOTE_EXEC = `insert or replace into notes(noteID, title, text, addDate, changeDate, version, tags, sequence)
+     values(?, ?, ?, ?, ?, ?, ?, ?);`
+
+const RESTORE_REVISION_EXEC = `insert into revisions(revisionID, noteID, version, operation, title, text, addDate, changeDate, tags, recordDate)
+     values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+const SELECT_NOTE_IDS_QS = `select noteID
+     from notes`
+
+const RAISE_SEQUENCE_EXEC = `update changeSequence
+     set sequence = max(sequence, ?);`
+
+const SET_NOTE_SEQUENCE_EXEC = `update notes
+     set sequence = ?
+     where noteID = ?;`
+
+const COUNT_NOTES_QS = `select count(*)
+     from notes`
+
+const CLEAR_LEASES_EXEC = `delete from leases;`
+
+type revision struct {
+	revisionID int64
+	noteID     int
+	version    int
+	operation  string
+	title      string
+	text       string
+	addDate    int64
+	changeDate int64
+	tags       string
+	recordDate int64
+}
+
+type RestoreReport struct {
+	Replayed     int
+	Notes        int
+	BackupDate   time.Time
+	LastRecorded time.Time
+}
+
+// ReplayRevisions rolls the database at path, a restored backup, forward to
+// the moment at. The revisions come from the database at logPath, normally
+// the live sndb.db, which only has to be readable. Every revision the backup
+// does not have yet and that was recorded at or before at is applied in
+// order.
+func ReplayRevisions(ctx context.Context, path string, logPath string, at time.Time) (RestoreReport, error) {
+	var report RestoreReport
+
+	// The revisions are copied as they are stored, sealed or not, so no
+	// encryption keys are needed.
+	var restored DatabaseManager = NewFile(path, false)
+	restored.raw = true
+	err := restored.Open(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer restored.Close()
+
+	var revisionLog DatabaseManager = NewFile(logPath, true)
+	revisionLog.raw = true
+	err = revisionLog.Open(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer revisionLog.Close()
+
+	var lastRevisionID int64
+	var lastRecordDate int64
+
+	err = restored.db.QueryRowContext(ctx, LAST_REVISION_QS).Scan(&lastRevisionID, &lastRecordDate)
+	if err != nil {
+		slog.Error("Query failed.", "error", err, "query", LAST_REVISION_QS)
+		return report, err
+	}
+	report.BackupDate = time.Unix(lastRecordDate, 0)
+
+	if at.Unix() < lastRecordDate {
+		return report, fmt.Errorf("The backup already has changes from %s, pick an older backup.", report.BackupDate.Format("2006-01-02 15:04:05"))
+	}
+
+	if lastRevisionID > 0 {
+		var count int
+		err = revisionLog.db.QueryRowContext(ctx, REVISION_EXISTS_QS, lastRevisionID).Scan(&count)
+		if err != nil {
+			slog.Error("Query failed.", "error", err, "query", REVISION_EXISTS_QS)
+			return report, err
+		}
+		if count == 0 {
+			return report, fmt.Errorf("%s does not continue the history of the backup.", logPath)
+		}
+	}
+
+	rows, err := revisionLog.db.QueryContext(ctx, SELECT_REVISIONS_BETWEEN_QS, lastRevisionID, at.Unix())
+	if err != nil {
+		slog.Error("Query failed.", "error", err, "query", SELECT_REVISIONS_BETWEEN_QS)
+		return report, err
+	}
+
+	var revisions []revision
+	for rows.Next() {
+		var r revision
+		err = rows.Scan(&r.revisionID, &r.noteID, &r.version, &r.operation, &r.title, &r.text, &r.addDate, &r.changeDate, &r.tags, &r.recordDate)
+		if err != nil {
+			rows.Close()
+			slog.Error("Scanning revisions.", "error", err)
+			return report, err
+		}
+		revisions = append(revisions, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return report, err
+	}
+
+	transaction, err := restored.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Initializing restore transaction.", "error", err)
+		return report, err
+	}
+	defer transaction.Rollback()
+
+	for _, r := range revisions {
+		err = restored.replayRevision(ctx, transaction, r)
+		if err != nil {
+			return report, err
+		}
+		report.Replayed++
+		report.LastRecorded = time.Unix(r.recordDate, 0)
+	}
+
+	err = restored.resequence(ctx, transaction, revisionLog.db)
+	if err != nil {
+		return report, err
+	}
+
+	// Nobody is editing the notes of a restored database.
+	_, err = transaction.ExecContext(ctx, CLEAR_LEASES_EXEC)
+	if err != nil {
+		slog.Error("Query failed.", "error", err, "query", CLEAR_LEASES_EXEC)
+		return report, err
+	}
+
+	err = transaction.QueryRowContext(ctx, COUNT_NOTES_QS).Scan(&report.Notes)
+	if err != nil {
+		return report, err
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		slog.Error("Committing restore transaction.", "error", err)
+	}
+
+	return report, err
+}
+
+func (dbm *DatabaseManager) replayRevision(ctx context.Context, transaction *sql.Tx, r revision) error {
+	sequence, err := dbm.nextSequence(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	if r.operation == REVISION_DELETE {
+		_, err = transaction.ExecContext(ctx, DELETE_NOTE_EXEC, r.noteID)
+		if err == nil {
+			_, err = transaction.ExecContext(ctx, ADD_TOMBSTONE_EXEC, r.noteID, sequence, r.recordDate)
+		}
+	} else {
+		_, err = transaction.ExecContext(ctx, RESTORE_NOTE_EXEC, r.noteID, r.title, r.text, r.addDate, r.changeDate, r.version, r.tags, sequence)
+		if err == nil {
+			_, err = transaction.ExecContext(ctx, DELETE_TOMBSTONE_EXEC, r.noteID)
+		}
+	}
+	if err != nil {
+		slog.Error("Replaying a revision.", "error", err, "revision", r.revisionID)
+		return err
+	}
+
+	_, err = transaction.ExecContext(ctx, RESTORE_REVISION_EXEC, r.revisionID, r.noteID, r.version, r.operation, r.title, r.text, r.addDate, r.changeDate, r.tags, r.recordDate)
+	if err != nil {
+		slog.Error("Copying a revision.", "error", err, "revision", r.revisionID)
+	}
+
+	return err
+}
+
+func selectNoteIDs(ctx context.Context, query func(context.Context, string, ...interface{}) (*sql.Rows, error)) (map[int]bool, error) {
+	rows, err := query(ctx, SELECT_NOTE_IDS_QS)
+	if err != nil {
+		slog.Error("Query failed.", "error", err, "query", SELECT_NOTE_IDS_QS)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var noteIDs map[int]bool = make(map[int]bool)
+	for rows.Next() {
+		var noteID int
+		err = rows.Scan(&noteID)
+		if err != nil {
+			return nil, err
+		}
+		noteIDs[noteID] = true
+	}
+
+	return noteIDs, rows.Err()
+}
+
+// resequence makes the restore a change that devices pick up from the change
+// feed of the live database: every restored note gets a sequence above the
+// live one, and notes that only the live database has get a tombstone.
+func (dbm *DatabaseManager) resequence(ctx context.Context, transaction *sql.Tx, live *sql.DB) error {
+	var liveSequence int64
+
+	err := live.QueryRowContext(ctx, CURRENT_SEQUENCE_QS).Scan(&liveSequence)
+	if err != nil {
+		slog.Error("Query failed.", "error", err, "query", CURRENT_SEQUENCE_QS)
+		return err
+	}
+
+	_, err = transaction.ExecContext(ctx, RAISE_SEQUENCE_EXEC, liveSequence)
+	if err != nil {
+		slog.Error("Query failed.", "error", err, "query", RAISE_SEQUENCE_EXEC)
+		return err
+	}
+
+	restoredNoteIDs, err := selectNoteIDs(ctx, transaction.QueryContext)
+	if err != nil {
+		return err
+	}
+
+	liveNoteIDs, err := selectNoteIDs(ctx, live.QueryContext)
+	if err != nil {
+		return err
+	}
+
+	for noteID := range restoredNoteIDs {
+		sequence, err := dbm.nextSequence(ctx, transaction)
+		if err != nil {
+			return err
+		}
+
+		_, err = transaction.ExecContext(ctx, SET_NOTE_SEQUENCE_EXEC, sequence, noteID)
+		if err != nil {
+			slog.Error("Query failed.", "error", err, "query", SET_NOTE_SEQUENCE_EXEC)
+			return err
+		}
+	}
+
+	for noteID := range liveNoteIDs {
+		if restoredNoteIDs[noteID] {
+			continue
+		}
+
+		sequence, err := dbm.nextSequence(ctx, transaction)
+		if err != nil {
+			return err
+		}
+
+		_, err = transaction.ExecContext(ctx, ADD_TOMBSTONE_EXEC, noteID, sequence, time.Now().Unix())
+		if err != nil {
+			slog.Error("Query failed.", "error", err, "query", ADD_TOMBSTONE_EXEC)
+			return err
+		}
+	}
+
+	return nil
+}
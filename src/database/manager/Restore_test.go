@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"note"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReplayRevisions backs up two notes, edits one, deletes the other and
+// edits the first again, then restores the backup to a moment between the
+// delete and the second edit.
+func TestReplayRevisions(t *testing.T) {
+	var dbm *DatabaseManager = openTestDatabase(t)
+	var ctx context.Context = context.Background()
+	var backupPath string = filepath.Join(t.TempDir(), "backup.db")
+
+	keptID, err := dbm.CreateNote(ctx, note.New("kept", "original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletedID, err := dbm.CreateNote(ctx, note.New("deleted", "text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dbm.Backup(ctx, backupPath); err != nil {
+		t.Fatal(err)
+	}
+	var backupRevisionID int64
+	var backupDate int64
+	if err = dbm.db.QueryRow(LAST_REVISION_QS).Scan(&backupRevisionID, &backupDate); err != nil {
+		t.Fatal(err)
+	}
+
+	edit := func(text string) {
+		current, err := dbm.GetNote(ctx, keptID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dbm.UpdateNote(ctx, note.NewLocal(keptID, current.Title(), text, current.AddDate(), time.Now(), current.Version(), nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edit("first edit")
+	deleted, err := dbm.GetNote(ctx, deletedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dbm.DeleteNote(ctx, deletedID, deleted.Version()); err != nil {
+		t.Fatal(err)
+	}
+	edit("second edit")
+
+	// The changes all happen within a second, so they are spread out by
+	// hand: ten seconds apart, starting ten seconds after the backup.
+	_, err = dbm.db.Exec(`update revisions set recordDate = ? + (revisionID - ?) * 10 where revisionID > ?`, backupDate, backupRevisionID, backupRevisionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var between time.Time = time.Unix(backupDate+25, 0)
+
+	if _, err = ReplayRevisions(ctx, backupPath, dbm.path, time.Unix(backupDate-1, 0)); err == nil {
+		t.Error("replayed to a moment before the backup")
+	}
+
+	report, err := ReplayRevisions(ctx, backupPath, dbm.path, between)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Replayed != 2 || report.Notes != 1 {
+		t.Errorf("replayed %d revisions to %d notes, expected 2 revisions and 1 note", report.Replayed, report.Notes)
+	}
+	if !report.LastRecorded.Equal(time.Unix(backupDate+20, 0)) {
+		t.Errorf("last replayed revision recorded at %s, expected %s", report.LastRecorded, time.Unix(backupDate+20, 0))
+	}
+
+	var restored DatabaseManager = New(backupPath)
+	if err = restored.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	kept, err := restored.GetNote(ctx, keptID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Text() != "first edit" {
+		t.Errorf("restored text %q, expected %q", kept.Text(), "first edit")
+	}
+	if _, err = restored.GetNote(ctx, deletedID); err != sql.ErrNoRows {
+		t.Errorf("getting the deleted note: %v, expected %v", err, sql.ErrNoRows)
+	}
+}
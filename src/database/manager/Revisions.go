@@ -0,0 +1,53 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"note"
+	"time"
+)
+
+const REVISION_ADD = "add"
+const REVISION_UPDATE = "update"
+const REVISION_DELETE = "delete"
+
+const ADD_REVISION_EXEC = `insert into revisions(noteID, version, operation, title, text, addDate, changeDate, tags, recordDate)
+     select noteID, version, ?, title, text, addDate, changeDate, tags, ?
+     from notes
+     where noteID = ?;`
+
+const LOOKUP_REVISION_QS = `select title, text, addDate, changeDate, tags
+     from revisions
+     where noteID = ? and version = ? and operation != 'delete'
+     order by revisionID desc
+     limit 1`
+
+func (dbm *DatabaseManager) recordRevision(ctx context.Context, transaction *sql.Tx, noteID int, operation string) error {
+	_, err := dbm.exec(ctx, transaction, ADD_REVISION_EXEC, operation, time.Now().Unix(), noteID)
+	if err != nil {
+		dbm.log().Error("Recording revision.", "error", err)
+	}
+
+	return err
+}
+
+// GetRevision returns a note as it was saved in the given version.
+func (dbm *DatabaseManager) GetRevision(ctx context.Context, noteID int, version int) (note.Note, error) {
+	defer dbm.observe("GetRevision", time.Now())
+
+	var title string
+	var text string
+	var addDate int64
+	var changeDate int64
+	var tags string
+
+	err := dbm.queryRow(ctx, nil, LOOKUP_REVISION_QS, noteID, version).Scan(&title, &text, &addDate, &changeDate, &tags)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			dbm.log().Error("Query failed.", "error", err, "query", LOOKUP_REVISION_QS)
+		}
+		return note.Note{}, err
+	}
+
+	return dbm.openNote(noteID, title, text, addDate, changeDate, version, tags)
+}
@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// In WAL mode readers never wait for a writer, only writers wait for each
+// other, up to BUSY_TIMEOUT. Write transactions take the write lock when they
+// begin, so they wait for it there instead of failing halfway. The timeout is
+// shorter than the time running requests get when the server shuts down.
+const BUSY_TIMEOUT = 10 * time.Second
+
+const ENABLE_WAL_EXEC = `pragma journal_mode = wal;`
+
+// A statementCache keeps every query prepared for as long as the pool is
+// open. The copies WithLogger makes share it.
+type statementCache struct {
+	mutex      sync.Mutex
+	statements map[string]*sql.Stmt
+}
+
+func newStatementCache() *statementCache {
+	return &statementCache{statements: make(map[string]*sql.Stmt)}
+}
+
+func (cache *statementCache) close() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for query, statement := range cache.statements {
+		statement.Close()
+		delete(cache.statements, query)
+	}
+}
+
+// statement prepares query the first time it is run. Only the constant
+// queries of this package are run, so the cache does not grow without end.
+func (dbm *DatabaseManager) statement(ctx context.Context, query string) (*sql.Stmt, error) {
+	dbm.statements.mutex.Lock()
+	defer dbm.statements.mutex.Unlock()
+
+	if statement, found := dbm.statements.statements[query]; found {
+		return statement, nil
+	}
+
+	statement, err := dbm.db.PrepareContext(ctx, query)
+	if err != nil {
+		dbm.log().Error("Preparing a statement.", "error", err, "query", query)
+		return nil, err
+	}
+	dbm.statements.statements[query] = statement
+
+	return statement, nil
+}
+
+// prepared returns the statement of query, within transaction unless it is
+// nil.
+func (dbm *DatabaseManager) prepared(ctx context.Context, transaction *sql.Tx, query string) (*sql.Stmt, error) {
+	statement, err := dbm.statement(ctx, query)
+	if err != nil || transaction == nil {
+		return statement, err
+	}
+
+	return transaction.StmtContext(ctx, statement), nil
+}
+
+func (dbm *DatabaseManager) begin(ctx context.Context) (*sql.Tx, error) {
+	return dbm.db.BeginTx(ctx, nil)
+}
+
+func (dbm *DatabaseManager) exec(ctx context.Context, transaction *sql.Tx, query string, arguments ...interface{}) (sql.Result, error) {
+	statement, err := dbm.prepared(ctx, transaction, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return statement.ExecContext(ctx, arguments...)
+}
+
+func (dbm *DatabaseManager) query(ctx context.Context, transaction *sql.Tx, query string, arguments ...interface{}) (*sql.Rows, error) {
+	statement, err := dbm.prepared(ctx, transaction, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return statement.QueryContext(ctx, arguments...)
+}
+
+// A row is the result of queryRow. An error preparing the statement is
+// returned by Scan, like the errors of the query.
+type row struct {
+	row *sql.Row
+	err error
+}
+
+func (r row) Scan(destinations ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.row.Scan(destinations...)
+}
+
+func (dbm *DatabaseManager) queryRow(ctx context.Context, transaction *sql.Tx, query string, arguments ...interface{}) row {
+	statement, err := dbm.prepared(ctx, transaction, query)
+	if err != nil {
+		return row{err: err}
+	}
+
+	return row{row: statement.QueryRowContext(ctx, arguments...)}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"davfs"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+const DAV_PREFIX = "/dav"
+
+var davFileSystem *davfs.FileSystem
+
+var davHandler *webdav.Handler
+
+// setUpWebDAV is called once the storage is chosen, the preview command
+// serves another database than the one dbManager starts with.
+func setUpWebDAV() {
+	davFileSystem = davfs.New(dbManager)
+
+	davHandler = &webdav.Handler{
+		Prefix:     routes.BasePath() + DAV_PREFIX,
+		FileSystem: davFileSystem,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(request *http.Request, err error) {
+			if err != nil {
+				loggerFor(request).Error("WebDAV request failed.", "error", err, "method", request.Method, "path", request.URL.Path)
+			}
+		}}
+}
+
+// davPreconditionFailed checks If-Match against the version of the note a
+// PUT or DELETE goes to, the webdav package only looks at the If header.
+func davPreconditionFailed(writer http.ResponseWriter, request *http.Request, name string) bool {
+	var header string = request.Header.Get("If-Match")
+	if header == "" {
+		return false
+	}
+
+	noteID, found := davFileSystem.NoteID(request.Context(), name)
+	if !found {
+		http.Error(writer, "Note not found.", http.StatusPreconditionFailed)
+		return true
+	}
+
+	foundNote, err := storeFor(request).GetNote(request.Context(), noteID)
+	if err == sql.ErrNoRows {
+		http.Error(writer, "Note not found.", http.StatusPreconditionFailed)
+		return true
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	version, err := parseIfMatch(header, foundNote)
+	if err != nil || version != foundNote.Version() {
+		writer.Header().Set("ETag", noteETag(foundNote))
+		http.Error(writer, "The note was changed in the meantime.", http.StatusPreconditionFailed)
+		return true
+	}
+
+	return false
+}
+
+// davSaveScratch handles editors that save by writing a temporary file and
+// moving it over the note. The webdav package would delete the note before
+// the move, which drops its id, history and tags.
+func (s *server) davSaveScratch(writer http.ResponseWriter, request *http.Request, name string) bool {
+	if !davFileSystem.IsScratch(name) || request.Header.Get("Overwrite") == "F" {
+		return false
+	}
+
+	destination, err := url.Parse(request.Header.Get("Destination"))
+	if err != nil {
+		return false
+	}
+
+	target, isDav := davPath(destination.Path)
+	if !isDav {
+		return false
+	}
+
+	if _, found := davFileSystem.NoteID(request.Context(), target); !found {
+		return false
+	}
+
+	if s.davLeasedElsewhere(writer, request, target) {
+		return true
+	}
+
+	err = davFileSystem.Rename(request.Context(), name, target)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// davRefuseUpload answers uploads the file system would not keep: files over
+// davfs.MAX_FILE_SIZE, and scratch files when there are too many. The webdav
+// package would answer both with 405. The body is read here to tell its size.
+func davRefuseUpload(writer http.ResponseWriter, request *http.Request, name string) bool {
+	if request.ContentLength > davfs.MAX_FILE_SIZE {
+		http.Error(writer, davfs.ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(request.Body, davfs.MAX_FILE_SIZE+1))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	if len(body) > davfs.MAX_FILE_SIZE {
+		http.Error(writer, davfs.ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return true
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if davFileSystem.ScratchFull(name) {
+		http.Error(writer, davfs.ErrNoScratchSpace.Error(), http.StatusInsufficientStorage)
+		return true
+	}
+
+	return false
+}
+
+// davLeasedElsewhere refuses to change a note that is being edited in a
+// browser.
+func (s *server) davLeasedElsewhere(writer http.ResponseWriter, request *http.Request, name string) bool {
+	noteID, found := davFileSystem.NoteID(request.Context(), name)
+	if !found {
+		return false
+	}
+
+	lease, err := s.leasedElsewhere(request, noteID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return true
+	} else if lease != nil {
+		http.Error(writer, leaseHeldMessage(lease), http.StatusConflict)
+		return true
+	}
+
+	return false
+}
+
+// davPath returns the name of a file from its path, base path included.
+func davPath(urlPath string) (string, bool) {
+	var prefix string = routes.BasePath() + DAV_PREFIX
+
+	if urlPath != prefix && !strings.HasPrefix(urlPath, prefix+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(urlPath, prefix), true
+}
+
+// webdavHandler is not rate limited like the other handlers, WebDAV clients
+// send a burst of requests for every directory they open.
+func (s *server) webdavHandler(writer http.ResponseWriter, request *http.Request) {
+	// The router strips the base path, the webdav package needs it for the
+	// paths in its answers and in Destination headers.
+	if routes.BasePath() != "" {
+		request = request.Clone(request.Context())
+		request.URL.Path = routes.BasePath() + request.URL.Path
+	}
+
+	name, isDav := davPath(request.URL.Path)
+	if !isDav {
+		http.NotFound(writer, request)
+		return
+	}
+
+	switch request.Method {
+	case "PUT":
+		if davPreconditionFailed(writer, request, name) || s.davLeasedElsewhere(writer, request, name) || davRefuseUpload(writer, request, name) {
+			return
+		}
+	case "DELETE":
+		if davPreconditionFailed(writer, request, name) || s.davLeasedElsewhere(writer, request, name) {
+			return
+		}
+	case "MOVE":
+		if s.davLeasedElsewhere(writer, request, name) || s.davSaveScratch(writer, request, name) {
+			return
+		}
+	}
+
+	davHandler.ServeHTTP(writer, request)
+}
@@ -0,0 +1,765 @@
+package davfs
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"export"
+	"fmt"
+	"frontmatter"
+	"golang.org/x/net/webdav"
+	"io"
+	"note"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const NOTE_EXTENSION = ".md"
+
+// Files are read into memory before they are saved, so none may be larger
+// than MAX_FILE_SIZE. Scratch files only live in memory: there are at most
+// MAX_SCRATCH_FILES of them, and each is dropped SCRATCH_FILE_LIFETIME after
+// it was last written.
+const MAX_FILE_SIZE = 4 << 20
+const MAX_SCRATCH_FILES = 100
+const SCRATCH_FILE_LIFETIME = time.Hour
+
+var ErrFileTooLarge = errors.New("The file is too large.")
+var ErrNoScratchSpace = errors.New("There are too many temporary files.")
+
+var noteFileName = regexp.MustCompile(`^([0-9]+)(-[^/]*)?\.md$`)
+
+type Store interface {
+	EachNote(ctx context.Context, function func(note.Note) error) error
+	GetNote(ctx context.Context, noteID int) (note.Note, error)
+	CreateNote(ctx context.Context, n note.Note) (int, error)
+	UpdateNote(ctx context.Context, n note.Note) error
+	DeleteNote(ctx context.Context, noteID int, version int) error
+}
+
+// A FileSystem shows every note as "<id>-<slug>.md" with front matter, in
+// the root and in one directory per tag. It implements webdav.FileSystem.
+//
+// Files that are not notes, like the temporary files editors save through
+// or the hidden files file managers leave behind, are only kept in memory.
+// Renaming such a file onto a note saves it into the note.
+type FileSystem struct {
+	store   Store
+	mutex   sync.Mutex
+	scratch map[string]*scratchFile
+	aliases map[string]int
+	folders map[string]bool
+}
+
+type scratchFile struct {
+	content []byte
+	modTime time.Time
+}
+
+func New(store Store) *FileSystem {
+	return &FileSystem{
+		store:   store,
+		scratch: make(map[string]*scratchFile),
+		aliases: make(map[string]int),
+		folders: make(map[string]bool)}
+}
+
+// resolved is what a path points to.
+type resolved struct {
+	dir    bool
+	tag    string
+	noteID int
+	name   string
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// resolve maps a path onto the root, a tag directory or a note. Notes are
+// found by the id at the start of their name, so a note can still be
+// opened under its old name after its title changed.
+func (fs *FileSystem) resolve(ctx context.Context, name string) (resolved, error) {
+	var parts []string = splitPath(name)
+
+	if len(parts) == 0 {
+		return resolved{dir: true}, nil
+	}
+	if len(parts) > 2 {
+		return resolved{}, os.ErrNotExist
+	}
+
+	var result resolved = resolved{name: parts[len(parts)-1]}
+	if len(parts) == 2 {
+		result.tag = parts[0]
+	}
+
+	fs.mutex.Lock()
+	noteID, aliased := fs.aliases["/"+strings.Join(parts, "/")]
+	fs.mutex.Unlock()
+
+	if match := noteFileName.FindStringSubmatch(result.name); match != nil || aliased {
+		if !aliased {
+			noteID, _ = strconv.Atoi(match[1])
+		}
+
+		n, err := fs.store.GetNote(ctx, noteID)
+		if err == sql.ErrNoRows {
+			return resolved{}, os.ErrNotExist
+		} else if err != nil {
+			return resolved{}, err
+		}
+		if result.tag != "" && !n.HasTag(result.tag) {
+			return resolved{}, os.ErrNotExist
+		}
+
+		result.noteID = noteID
+		return result, nil
+	}
+
+	if len(parts) == 1 {
+		exists, err := fs.tagExists(ctx, parts[0])
+		if err != nil {
+			return resolved{}, err
+		}
+		if exists {
+			return resolved{dir: true, tag: parts[0], name: parts[0]}, nil
+		}
+	}
+
+	return result, os.ErrNotExist
+}
+
+func (fs *FileSystem) tagExists(ctx context.Context, tag string) (bool, error) {
+	fs.mutex.Lock()
+	var found bool = fs.folders[tag]
+	fs.mutex.Unlock()
+
+	if found {
+		return true, nil
+	}
+
+	err := fs.store.EachNote(ctx, func(n note.Note) error {
+		if n.HasTag(tag) {
+			found = true
+			return io.EOF
+		}
+		return nil
+	})
+	if err == io.EOF {
+		err = nil
+	}
+
+	return found, err
+}
+
+func render(n note.Note) []byte {
+	var buffer bytes.Buffer
+	export.WriteMarkdown(&buffer, n)
+	return buffer.Bytes()
+}
+
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	var parts []string = splitPath(name)
+	if len(parts) != 1 || strings.HasSuffix(parts[0], NOTE_EXTENSION) {
+		return os.ErrPermission
+	}
+
+	if _, err := fs.resolve(ctx, name); err == nil {
+		return os.ErrExist
+	}
+
+	fs.mutex.Lock()
+	fs.folders[parts[0]] = true
+	fs.mutex.Unlock()
+
+	return nil
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fs.mutex.Lock()
+	scratch, found := fs.scratch[path.Clean("/"+name)]
+	fs.mutex.Unlock()
+
+	if found {
+		return &fileInfo{name: path.Base(name), size: int64(len(scratch.content)), modTime: scratch.modTime}, nil
+	}
+
+	target, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.dir {
+		return &fileInfo{name: target.name, dir: true, modTime: time.Now()}, nil
+	}
+
+	n, err := fs.store.GetNote(ctx, target.noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return noteInfo(target.name, n), nil
+}
+
+func noteInfo(name string, n note.Note) *fileInfo {
+	return &fileInfo{name: name, size: int64(len(render(n))), modTime: n.ChangeDate(), version: n.Version()}
+}
+
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	var cleaned string = path.Clean("/" + name)
+	var writing bool = flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	fs.mutex.Lock()
+	scratch, found := fs.scratch[cleaned]
+	fs.mutex.Unlock()
+
+	if found {
+		var file *noteFile = &noteFile{fs: fs, ctx: ctx, path: cleaned, writing: writing,
+			info: &fileInfo{name: path.Base(cleaned), size: int64(len(scratch.content)), modTime: scratch.modTime}}
+		if flag&os.O_TRUNC == 0 {
+			file.reader = bytes.NewReader(scratch.content)
+		}
+		return file, nil
+	}
+
+	target, err := fs.resolve(ctx, cleaned)
+	if err == os.ErrNotExist && flag&os.O_CREATE != 0 {
+		var parts []string = splitPath(cleaned)
+		if len(parts) == 2 {
+			if _, err := fs.resolve(ctx, "/"+parts[0]); err != nil {
+				return nil, os.ErrNotExist
+			}
+		}
+		return &noteFile{fs: fs, ctx: ctx, path: cleaned, writing: true, info: &fileInfo{name: path.Base(cleaned), modTime: time.Now()}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if target.dir {
+		if writing {
+			return nil, os.ErrPermission
+		}
+		return &noteFile{fs: fs, ctx: ctx, path: cleaned, info: &fileInfo{name: target.name, dir: true, modTime: time.Now()}}, nil
+	}
+
+	n, err := fs.store.GetNote(ctx, target.noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	var file *noteFile = &noteFile{fs: fs, ctx: ctx, path: cleaned, writing: writing, noteID: n.NoteID(), version: n.Version(), info: noteInfo(target.name, n)}
+	if flag&os.O_TRUNC == 0 {
+		file.reader = bytes.NewReader(render(n))
+	}
+
+	return file, nil
+}
+
+// isScratch tells files that should never become notes.
+func isScratch(name string) bool {
+	var base string = path.Base(name)
+
+	return strings.HasPrefix(base, ".") || strings.HasPrefix(base, "~") || strings.HasSuffix(base, "~") ||
+		strings.HasSuffix(base, ".tmp") || strings.HasSuffix(base, ".swp") || !strings.HasSuffix(base, NOTE_EXTENSION)
+}
+
+// save stores what was written to a file: into the note it belongs to, into
+// a new note, or into memory for scratch files.
+func (fs *FileSystem) save(ctx context.Context, file *noteFile) error {
+	if file.noteID == 0 && isScratch(file.path) {
+		fs.mutex.Lock()
+		defer fs.mutex.Unlock()
+
+		fs.expireScratch()
+		if _, found := fs.scratch[file.path]; !found && len(fs.scratch) >= MAX_SCRATCH_FILES {
+			return ErrNoScratchSpace
+		}
+
+		fs.scratch[file.path] = &scratchFile{content: append([]byte(nil), file.buffer.Bytes()...), modTime: time.Now()}
+		return nil
+	}
+
+	matter, body, _, err := frontmatter.Parse(file.buffer.String())
+	if err != nil {
+		return err
+	}
+
+	var parts []string = splitPath(file.path)
+	var tags []string = matter.Tags
+
+	if file.noteID == 0 {
+		var title string = matter.Title
+		if title == "" {
+			title = strings.TrimSuffix(path.Base(file.path), NOTE_EXTENSION)
+		}
+		if len(parts) == 2 {
+			tags = append(tags, parts[0])
+		}
+
+		noteID, err := fs.store.CreateNote(ctx, note.NewLocal(0, title, body, time.Now(), time.Now(), 0, note.SplitTags(strings.Join(tags, note.TAG_SEPARATOR))))
+		if err != nil {
+			return err
+		}
+
+		fs.mutex.Lock()
+		fs.aliases[file.path] = noteID
+		delete(fs.scratch, file.path)
+		fs.mutex.Unlock()
+
+		file.noteID = noteID
+		file.version = 1
+	} else {
+		current, err := fs.store.GetNote(ctx, file.noteID)
+		if err != nil {
+			return err
+		}
+
+		var title string = matter.Title
+		if title == "" {
+			title = current.Title()
+		}
+		if tags == nil {
+			tags = current.Tags()
+		}
+
+		if title == current.Title() && body == current.Text() && note.JoinTags(tags) == note.JoinTags(current.Tags()) {
+			file.version = current.Version()
+		} else {
+			err = fs.store.UpdateNote(ctx, note.NewLocal(file.noteID, title, body, current.AddDate(), time.Now(), file.version, tags))
+			if err != nil {
+				return err
+			}
+			file.version++
+		}
+	}
+
+	file.info.version = file.version
+	file.info.size = int64(file.buffer.Len())
+	file.info.modTime = time.Now()
+
+	return nil
+}
+
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	var cleaned string = path.Clean("/" + name)
+
+	fs.mutex.Lock()
+	_, found := fs.scratch[cleaned]
+	delete(fs.scratch, cleaned)
+	fs.mutex.Unlock()
+
+	if found {
+		return nil
+	}
+
+	target, err := fs.resolve(ctx, cleaned)
+	if err != nil {
+		return err
+	}
+
+	if target.dir && target.tag == "" {
+		return os.ErrPermission
+	}
+
+	// Removing a tag directory or a note inside one only takes the tag away.
+	if target.dir {
+		fs.mutex.Lock()
+		delete(fs.folders, target.tag)
+		fs.mutex.Unlock()
+
+		return fs.retag(ctx, target.tag, "")
+	}
+
+	if target.tag != "" {
+		return fs.moveNote(ctx, target.noteID, target.tag, "", "")
+	}
+
+	n, err := fs.store.GetNote(ctx, target.noteID)
+	if err != nil {
+		return err
+	}
+
+	return fs.store.DeleteNote(ctx, n.NoteID(), n.Version())
+}
+
+// retag renames a tag on every note, or removes it if to is empty.
+func (fs *FileSystem) retag(ctx context.Context, from string, to string) error {
+	var tagged []note.Note
+
+	err := fs.store.EachNote(ctx, func(n note.Note) error {
+		if n.HasTag(from) {
+			tagged = append(tagged, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, n := range tagged {
+		err = fs.moveNote(ctx, n.NoteID(), from, to, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveNote swaps the tag from for the tag to and gives the note a new
+// title if it was renamed.
+func (fs *FileSystem) moveNote(ctx context.Context, noteID int, from string, to string, title string) error {
+	n, err := fs.store.GetNote(ctx, noteID)
+	if err != nil {
+		return err
+	}
+
+	var tags []string
+	for _, tag := range n.Tags() {
+		if tag != from {
+			tags = append(tags, tag)
+		}
+	}
+	if to != "" {
+		tags = append(tags, to)
+	}
+
+	if title == "" {
+		title = n.Title()
+	}
+
+	if title == n.Title() && note.JoinTags(tags) == note.JoinTags(n.Tags()) {
+		return nil
+	}
+
+	return fs.store.UpdateNote(ctx, note.NewLocal(noteID, title, n.Text(), n.AddDate(), time.Now(), n.Version(), tags))
+}
+
+// titleFromName returns the title a file name asks for, or "" if the name
+// still fits the current title.
+func titleFromName(name string, n note.Note) string {
+	if name == export.FileName(n) {
+		return ""
+	}
+
+	var title string = strings.TrimSuffix(name, NOTE_EXTENSION)
+	if match := noteFileName.FindStringSubmatch(name); match != nil {
+		title = strings.TrimPrefix(match[2], "-")
+		if title == "" || title == export.Slug(n.Title()) {
+			return ""
+		}
+	}
+
+	return title
+}
+
+// expireScratch drops the scratch files nobody wrote to for
+// SCRATCH_FILE_LIFETIME. The mutex has to be held.
+func (fs *FileSystem) expireScratch() {
+	for name, scratch := range fs.scratch {
+		if time.Since(scratch.modTime) > SCRATCH_FILE_LIFETIME {
+			delete(fs.scratch, name)
+		}
+	}
+}
+
+// ScratchFull tells whether writing name would need one scratch file more
+// than there is room for.
+func (fs *FileSystem) ScratchFull(name string) bool {
+	var cleaned string = path.Clean("/" + name)
+	if !isScratch(cleaned) {
+		return false
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.expireScratch()
+	_, found := fs.scratch[cleaned]
+	return !found && len(fs.scratch) >= MAX_SCRATCH_FILES
+}
+
+// IsScratch tells whether name is a file that is only kept in memory.
+func (fs *FileSystem) IsScratch(name string) bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	_, found := fs.scratch[path.Clean("/"+name)]
+	return found
+}
+
+// NoteID returns the note a path points to.
+func (fs *FileSystem) NoteID(ctx context.Context, name string) (int, bool) {
+	target, err := fs.resolve(ctx, name)
+	if err != nil || target.dir {
+		return 0, false
+	}
+	return target.noteID, true
+}
+
+func (fs *FileSystem) Rename(ctx context.Context, oldName string, newName string) error {
+	var oldPath string = path.Clean("/" + oldName)
+	var newPath string = path.Clean("/" + newName)
+	var newParts []string = splitPath(newPath)
+
+	fs.mutex.Lock()
+	scratch, isScratchFile := fs.scratch[oldPath]
+	fs.mutex.Unlock()
+
+	if isScratchFile {
+		// Editors save by writing a temporary file and renaming it over the
+		// original.
+		file, err := fs.OpenFile(ctx, newPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		file.Write(scratch.content)
+
+		err = file.Close()
+		if err != nil {
+			return err
+		}
+
+		fs.mutex.Lock()
+		delete(fs.scratch, oldPath)
+		fs.mutex.Unlock()
+		return nil
+	}
+
+	source, err := fs.resolve(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+
+	if source.dir {
+		if source.tag == "" || len(newParts) != 1 {
+			return os.ErrPermission
+		}
+
+		fs.mutex.Lock()
+		if fs.folders[source.tag] {
+			delete(fs.folders, source.tag)
+			fs.folders[newParts[0]] = true
+		}
+		fs.mutex.Unlock()
+
+		return fs.retag(ctx, source.tag, newParts[0])
+	}
+
+	if len(newParts) == 0 || len(newParts) > 2 {
+		return os.ErrPermission
+	}
+
+	var destinationTag string = ""
+	if len(newParts) == 2 {
+		destinationTag = newParts[0]
+		if _, err := fs.resolve(ctx, "/"+destinationTag); err != nil {
+			return os.ErrNotExist
+		}
+	}
+
+	n, err := fs.store.GetNote(ctx, source.noteID)
+	if err != nil {
+		return err
+	}
+
+	var title string = titleFromName(newParts[len(newParts)-1], n)
+
+	var from string = source.tag
+	var to string = destinationTag
+	if from == to {
+		from = ""
+		to = ""
+	} else if from == "" {
+		// Copying into a tag directory keeps the note in the root.
+		from = to
+	}
+
+	fs.mutex.Lock()
+	if _, aliased := fs.aliases[oldPath]; aliased {
+		delete(fs.aliases, oldPath)
+	}
+	fs.aliases[newPath] = source.noteID
+	fs.mutex.Unlock()
+
+	return fs.moveNote(ctx, source.noteID, from, to, title)
+}
+
+func (fs *FileSystem) readDir(ctx context.Context, dirPath string, tag string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	var tags map[string]bool = make(map[string]bool)
+
+	err := fs.store.EachNote(ctx, func(n note.Note) error {
+		for _, t := range n.Tags() {
+			tags[t] = true
+		}
+
+		if tag == "" || n.HasTag(tag) {
+			infos = append(infos, noteInfo(export.FileName(n), n))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mutex.Lock()
+	for folder := range fs.folders {
+		tags[folder] = true
+	}
+	fs.expireScratch()
+	for scratchPath, scratch := range fs.scratch {
+		if path.Dir(scratchPath) == dirPath {
+			infos = append(infos, &fileInfo{name: path.Base(scratchPath), size: int64(len(scratch.content)), modTime: scratch.modTime})
+		}
+	}
+	fs.mutex.Unlock()
+
+	if tag == "" {
+		var names []string
+		for t := range tags {
+			names = append(names, t)
+		}
+		sort.Strings(names)
+
+		for _, t := range names {
+			infos = append(infos, &fileInfo{name: t, dir: true, modTime: time.Now()})
+		}
+	}
+
+	return infos, nil
+}
+
+// A noteFile is saved when it is closed and listed when it is read, after
+// OpenFile returned; both run with the context of the request that opened it.
+type noteFile struct {
+	fs      *FileSystem
+	ctx     context.Context
+	path    string
+	info    *fileInfo
+	reader  *bytes.Reader
+	buffer  bytes.Buffer
+	writing bool
+	noteID  int
+	version int
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (f *noteFile) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, io.EOF
+	}
+	return f.reader.Read(p)
+}
+
+func (f *noteFile) Seek(offset int64, whence int) (int64, error) {
+	if f.reader == nil {
+		if f.info.dir || (offset == 0 && whence != io.SeekCurrent) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("Seek is not supported while writing.")
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *noteFile) Write(p []byte) (int, error) {
+	if !f.writing {
+		return 0, os.ErrPermission
+	}
+	if f.buffer.Len()+len(p) > MAX_FILE_SIZE {
+		return 0, ErrFileTooLarge
+	}
+	return f.buffer.Write(p)
+}
+
+func (f *noteFile) Close() error {
+	if !f.writing {
+		return nil
+	}
+	f.writing = false
+
+	return f.fs.save(f.ctx, f)
+}
+
+func (f *noteFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *noteFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, os.ErrInvalid
+	}
+
+	if !f.listed {
+		var tag string = ""
+		if parts := splitPath(f.path); len(parts) == 1 {
+			tag = parts[0]
+		}
+
+		entries, err := f.fs.readDir(f.ctx, f.path, tag)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = entries
+		f.listed = true
+	}
+
+	if count <= 0 {
+		var entries []os.FileInfo = f.entries
+		f.entries = nil
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+
+	var entries []os.FileInfo = f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	version int
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ETag ties the ETag of a note to its version, like the notes API does.
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.version == 0 {
+		return fmt.Sprintf("\"%x%x\"", fi.modTime.UnixNano(), fi.size), nil
+	}
+	return fmt.Sprintf("\"%d\"", fi.version), nil
+}
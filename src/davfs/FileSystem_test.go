@@ -0,0 +1,190 @@
+package davfs
+
+import (
+	"context"
+	"database/manager"
+	"fmt"
+	"io/ioutil"
+	"note"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func openTestStore(t *testing.T) *manager.DatabaseManager {
+	var dbm manager.DatabaseManager = manager.New(filepath.Join(t.TempDir(), "sndb.db"))
+
+	err := dbm.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dbm.Close)
+
+	return &dbm
+}
+
+// writeFile saves content like a PUT does.
+func writeFile(ctx context.Context, fs *FileSystem, name string, content string) error {
+	file, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write([]byte(content)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func describeNotes(t *testing.T, store *manager.DatabaseManager) []string {
+	notes, err := store.LoadNotes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var described []string
+	for _, n := range notes {
+		described = append(described, fmt.Sprintf("%d %s [%s] %s", n.NoteID(), n.Title(), note.JoinTags(n.Tags()), strings.TrimSpace(n.Text())))
+	}
+	sort.Strings(described)
+	return described
+}
+
+// TestFileSystem starts every case with note 1, "first", tagged "work", and
+// checks the notes after one or more file operations.
+func TestFileSystem(t *testing.T) {
+	var tests = []struct {
+		name      string
+		operation func(ctx context.Context, fs *FileSystem) error
+		notes     []string
+	}{
+		{"write a new note", func(ctx context.Context, fs *FileSystem) error {
+			return writeFile(ctx, fs, "/new.md", "hello")
+		}, []string{"1 first [work] original", "2 new [] hello"}},
+		{"write into a tag directory", func(ctx context.Context, fs *FileSystem) error {
+			return writeFile(ctx, fs, "/work/tagged.md", "hello")
+		}, []string{"1 first [work] original", "2 tagged [work] hello"}},
+		{"edit a note", func(ctx context.Context, fs *FileSystem) error {
+			return writeFile(ctx, fs, "/1-first.md", "edited")
+		}, []string{"1 first [work] edited"}},
+		{"save through a temporary file", func(ctx context.Context, fs *FileSystem) error {
+			if err := writeFile(ctx, fs, "/1-first.md.tmp", "saved"); err != nil {
+				return err
+			}
+			return fs.Rename(ctx, "/1-first.md.tmp", "/1-first.md")
+		}, []string{"1 first [work] saved"}},
+		{"rename a note", func(ctx context.Context, fs *FileSystem) error {
+			return fs.Rename(ctx, "/1-first.md", "/1-renamed.md")
+		}, []string{"1 renamed [work] original"}},
+		{"move into a new tag directory", func(ctx context.Context, fs *FileSystem) error {
+			if err := fs.Mkdir(ctx, "/home", 0755); err != nil {
+				return err
+			}
+			return fs.Rename(ctx, "/work/1-first.md", "/home/1-first.md")
+		}, []string{"1 first [home] original"}},
+		{"rename a tag directory", func(ctx context.Context, fs *FileSystem) error {
+			return fs.Rename(ctx, "/work", "/job")
+		}, []string{"1 first [job] original"}},
+		{"remove from a tag directory", func(ctx context.Context, fs *FileSystem) error {
+			return fs.RemoveAll(ctx, "/work/1-first.md")
+		}, []string{"1 first [] original"}},
+		{"delete a note", func(ctx context.Context, fs *FileSystem) error {
+			return fs.RemoveAll(ctx, "/1-first.md")
+		}, nil},
+		{"scratch files stay out of the notes", func(ctx context.Context, fs *FileSystem) error {
+			return writeFile(ctx, fs, "/.DS_Store", "junk")
+		}, []string{"1 first [work] original"}},
+	}
+
+	for _, test := range tests {
+		var ctx context.Context = context.Background()
+		var store *manager.DatabaseManager = openTestStore(t)
+
+		first := note.New("first", "original")
+		first.SetTags([]string{"work"})
+		if _, err := store.CreateNote(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+
+		var fs *FileSystem = New(store)
+
+		if err := test.operation(ctx, fs); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		var notes []string = describeNotes(t, store)
+		if strings.Join(notes, "|") != strings.Join(test.notes, "|") {
+			t.Errorf("%s: notes %q, expected %q", test.name, notes, test.notes)
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	var ctx context.Context = context.Background()
+	var store *manager.DatabaseManager = openTestStore(t)
+
+	first := note.New("first", "original")
+	first.SetTags([]string{"work"})
+	if _, err := store.CreateNote(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateNote(ctx, note.New("second", "untagged")); err != nil {
+		t.Fatal(err)
+	}
+
+	var fs *FileSystem = New(store)
+
+	var tests = []struct {
+		directory string
+		entries   string
+	}{
+		{"/", "1-first.md|2-second.md|work/"},
+		{"/work", "1-first.md"},
+	}
+
+	for _, test := range tests {
+		directory, err := fs.OpenFile(ctx, test.directory, os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos, err := directory.Readdir(0)
+		directory.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, info := range infos {
+			if info.IsDir() {
+				names = append(names, info.Name()+"/")
+			} else {
+				names = append(names, info.Name())
+			}
+		}
+		if strings.Join(names, "|") != test.entries {
+			t.Errorf("%s: listed %q, expected %q", test.directory, strings.Join(names, "|"), test.entries)
+		}
+	}
+
+	// A note reads as Markdown with front matter and can be found under an
+	// older name, as long as the id is right.
+	file, err := fs.OpenFile(ctx, "/1-old-title.md", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "---\n") || !strings.Contains(string(content), "original") {
+		t.Errorf("1-old-title.md reads %q", content)
+	}
+
+	if _, err = fs.Stat(ctx, "/3-missing.md"); !os.IsNotExist(err) {
+		t.Errorf("stat of a missing note: %v, expected %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/manager"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"note"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const EDIT_LEASE_RENEW_INTERVAL = 30 * time.Second
+const EDIT_LEASE_SWEEP_INTERVAL = time.Minute
+
+const DEVICE_COOKIE_NAME = "sharenotes_device"
+const DEVICE_COOKIE_MAX_AGE = 10 * 365 * 24 * 60 * 60
+
+type noteLockedData struct {
+	Note    note.Note
+	Lease   manager.Lease
+	Token   synchronizedToken
+	Unsaved string
+}
+
+// deviceOf identifies the browser a request came from by a long-lived
+// cookie and describes it by its user agent for other devices to see.
+func (s *server) deviceOf(writer http.ResponseWriter, request *http.Request) (string, string) {
+	var deviceID string
+
+	cookie, err := request.Cookie(DEVICE_COOKIE_NAME)
+	if err == nil && cookie.Value != "" {
+		deviceID = cookie.Value
+	} else {
+		deviceID = randomStringLength(32)
+		http.SetCookie(writer, &http.Cookie{
+			Name:     DEVICE_COOKIE_NAME,
+			Value:    deviceID,
+			Path:     routes.BasePath() + "/",
+			MaxAge:   DEVICE_COOKIE_MAX_AGE,
+			HttpOnly: true,
+			Secure:   s.settings.TLS,
+			SameSite: http.SameSiteLaxMode})
+	}
+
+	return deviceID, deviceName(request.UserAgent())
+}
+
+func deviceName(userAgent string) string {
+	var browser string = "a browser"
+	var system string = "an unknown device"
+
+	switch {
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(userAgent, "curl/"):
+		browser = "curl"
+	}
+
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		system = "Android"
+	case strings.Contains(userAgent, "iPhone"):
+		system = "iPhone"
+	case strings.Contains(userAgent, "iPad"):
+		system = "iPad"
+	case strings.Contains(userAgent, "Windows"):
+		system = "Windows"
+	case strings.Contains(userAgent, "Mac OS"):
+		system = "Mac"
+	case strings.Contains(userAgent, "Linux"):
+		system = "Linux"
+	}
+
+	return browser + " on " + system
+}
+
+func synchronizedTokenFromRequest(request *http.Request) (synchronizedToken, error) {
+	id, err := strconv.ParseUint(request.FormValue("share_note_token_id"), 10, 64)
+
+	return synchronizedToken{ID: id, TokenString: request.FormValue("share_note_token_string")}, err
+}
+
+func checkSynchronizedToken(writer http.ResponseWriter, request *http.Request) bool {
+	token, err := synchronizedTokenFromRequest(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if !sidManager.synchronizedTokenIsValid(token) {
+		http.Error(writer, "Token was invlaid.", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// leasedElsewhere returns the edit lease of a note when a device other than
+// the one of the request holds it, so a change now would overwrite an edit in
+// progress there. Only browsers on the edit page hold leases, API, sync and
+// WebDAV clients are turned away while any device edits the note.
+func (s *server) leasedElsewhere(request *http.Request, noteID int) (*manager.Lease, error) {
+	if !s.settings.EditLeases {
+		return nil, nil
+	}
+
+	lease, err := storeFor(request).GetLease(request.Context(), noteID)
+	if err != nil || lease == nil {
+		return nil, err
+	}
+
+	cookie, err := request.Cookie(DEVICE_COOKIE_NAME)
+	if err == nil && cookie.Value == lease.Holder {
+		return nil, nil
+	}
+
+	return lease, nil
+}
+
+func leaseHeldMessage(lease *manager.Lease) string {
+	return fmt.Sprintf("Now being edited on %s since %s.", lease.HolderName, lease.AcquireDate.Format(time.Stamp))
+}
+
+// refuseLeasedChange answers a change from a page with 409 and the NoteLocked
+// page when another device holds the lease, e.g. after it broke the lease of
+// this one. unsaved is the text that was not saved, for the user to copy.
+func (s *server) refuseLeasedChange(writer http.ResponseWriter, request *http.Request, n note.Note, unsaved string) bool {
+	lease, err := s.leasedElsewhere(request, n.NoteID())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if lease == nil {
+		return false
+	}
+
+	// The unsaved text of an encrypted note is plain text.
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusConflict)
+
+	err = templates.ExecuteTemplate(writer, "NoteLocked.html", noteLockedData{Note: n, Lease: *lease, Token: sidManager.generateSynchronizedToken(), Unsaved: unsaved})
+	if err != nil {
+		loggerFor(request).Error("Rendering the note locked page.", "error", err)
+	}
+	return true
+}
+
+func (s *server) releaseEditLease(writer http.ResponseWriter, request *http.Request, noteID int) {
+	if !s.settings.EditLeases {
+		return
+	}
+
+	deviceID, _ := s.deviceOf(writer, request)
+	storeFor(request).ReleaseLease(request.Context(), noteID, deviceID)
+}
+
+func (s *server) renewLeaseHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+	if !checkSynchronizedToken(writer, request) {
+		return
+	}
+
+	deviceID, holderName := s.deviceOf(writer, request)
+
+	lease, err := storeFor(request).AcquireLease(request.Context(), noteID, deviceID, holderName, s.settings.EditLeaseDuration)
+	if err == manager.ErrLeaseHeld {
+		http.Error(writer, leaseHeldMessage(&lease), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) releaseLeaseHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+	if !checkSynchronizedToken(writer, request) {
+		return
+	}
+
+	s.releaseEditLease(writer, request, noteID)
+
+	http.Redirect(writer, request, routes.URL("note", noteID), http.StatusFound)
+}
+
+func breakLeaseHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+	if !checkSynchronizedToken(writer, request) {
+		return
+	}
+
+	err := storeFor(request).BreakLease(request.Context(), noteID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(writer, request, routes.URL("editNote", noteID), http.StatusFound)
+}
+
+func sweepEditLeases(stop <-chan struct{}) {
+	var ticker *time.Ticker = time.NewTicker(EDIT_LEASE_SWEEP_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		swept, err := dbManager.SweepLeases(context.Background())
+		if err != nil {
+			slog.Error("Sweeping expired edit leases.", "error", err)
+		} else if swept > 0 {
+			slog.Info("Swept expired edit leases.", "count", swept)
+		}
+	}
+}
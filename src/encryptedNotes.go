@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encryption"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Every passphrase that is tried costs a scrypt run. A client gets
+// MAX_DECRYPT_FAILURES wrong passphrases per note within
+// DECRYPT_FAILURE_WINDOW, then it has to wait until the window is over.
+const MAX_DECRYPT_FAILURES = 5
+const DECRYPT_FAILURE_WINDOW = 15 * time.Minute
+
+var ErrTooManyAttempts = errors.New("Too many wrong passphrases, please try again later.")
+
+type decryptFailures struct {
+	count int
+	since time.Time
+}
+
+var failedDecryptions map[string]*decryptFailures = make(map[string]*decryptFailures)
+var failedDecryptionsMutex sync.Mutex
+
+// decryptNote opens the text of an encrypted note with a passphrase the
+// client sent, unless the client used up its attempts on the note. Every
+// attempt is counted before the decryption and given back when it succeeds,
+// so that concurrent requests cannot get past the limit.
+func decryptNote(request *http.Request, noteID int, text string, passphrase string) (string, error) {
+	var key string = fmt.Sprintf("%s/%d", clientIP(request), noteID)
+	var now time.Time = time.Now()
+
+	failedDecryptionsMutex.Lock()
+	for other, otherFailures := range failedDecryptions {
+		if now.Sub(otherFailures.since) > DECRYPT_FAILURE_WINDOW {
+			delete(failedDecryptions, other)
+		}
+	}
+	failures, found := failedDecryptions[key]
+	if !found {
+		failures = &decryptFailures{since: now}
+		failedDecryptions[key] = failures
+	}
+	if failures.count >= MAX_DECRYPT_FAILURES {
+		failedDecryptionsMutex.Unlock()
+		return "", ErrTooManyAttempts
+	}
+	failures.count++
+	failedDecryptionsMutex.Unlock()
+
+	plainText, err := encryption.Decrypt(text, passphrase)
+
+	failedDecryptionsMutex.Lock()
+	defer failedDecryptionsMutex.Unlock()
+
+	if err == nil {
+		delete(failedDecryptions, key)
+	} else if err != encryption.ErrWrongPassphrase && failedDecryptions[key] == failures {
+		failures.count--
+		if failures.count == 0 {
+			delete(failedDecryptions, key)
+		}
+	}
+
+	return plainText, err
+}
+
+// decryptErrorStatus is the status of an answer to a passphrase that did
+// not open a note.
+func decryptErrorStatus(writer http.ResponseWriter, err error) int {
+	if err == ErrTooManyAttempts {
+		writer.Header().Set("Retry-After", strconv.Itoa(int(DECRYPT_FAILURE_WINDOW/time.Second)))
+		return http.StatusTooManyRequests
+	}
+	return http.StatusForbidden
+}
+
+// decryptNoteHandler shows an encrypted note with the passphrase posted from
+// its note page. The decrypted text is only rendered, never stored, and the
+// page, which carries the passphrase, is not cached.
+func decryptNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+	if request.Method != "POST" {
+		http.Redirect(writer, request, routes.URL("note", noteID), http.StatusFound)
+		return
+	}
+
+	foundNote, err := storeFor(request).GetNote(request.Context(), noteID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var details htmlNote = noteToHtmlNote(foundNote)
+	var passphrase string = request.FormValue("passphrase")
+
+	writer.Header().Set("Cache-Control", "no-store")
+
+	text, err := decryptNote(request, noteID, foundNote.Text(), passphrase)
+	if err != nil {
+		details.Error = err.Error()
+		writer.WriteHeader(decryptErrorStatus(writer, err))
+	} else {
+		details.Decrypted = true
+		details.Passphrase = passphrase
+		details.Text = template.HTML(template.HTMLEscapeString(text))
+	}
+
+	err = templates.ExecuteTemplate(writer, "Note.html", details)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,146 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted text starts with ENCRYPTED_PREFIX, followed by the scrypt
+// parameters, the salt, the nonce and the sealed text, separated by colons.
+// The parameters are stored with every note so they can be raised later
+// without breaking older notes.
+const ENCRYPTED_PREFIX = "sharenotes-encrypted:v1:"
+
+const SCRYPT_LOG_N = 15
+const SCRYPT_R = 8
+const SCRYPT_P = 1
+const KEY_LENGTH = 32
+const SALT_LENGTH = 16
+
+// Limits for stored parameters, so a forged note cannot make the server
+// spend minutes or gigabytes on deriving a key.
+const MAX_SCRYPT_LOG_N = 20
+const MAX_SCRYPT_R = 16
+const MAX_SCRYPT_P = 4
+
+var ErrWrongPassphrase = errors.New("The passphrase is wrong.")
+var ErrNoPassphrase = errors.New("A passphrase is needed.")
+var ErrDamaged = errors.New("The encrypted text is damaged.")
+
+var encoding = base64.RawStdEncoding
+
+// IsEncrypted tells whether text was written by Encrypt.
+func IsEncrypted(text string) bool {
+	return strings.HasPrefix(text, ENCRYPTED_PREFIX)
+}
+
+func newGCM(passphrase string, salt []byte, logN int, r int, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<uint(logN), r, p, KEY_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypt seals text with AES-256-GCM under a key derived from passphrase
+// by scrypt. Every call uses a new salt and nonce.
+func Encrypt(text string, passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", ErrNoPassphrase
+	}
+
+	var salt []byte = make([]byte, SALT_LENGTH)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(passphrase, salt, SCRYPT_LOG_N, SCRYPT_R, SCRYPT_P)
+	if err != nil {
+		return "", err
+	}
+
+	var nonce []byte = make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	// The parameters are authenticated along with the text.
+	var header string = fmt.Sprintf("%s%d:%d:%d", ENCRYPTED_PREFIX, SCRYPT_LOG_N, SCRYPT_R, SCRYPT_P)
+	var sealed []byte = gcm.Seal(nil, nonce, []byte(text), []byte(header))
+
+	return strings.Join([]string{header, encoding.EncodeToString(salt), encoding.EncodeToString(nonce), encoding.EncodeToString(sealed)}, ":"), nil
+}
+
+// Decrypt opens text written by Encrypt. A wrong passphrase and a damaged
+// text both give ErrWrongPassphrase, GCM cannot tell them apart.
+func Decrypt(text string, passphrase string) (string, error) {
+	if !IsEncrypted(text) {
+		return "", errors.New("The text is not encrypted.")
+	}
+	if passphrase == "" {
+		return "", ErrNoPassphrase
+	}
+
+	var fields []string = strings.Split(strings.TrimPrefix(text, ENCRYPTED_PREFIX), ":")
+	if len(fields) != 6 {
+		return "", ErrDamaged
+	}
+
+	var parameters []int = make([]int, 3)
+	for i := range parameters {
+		value, err := strconv.Atoi(fields[i])
+		if err != nil || value < 1 {
+			return "", ErrDamaged
+		}
+		parameters[i] = value
+	}
+	if parameters[0] > MAX_SCRYPT_LOG_N || parameters[1] > MAX_SCRYPT_R || parameters[2] > MAX_SCRYPT_P {
+		return "", errors.New("The encrypted text asks for too expensive key derivation.")
+	}
+
+	salt, err := encoding.DecodeString(fields[3])
+	if err != nil {
+		return "", ErrDamaged
+	}
+	nonce, err := encoding.DecodeString(fields[4])
+	if err != nil {
+		return "", ErrDamaged
+	}
+	sealed, err := encoding.DecodeString(fields[5])
+	if err != nil {
+		return "", ErrDamaged
+	}
+
+	gcm, err := newGCM(passphrase, salt, parameters[0], parameters[1], parameters[2])
+	if err != nil {
+		return "", err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return "", ErrDamaged
+	}
+
+	var header string = ENCRYPTED_PREFIX + strings.Join(fields[:3], ":")
+
+	opened, err := gcm.Open(nil, nonce, sealed, []byte(header))
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+
+	return string(opened), nil
+}
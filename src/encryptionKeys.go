@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"database/manager"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Pause between two batches of re-encryption, so saving notes does not wait
+// for it.
+const REENCRYPT_PAUSE = 200 * time.Millisecond
+
+type reencrypter interface {
+	Encrypted() bool
+	Reencrypt(ctx context.Context, pause time.Duration, stop <-chan struct{}) (int, error)
+}
+
+// startReencryption moves every note and revision to the current key in the
+// background, after a key rotation or when a database is encrypted for the
+// first time.
+func startReencryption() {
+	store, isReencrypter := dbManager.(reencrypter)
+	if !isReencrypter || !store.Encrypted() {
+		return
+	}
+
+	startBackgroundJob(func(stop <-chan struct{}) {
+		count, err := store.Reencrypt(context.Background(), REENCRYPT_PAUSE, stop)
+		if err != nil {
+			slog.Error("Re-encrypting the database.", "error", err)
+			return
+		}
+
+		if count > 0 {
+			slog.Info("Re-encrypted notes and revisions with the current key.", "count", count)
+		}
+	})
+}
+
+func keygenCommand(arguments []string) int {
+	if len(arguments) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: shareNotes keygen")
+		return 2
+	}
+
+	key, err := manager.GenerateKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(key)
+
+	return 0
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const NOTE_CREATED = "created"
+const NOTE_UPDATED = "updated"
+const NOTE_DELETED = "deleted"
+
+const SUBSCRIBER_BUFFER_SIZE = 16
+
+type Event struct {
+	Type       string    `json:"type"`
+	NoteID     int       `json:"noteID"`
+	ChangeDate time.Time `json:"changeDate"`
+}
+
+type Broker struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func New() *Broker {
+	return &Broker{subscribers: make(map[chan Event]bool)}
+}
+
+func (b *Broker) Subscribe() chan Event {
+	var subscriber chan Event = make(chan Event, SUBSCRIBER_BUFFER_SIZE)
+
+	b.mutex.Lock()
+	b.subscribers[subscriber] = true
+	b.mutex.Unlock()
+
+	return subscriber
+}
+
+func (b *Broker) Unsubscribe(subscriber chan Event) {
+	b.mutex.Lock()
+	if b.subscribers[subscriber] {
+		delete(b.subscribers, subscriber)
+		close(subscriber)
+	}
+	b.mutex.Unlock()
+}
+
+// Publish never blocks. A subscriber that does not keep up loses events
+// instead of stalling the database write that produced them.
+func (b *Broker) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
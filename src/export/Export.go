@@ -0,0 +1,151 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"note"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const FORMAT_JSON = "json"
+const FORMAT_MARKDOWN = "markdown"
+const FORMAT_ZIP = "zip"
+const FORMAT_HTML = "html"
+
+const MAX_SLUG_LENGTH = 60
+
+// A NoteSource hands out notes one at a time, so that an export never has
+// to hold the whole database in memory.
+type NoteSource interface {
+	EachNote(ctx context.Context, function func(note.Note) error) error
+}
+
+type jsonNote struct {
+	NoteID     int       `json:"id"`
+	Title      string    `json:"title"`
+	Text       string    `json:"text"`
+	AddDate    time.Time `json:"addDate"`
+	ChangeDate time.Time `json:"changeDate"`
+	Version    int       `json:"version"`
+	Tags       []string  `json:"tags"`
+}
+
+func toJSONNote(n note.Note) jsonNote {
+	var tags []string = n.Tags()
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return jsonNote{
+		NoteID:     n.NoteID(),
+		Title:      n.Title(),
+		Text:       n.Text(),
+		AddDate:    n.AddDate(),
+		ChangeDate: n.ChangeDate(),
+		Version:    n.Version(),
+		Tags:       tags}
+}
+
+func Slug(title string) string {
+	var slug []rune
+	var dash bool = false
+
+	for _, character := range strings.ToLower(title) {
+		if unicode.IsLetter(character) || unicode.IsDigit(character) {
+			slug = append(slug, character)
+			dash = false
+		} else if !dash && len(slug) > 0 {
+			slug = append(slug, '-')
+			dash = true
+		}
+
+		if len(slug) >= MAX_SLUG_LENGTH {
+			break
+		}
+	}
+
+	var result string = strings.Trim(string(slug), "-")
+	if result == "" {
+		return "note"
+	}
+	return result
+}
+
+func FileName(n note.Note) string {
+	return fmt.Sprintf("%d-%s.md", n.NoteID(), Slug(n.Title()))
+}
+
+func WriteJSON(ctx context.Context, writer io.Writer, source NoteSource) error {
+	_, err := fmt.Fprintf(writer, "{\"exportDate\":%q,\"notes\":[", time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	var first bool = true
+	var encoder *json.Encoder = json.NewEncoder(writer)
+
+	err = source.EachNote(ctx, func(n note.Note) error {
+		if !first {
+			_, err := io.WriteString(writer, ",")
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+
+		return encoder.Encode(toJSONNote(n))
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(writer, "]}\n")
+	return err
+}
+
+var htmlHeader = template.Must(template.New("header").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <title>ShareNotes export</title>
+  <meta charset="utf-8">
+  <style>
+    body { font-family: sans-serif; margin: 2em; }
+    section { border-top: 1px solid #ccc; padding: 1em 0; }
+    pre { white-space: pre-wrap; }
+    small { color: #666; }
+  </style>
+</head>
+<body>
+<h1>ShareNotes export</h1>
+<small>Exported {{.Format "2006-01-02 15:04:05 MST"}}</small>
+`))
+
+var htmlNote = template.Must(template.New("note").Parse(`<section id="note-{{.NoteID}}">
+  <h2>{{.Title}} <small>(ID: {{.NoteID}})</small></h2>
+  <pre>{{.Text}}</pre>
+  {{if .Tags}}<p>Tags: {{range $i, $tag := .Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}</p>{{end}}
+  <small>Created {{.AddDate.Format "2006-01-02 15:04"}}, last changed {{.ChangeDate.Format "2006-01-02 15:04"}}</small>
+</section>
+`))
+
+func WriteHTML(ctx context.Context, writer io.Writer, source NoteSource) error {
+	err := htmlHeader.Execute(writer, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = source.EachNote(ctx, func(n note.Note) error {
+		return htmlNote.Execute(writer, toJSONNote(n))
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(writer, "</body>\n</html>\n")
+	return err
+}
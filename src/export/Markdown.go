@@ -0,0 +1,79 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"frontmatter"
+	"io"
+	"note"
+	"os"
+	"path/filepath"
+)
+
+// WriteMarkdown writes a note as Markdown with YAML front matter.
+func WriteMarkdown(writer io.Writer, n note.Note) error {
+	err := frontmatter.WriteYAML(writer, frontmatter.Matter{
+		ID:         n.NoteID(),
+		Title:      n.Title(),
+		AddDate:    n.AddDate(),
+		ChangeDate: n.ChangeDate(),
+		Version:    n.Version(),
+		Tags:       n.Tags()})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(writer, n.Text())
+	return err
+}
+
+func WriteMarkdownZip(ctx context.Context, writer io.Writer, source NoteSource) error {
+	var archive *zip.Writer = zip.NewWriter(writer)
+
+	err := source.EachNote(ctx, func(n note.Note) error {
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     FileName(n),
+			Method:   zip.Deflate,
+			Modified: n.ChangeDate()})
+		if err != nil {
+			return err
+		}
+
+		return WriteMarkdown(file, n)
+	})
+	if err != nil {
+		archive.Close()
+		return err
+	}
+
+	return archive.Close()
+}
+
+func WriteMarkdownDirectory(ctx context.Context, directory string, source NoteSource) error {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return err
+	}
+
+	return source.EachNote(ctx, func(n note.Note) error {
+		var path string = filepath.Join(directory, FileName(n))
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+
+		err = WriteMarkdown(file, n)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		err = file.Close()
+		if err != nil {
+			return err
+		}
+
+		return os.Chtimes(path, n.ChangeDate(), n.ChangeDate())
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"export"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"router"
+	"time"
+)
+
+func exportHandler(writer http.ResponseWriter, request *http.Request) {
+	var format string = router.Param(request, "format")
+	var fileName string = "sharenotes-" + time.Now().Format("20060102-150405") + "." + format
+	var err error
+
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	switch format {
+	case export.FORMAT_JSON:
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		err = export.WriteJSON(request.Context(), writer, dbManager)
+	case export.FORMAT_ZIP:
+		writer.Header().Set("Content-Type", "application/zip")
+		err = export.WriteMarkdownZip(request.Context(), writer, dbManager)
+	case export.FORMAT_HTML:
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = export.WriteHTML(request.Context(), writer, dbManager)
+	}
+
+	// The download has already started, so all that is left is to log.
+	if err != nil {
+		loggerFor(request).Error("Streaming the export failed.", "error", err, "file", fileName)
+	}
+}
+
+func exportCommand(arguments []string) int {
+	var flags *flag.FlagSet = flag.NewFlagSet("export", flag.ExitOnError)
+	var format *string = flags.String("format", export.FORMAT_JSON, "json, zip, html or markdown (one .md file per note)")
+	var output *string = flags.String("o", "-", "output file, or the directory for -format markdown; - writes to stdout")
+	flags.Parse(arguments)
+
+	var write func(context.Context, io.Writer, export.NoteSource) error
+
+	switch *format {
+	case export.FORMAT_JSON:
+		write = export.WriteJSON
+	case export.FORMAT_ZIP:
+		write = export.WriteMarkdownZip
+	case export.FORMAT_HTML:
+		write = export.WriteHTML
+	case export.FORMAT_MARKDOWN:
+		if *output == "-" {
+			fmt.Fprintln(os.Stderr, "-format markdown needs an output directory.")
+			return 2
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown export format %q.\n", *format)
+		return 2
+	}
+
+	err := dbManager.Open(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer dbManager.Close()
+
+	if *format == export.FORMAT_MARKDOWN {
+		err = export.WriteMarkdownDirectory(context.Background(), *output, dbManager)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	if *output == "-" {
+		err = write(context.Background(), os.Stdout, dbManager)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = write(context.Background(), file, dbManager)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Half an export looks like a whole one.
+		os.Remove(*output)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"config"
+	"context"
+	"flag"
+	"fmt"
+	"foldersync"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func (s *server) startFolderSync() {
+	syncer, err := foldersync.New(dbManager, s.settings.SyncDirectory)
+	if err != nil {
+		slog.Error("Starting folder sync.", "error", err)
+		return
+	}
+
+	startBackgroundJob(func(stop <-chan struct{}) {
+		syncer.Run(s.settings.SyncInterval, stop)
+	})
+}
+
+func syncCommand(arguments []string, settings config.Config) int {
+	var flags *flag.FlagSet = flag.NewFlagSet("sync", flag.ExitOnError)
+	var directory *string = flags.String("dir", "", "folder to mirror the notes to")
+	var interval *time.Duration = flags.Duration("interval", settings.SyncInterval, "how often to look for changes")
+	var once *bool = flags.Bool("once", false, "sync once and exit")
+	flags.Parse(arguments)
+
+	if *directory == "" {
+		fmt.Fprintln(os.Stderr, "Usage: shareNotes sync -dir <folder> [-interval 2s] [-once]")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	if !keepsChanges(settings) {
+		fmt.Fprintf(os.Stderr, "Folder sync needs storage %q, %q keeps no change feed to sync from.\n", config.STORAGE_SQLITE, settings.Storage)
+		return 1
+	}
+
+	err := dbManager.Open(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer dbManager.Close()
+
+	syncer, err := foldersync.New(dbManager, *directory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *once {
+		err = syncer.Sync(context.Background())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	// Finish the current pass and close the database on Ctrl-C or a
+	// service stop instead of dying in the middle of a write.
+	var signals chan os.Signal = make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var stop chan struct{} = make(chan struct{})
+	go func() {
+		received := <-signals
+		slog.Info("Stopping folder sync...", "signal", received.String())
+		close(stop)
+	}()
+
+	syncer.Run(*interval, stop)
+	return 0
+}
@@ -0,0 +1,474 @@
+package foldersync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/manager"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"export"
+	"fmt"
+	"frontmatter"
+	"io/ioutil"
+	"log/slog"
+	"note"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const STATE_FILE_NAME = ".sharenotes-sync.json"
+const CONFLICT_SUFFIX = ".conflict"
+const CONFLICT_DATE_FORMAT = "20060102-150405"
+const CHANGES_BATCH_SIZE = 100
+
+var NOTE_EXTENSIONS = []string{".md", ".markdown", ".txt"}
+
+type Store interface {
+	GetNote(ctx context.Context, noteID int) (note.Note, error)
+	CreateNote(ctx context.Context, n note.Note) (int, error)
+	UpdateNote(ctx context.Context, n note.Note) error
+	DeleteNote(ctx context.Context, noteID int, version int) error
+	Changes(ctx context.Context, since int64, limit int) ([]manager.Change, error)
+}
+
+// What the folder looked like after the last sync, so that edits on either
+// side can be told apart.
+type fileState struct {
+	File    string    `json:"file"`
+	Version int       `json:"version"`
+	Hash    string    `json:"hash"`
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+}
+
+type syncState struct {
+	Sequence int64              `json:"sequence"`
+	Notes    map[int]*fileState `json:"notes"`
+}
+
+// A Syncer mirrors every note to one Markdown file in a folder and takes
+// edits, new files, renames and deletions in that folder back into the
+// store. When a note changed on both sides, the version in the store wins
+// the file and the edit from the folder is kept next to it as a .conflict
+// file.
+type Syncer struct {
+	store     Store
+	directory string
+	state     syncState
+}
+
+func New(store Store, directory string) (*Syncer, error) {
+	var syncer *Syncer = &Syncer{store: store, directory: directory, state: syncState{Notes: make(map[int]*fileState)}}
+
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(directory, STATE_FILE_NAME))
+	if err == nil {
+		err = json.Unmarshal(content, &syncer.state)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", STATE_FILE_NAME, err)
+		}
+		if syncer.state.Notes == nil {
+			syncer.state.Notes = make(map[int]*fileState)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return syncer, nil
+}
+
+// Run syncs every interval until stop is closed. A sync that is running
+// when stop is closed is finished first.
+func (s *Syncer) Run(interval time.Duration, stop <-chan struct{}) {
+	var ticker *time.Ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Sync(context.Background())
+		if err != nil {
+			slog.Error("Syncing notes folder.", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func isNoteFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, extension := range NOTE_EXTENSIONS {
+		if strings.HasSuffix(strings.ToLower(name), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashOf(content []byte) string {
+	var sum [sha256.Size]byte = sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sync does one round: edits in the folder first, then the changes in the
+// store since the last round.
+func (s *Syncer) Sync(ctx context.Context) error {
+	entries, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return err
+	}
+
+	var files map[string]os.FileInfo = make(map[string]os.FileInfo)
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && isNoteFile(entry.Name()) {
+			files[entry.Name()] = entry
+		}
+	}
+
+	var tracked map[string]int = make(map[string]int)
+	var noteIDs []int
+	for noteID, state := range s.state.Notes {
+		tracked[state.File] = noteID
+		noteIDs = append(noteIDs, noteID)
+	}
+	sort.Ints(noteIDs)
+
+	var missing map[int]bool = make(map[int]bool)
+
+	for _, noteID := range noteIDs {
+		var state *fileState = s.state.Notes[noteID]
+
+		info, found := files[state.File]
+		if !found {
+			missing[noteID] = true
+			continue
+		}
+
+		if info.ModTime().Equal(state.ModTime) && info.Size() == state.Size {
+			continue
+		}
+
+		err = s.pushFile(ctx, noteID, info)
+		if err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name := range files {
+		if _, found := tracked[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err = s.addFile(ctx, files[name], missing)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, noteID := range noteIDs {
+		if !missing[noteID] {
+			continue
+		}
+
+		err = s.deleteFile(ctx, noteID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.pullChanges(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.saveState()
+}
+
+func (s *Syncer) saveState() error {
+	content, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(filepath.Join(s.directory, STATE_FILE_NAME), content)
+}
+
+// writeFile replaces a file in one step, so editors never see half a note.
+func writeFile(path string, content []byte) error {
+	var temporary string = filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+
+	err := ioutil.WriteFile(temporary, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path)
+}
+
+func (s *Syncer) track(noteID int, name string, version int, content []byte) error {
+	info, err := os.Stat(filepath.Join(s.directory, name))
+	if err != nil {
+		return err
+	}
+
+	s.state.Notes[noteID] = &fileState{
+		File:    name,
+		Version: version,
+		Hash:    hashOf(content),
+		ModTime: info.ModTime(),
+		Size:    info.Size()}
+
+	return nil
+}
+
+// writeNote writes the note from the store into the folder. Files that
+// still carry the name ShareNotes gave them follow the title of the note.
+func (s *Syncer) writeNote(n note.Note) error {
+	var buffer bytes.Buffer
+
+	err := export.WriteMarkdown(&buffer, n)
+	if err != nil {
+		return err
+	}
+
+	var name string = export.FileName(n)
+
+	if state, found := s.state.Notes[n.NoteID()]; found && state.File != name {
+		if strings.HasPrefix(state.File, fmt.Sprintf("%d-", n.NoteID())) {
+			os.Remove(filepath.Join(s.directory, state.File))
+		} else {
+			name = state.File
+		}
+	}
+
+	err = writeFile(filepath.Join(s.directory, name), buffer.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return s.track(n.NoteID(), name, n.Version(), buffer.Bytes())
+}
+
+// keepConflict moves an edit that could not be saved out of the way.
+func (s *Syncer) keepConflict(name string, content []byte) error {
+	var conflict string = fmt.Sprintf("%s.%s%s", name, time.Now().Format(CONFLICT_DATE_FORMAT), CONFLICT_SUFFIX)
+
+	slog.Warn("Conflicting edit kept.", "file", conflict)
+
+	return writeFile(filepath.Join(s.directory, conflict), content)
+}
+
+// parseFile reads a note file. What the file does not say is taken from
+// fallback, or from the file itself for new notes.
+func parseFile(name string, content []byte, info os.FileInfo, fallback *note.Note) (frontmatter.Matter, note.Note, error) {
+	matter, body, _, err := frontmatter.Parse(string(content))
+	if err != nil {
+		return matter, note.Note{}, fmt.Errorf("%s: %s", name, err)
+	}
+
+	var title string = matter.Title
+	var tags []string = matter.Tags
+	var addDate time.Time = matter.AddDate
+
+	if fallback != nil {
+		if title == "" {
+			title = fallback.Title()
+		}
+		if tags == nil {
+			tags = fallback.Tags()
+		}
+		addDate = fallback.AddDate()
+	}
+
+	if title == "" {
+		title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if addDate.IsZero() {
+		addDate = info.ModTime()
+	}
+
+	return matter, note.NewLocal(0, title, body, addDate, info.ModTime(), 0, tags), nil
+}
+
+// pushFile saves the edit of a tracked file.
+func (s *Syncer) pushFile(ctx context.Context, noteID int, info os.FileInfo) error {
+	var state *fileState = s.state.Notes[noteID]
+
+	content, err := ioutil.ReadFile(filepath.Join(s.directory, state.File))
+	if err != nil {
+		return err
+	}
+
+	if hashOf(content) == state.Hash {
+		state.ModTime = info.ModTime()
+		state.Size = info.Size()
+		return nil
+	}
+
+	current, err := s.store.GetNote(ctx, noteID)
+	if err == sql.ErrNoRows {
+		// Deleted in the store while it was edited here.
+		os.Remove(filepath.Join(s.directory, state.File))
+		delete(s.state.Notes, noteID)
+		return s.keepConflict(state.File, content)
+	} else if err != nil {
+		return err
+	}
+
+	_, parsed, err := parseFile(state.File, content, info, &current)
+	if err != nil {
+		slog.Error("Reading note file.", "error", err)
+		state.ModTime = info.ModTime()
+		state.Size = info.Size()
+		return nil
+	}
+
+	if parsed.Title() == current.Title() && parsed.Text() == current.Text() && note.JoinTags(parsed.Tags()) == note.JoinTags(current.Tags()) {
+		return s.track(noteID, state.File, current.Version(), content)
+	}
+
+	var edited note.Note = note.NewLocal(noteID, parsed.Title(), parsed.Text(), parsed.AddDate(), parsed.ChangeDate(), state.Version, parsed.Tags())
+
+	err = s.store.UpdateNote(ctx, edited)
+	if err == manager.ErrVersionConflict {
+		err = s.keepConflict(state.File, content)
+		if err != nil {
+			return err
+		}
+		return s.writeNote(current)
+	} else if err == sql.ErrNoRows {
+		os.Remove(filepath.Join(s.directory, state.File))
+		delete(s.state.Notes, noteID)
+		return s.keepConflict(state.File, content)
+	} else if err != nil {
+		return err
+	}
+
+	return s.track(noteID, state.File, state.Version+1, content)
+}
+
+// addFile handles a file that is not tracked yet: a renamed note, a file
+// from an earlier export that still knows its note, or a new note.
+func (s *Syncer) addFile(ctx context.Context, info os.FileInfo, missing map[int]bool) error {
+	var name string = info.Name()
+
+	content, err := ioutil.ReadFile(filepath.Join(s.directory, name))
+	if err != nil {
+		return err
+	}
+
+	matter, parsed, err := parseFile(name, content, info, nil)
+	if err != nil {
+		slog.Error("Reading note file.", "error", err)
+		return nil
+	}
+
+	var hash string = hashOf(content)
+
+	for noteID := range missing {
+		var state *fileState = s.state.Notes[noteID]
+
+		if noteID == matter.ID || hash == state.Hash {
+			delete(missing, noteID)
+			state.File = name
+
+			if hash == state.Hash {
+				state.ModTime = info.ModTime()
+				state.Size = info.Size()
+				return nil
+			}
+			return s.pushFile(ctx, noteID, info)
+		}
+	}
+
+	if _, tracked := s.state.Notes[matter.ID]; matter.ID > 0 && !tracked {
+		current, err := s.store.GetNote(ctx, matter.ID)
+		if err == nil {
+			var version int = matter.Version
+			if version == 0 {
+				version = current.Version()
+			}
+
+			s.state.Notes[matter.ID] = &fileState{File: name, Version: version}
+			return s.pushFile(ctx, matter.ID, info)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	noteID, err := s.store.CreateNote(ctx, parsed)
+	if err != nil {
+		return err
+	}
+
+	return s.track(noteID, name, 1, content)
+}
+
+// deleteFile deletes the note of a file that is gone, unless the note was
+// changed in the store in the meantime; then it comes back.
+func (s *Syncer) deleteFile(ctx context.Context, noteID int) error {
+	var state *fileState = s.state.Notes[noteID]
+
+	err := s.store.DeleteNote(ctx, noteID, state.Version)
+	if err == manager.ErrVersionConflict {
+		current, err := s.store.GetNote(ctx, noteID)
+		if err != nil {
+			return err
+		}
+		return s.writeNote(current)
+	} else if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	delete(s.state.Notes, noteID)
+	return nil
+}
+
+func (s *Syncer) pullChanges(ctx context.Context) error {
+	for {
+		changes, err := s.store.Changes(ctx, s.state.Sequence, CHANGES_BATCH_SIZE)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			var noteID int = change.Note.NoteID()
+			state, tracked := s.state.Notes[noteID]
+
+			if change.Deleted {
+				if tracked {
+					os.Remove(filepath.Join(s.directory, state.File))
+					delete(s.state.Notes, noteID)
+				}
+			} else if !tracked || state.Version != change.Note.Version() {
+				err = s.writeNote(change.Note)
+				if err != nil {
+					return err
+				}
+			}
+
+			s.state.Sequence = change.Sequence
+		}
+
+		if len(changes) < CHANGES_BATCH_SIZE {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package foldersync
+
+import (
+	"context"
+	"database/manager"
+	"io/ioutil"
+	"note"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const TEST_FILE_NAME = "1-first.md"
+
+func openTestStore(t *testing.T) *manager.DatabaseManager {
+	var dbm manager.DatabaseManager = manager.New(filepath.Join(t.TempDir(), "sndb.db"))
+
+	err := dbm.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dbm.Close)
+
+	return &dbm
+}
+
+// writeTestFile edits a note file like an editor would, with a change date
+// that the sync cannot miss.
+func writeTestFile(t *testing.T, path string, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var later time.Time = time.Now().Add(time.Minute)
+	if err = os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func editInStore(t *testing.T, store *manager.DatabaseManager, text string) {
+	var ctx context.Context = context.Background()
+
+	current, err := store.GetNote(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateNote(ctx, note.NewLocal(1, current.Title(), text, current.AddDate(), time.Now(), current.Version(), current.Tags()))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSync syncs a note to a folder, changes it on one or both sides and
+// checks the store and the folder after the next round.
+func TestSync(t *testing.T) {
+	var tests = []struct {
+		name      string
+		edit      func(t *testing.T, store *manager.DatabaseManager, directory string)
+		texts     []string
+		files     []string
+		conflicts int
+	}{
+		{"no change", func(t *testing.T, store *manager.DatabaseManager, directory string) {},
+			[]string{"original"}, []string{TEST_FILE_NAME}, 0},
+		{"edit in the folder", func(t *testing.T, store *manager.DatabaseManager, directory string) {
+			writeTestFile(t, filepath.Join(directory, TEST_FILE_NAME), "edited in the folder")
+		}, []string{"edited in the folder"}, []string{TEST_FILE_NAME}, 0},
+		{"edit in the store", func(t *testing.T, store *manager.DatabaseManager, directory string) {
+			editInStore(t, store, "edited in the store")
+		}, []string{"edited in the store"}, []string{TEST_FILE_NAME}, 0},
+		{"edit on both sides", func(t *testing.T, store *manager.DatabaseManager, directory string) {
+			editInStore(t, store, "edited in the store")
+			writeTestFile(t, filepath.Join(directory, TEST_FILE_NAME), "edited in the folder")
+		}, []string{"edited in the store"}, []string{TEST_FILE_NAME}, 1},
+		{"rename", func(t *testing.T, store *manager.DatabaseManager, directory string) {
+			err := os.Rename(filepath.Join(directory, TEST_FILE_NAME), filepath.Join(directory, "renamed.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"original"}, []string{"renamed.md"}, 0},
+		{"new file", func(t *testing.T, store *manager.DatabaseManager, directory string) {
+			writeTestFile(t, filepath.Join(directory, "second.md"), "added in the folder")
+		}, []string{"added in the folder", "original"}, []string{TEST_FILE_NAME, "second.md"}, 0},
+		{"delete", func(t *testing.T, store *manager.DatabaseManager, directory string) {
+			err := os.Remove(filepath.Join(directory, TEST_FILE_NAME))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, nil, nil, 0},
+	}
+
+	for _, test := range tests {
+		var ctx context.Context = context.Background()
+		var store *manager.DatabaseManager = openTestStore(t)
+		var directory string = t.TempDir()
+
+		if _, err := store.CreateNote(ctx, note.New("first", "original")); err != nil {
+			t.Fatal(err)
+		}
+
+		syncer, err := New(store, directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = syncer.Sync(ctx); err != nil {
+			t.Fatalf("%s: first sync: %s", test.name, err)
+		}
+
+		test.edit(t, store, directory)
+
+		// A restarted syncer has to pick up from the state file.
+		syncer, err = New(store, directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = syncer.Sync(ctx); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		notes, err := store.LoadNotes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var texts []string
+		for _, n := range notes {
+			texts = append(texts, strings.TrimSpace(n.Text()))
+		}
+		sort.Strings(texts)
+		if strings.Join(texts, "|") != strings.Join(test.texts, "|") {
+			t.Errorf("%s: notes %q, expected %q", test.name, texts, test.texts)
+		}
+
+		entries, err := ioutil.ReadDir(directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		var conflicts int
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), CONFLICT_SUFFIX) {
+				conflicts++
+			} else if isNoteFile(entry.Name()) {
+				files = append(files, entry.Name())
+			}
+		}
+		if strings.Join(files, "|") != strings.Join(test.files, "|") {
+			t.Errorf("%s: files %q, expected %q", test.name, files, test.files)
+		}
+		if conflicts != test.conflicts {
+			t.Errorf("%s: %d conflict files, expected %d", test.name, conflicts, test.conflicts)
+		}
+
+		// Every file shows what the store holds.
+		for _, n := range notes {
+			state, found := syncer.state.Notes[n.NoteID()]
+			if !found {
+				t.Errorf("%s: note %d has no file", test.name, n.NoteID())
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(directory, state.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), n.Text()) {
+				t.Errorf("%s: %s holds %q, expected %q", test.name, state.File, content, n.Text())
+			}
+		}
+	}
+}
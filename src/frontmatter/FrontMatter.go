@@ -0,0 +1,234 @@
+package frontmatter
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const YAML_DELIMITER = "---"
+const TOML_DELIMITER = "+++"
+
+var DATE_FORMATS = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// Matter holds the front matter fields ShareNotes knows about. Fields that
+// are missing stay at their zero value, other keys are ignored.
+type Matter struct {
+	ID         int
+	Title      string
+	AddDate    time.Time
+	ChangeDate time.Time
+	Version    int
+	Tags       []string
+}
+
+// Split separates the front matter from the body. The front matter has to
+// start on the first line and end with the same delimiter it started with.
+func Split(content string) (string, string, string, bool) {
+	content = strings.TrimPrefix(content, "\ufeff")
+
+	for _, delimiter := range []string{YAML_DELIMITER, TOML_DELIMITER} {
+		var rest string
+		if strings.HasPrefix(content, delimiter+"\n") {
+			rest = content[len(delimiter)+1:]
+		} else if strings.HasPrefix(content, delimiter+"\r\n") {
+			rest = content[len(delimiter)+2:]
+		} else {
+			continue
+		}
+
+		var offset int = 0
+		for _, line := range strings.SplitAfter(rest, "\n") {
+			if strings.TrimRight(line, " \t\r\n") == delimiter {
+				return rest[:offset], delimiter, rest[offset+len(line):], true
+			}
+			offset += len(line)
+		}
+	}
+
+	return "", "", content, false
+}
+
+// Parse reads YAML (---) or TOML (+++) front matter. Only the flat subset
+// of both is understood: scalars, quoted strings and lists of strings.
+func Parse(content string) (Matter, string, bool, error) {
+	var matter Matter
+
+	header, delimiter, body, found := Split(content)
+	if !found {
+		return matter, body, false, nil
+	}
+
+	var separator string = ":"
+	if delimiter == TOML_DELIMITER {
+		separator = "="
+	}
+
+	var lines []string = strings.Split(strings.Replace(header, "\r\n", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		var line string = strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var position int = strings.Index(line, separator)
+		if position < 0 {
+			// Tables and nested YAML are not used by ShareNotes.
+			if strings.HasPrefix(line, "[") || strings.HasPrefix(lines[i], " ") || strings.HasPrefix(line, "- ") {
+				continue
+			}
+			return matter, body, true, fmt.Errorf("front matter line %d: missing %q", i+2, separator)
+		}
+
+		var key string = strings.ToLower(strings.Trim(strings.TrimSpace(line[:position]), `"'`))
+		var value string = strings.TrimSpace(line[position+1:])
+
+		var list []string
+		var isList bool = false
+
+		if strings.HasPrefix(value, "[") {
+			// TOML arrays may span lines.
+			for !strings.HasSuffix(stripComment(value), "]") && i+1 < len(lines) {
+				i++
+				value += " " + strings.TrimSpace(lines[i])
+			}
+			list = parseList(stripComment(value))
+			isList = true
+		} else if value == "" && delimiter == YAML_DELIMITER {
+			for i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i+1]), "- ") {
+				i++
+				list = append(list, parseScalar(strings.TrimSpace(lines[i])[2:]))
+			}
+			isList = list != nil
+		}
+
+		var scalar string
+		if isList {
+			scalar = strings.Join(list, ", ")
+		} else {
+			scalar = parseScalar(value)
+		}
+
+		var err error
+		switch key {
+		case "id", "noteid":
+			matter.ID, err = strconv.Atoi(scalar)
+		case "title":
+			matter.Title = scalar
+		case "adddate", "created", "date":
+			matter.AddDate, err = parseDate(scalar)
+		case "changedate", "updated", "modified", "lastmod":
+			matter.ChangeDate, err = parseDate(scalar)
+		case "version":
+			matter.Version, err = strconv.Atoi(scalar)
+		case "tags":
+			if isList {
+				matter.Tags = list
+			} else {
+				matter.Tags = []string{}
+				for _, tag := range strings.Split(scalar, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						matter.Tags = append(matter.Tags, tag)
+					}
+				}
+			}
+		}
+
+		if err != nil {
+			return matter, body, true, fmt.Errorf("front matter %q: %s", key, err)
+		}
+	}
+
+	return matter, body, true, nil
+}
+
+func stripComment(value string) string {
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, `'`) {
+		return value
+	}
+	if position := strings.Index(value, " #"); position >= 0 {
+		return strings.TrimSpace(value[:position])
+	}
+	return value
+}
+
+func parseScalar(value string) string {
+	value = stripComment(strings.TrimSpace(value))
+
+	if len(value) >= 2 && value[0] == '"' {
+		unquoted, err := strconv.Unquote(value[:strings.LastIndex(value, `"`)+1])
+		if err == nil {
+			return unquoted
+		}
+	}
+	if len(value) >= 2 && value[0] == '\'' && strings.LastIndex(value, "'") > 0 {
+		return strings.Replace(value[1:strings.LastIndex(value, "'")], "''", "'", -1)
+	}
+
+	return value
+}
+
+func parseList(value string) []string {
+	var list []string = []string{}
+	var inner string = strings.TrimSpace(value[1 : len(value)-1])
+	var start int = 0
+	var quote byte = 0
+
+	for i := 0; i <= len(inner); i++ {
+		if i == len(inner) || (inner[i] == ',' && quote == 0) {
+			var item string = parseScalar(inner[start:i])
+			if item != "" {
+				list = append(list, item)
+			}
+			start = i + 1
+			continue
+		}
+
+		if quote == 0 && (inner[i] == '"' || inner[i] == '\'') {
+			quote = inner[i]
+		} else if quote != 0 && inner[i] == '\\' && quote == '"' {
+			i++
+		} else if inner[i] == quote {
+			quote = 0
+		}
+	}
+
+	return list
+}
+
+func parseDate(value string) (time.Time, error) {
+	var err error
+
+	for _, format := range DATE_FORMATS {
+		var date time.Time
+		date, err = time.Parse(format, value)
+		if err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+// WriteYAML writes the front matter block that Parse reads back.
+func WriteYAML(writer io.Writer, matter Matter) error {
+	var tags []string
+	for _, tag := range matter.Tags {
+		tags = append(tags, strconv.Quote(tag))
+	}
+
+	_, err := fmt.Fprintf(writer, "%s\nid: %d\ntitle: %s\naddDate: %s\nchangeDate: %s\nversion: %d\ntags: [%s]\n%s\n",
+		YAML_DELIMITER,
+		matter.ID,
+		strconv.Quote(matter.Title),
+		matter.AddDate.UTC().Format(time.RFC3339),
+		matter.ChangeDate.UTC().Format(time.RFC3339),
+		matter.Version,
+		strings.Join(tags, ", "),
+		YAML_DELIMITER)
+
+	return err
+}
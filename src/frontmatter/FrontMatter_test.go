@@ -0,0 +1,89 @@
+package frontmatter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func describeMatter(matter Matter) string {
+	return fmt.Sprintf("id %d, title %q, added %s, changed %s, version %d, tags %q", matter.ID, matter.Title,
+		matter.AddDate.Format(time.RFC3339), matter.ChangeDate.Format(time.RFC3339), matter.Version, matter.Tags)
+}
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		name    string
+		content string
+		matter  Matter
+		body    string
+		found   bool
+		failed  bool
+	}{
+		{"no front matter", "just text\n", Matter{}, "just text\n", false, false},
+		{"yaml", "---\nid: 3\ntitle: \"Quoted: title\"\naddDate: 2024-03-13T12:10:00Z\nchangeDate: 2024-03-14\nversion: 2\ntags: [\"a\", 'b, c']\nauthor: ignored\n---\nbody\n",
+			Matter{ID: 3, Title: "Quoted: title", AddDate: time.Date(2024, 3, 13, 12, 10, 0, 0, time.UTC), ChangeDate: time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC), Version: 2, Tags: []string{"a", "b, c"}},
+			"body\n", true, false},
+		{"yaml list", "---\ntitle: plain # comment\ntags:\n  - one\n  - \"two\"\n---\n",
+			Matter{Title: "plain", Tags: []string{"one", "two"}}, "", true, false},
+		{"comma separated tags", "---\ntags: a, b,,\n---\nbody",
+			Matter{Tags: []string{"a", "b"}}, "body", true, false},
+		{"empty tags", "---\ntags:\n---\nbody",
+			Matter{Tags: []string{}}, "body", true, false},
+		{"toml", "+++\ntitle = 'It''s'\ntags = [\n  \"a\",\n  \"b\"\n]\n[extra]\nkey = 1\n+++\nbody",
+			Matter{Title: "It's", Tags: []string{"a", "b"}}, "body", true, false},
+		{"windows line endings and byte order mark", "\ufeff---\r\ntitle: windows\r\n---\r\nbody\r\n",
+			Matter{Title: "windows"}, "body\r\n", true, false},
+		{"unterminated", "---\ntitle: x\nbody", Matter{}, "---\ntitle: x\nbody", false, false},
+		{"delimiters differ", "---\ntitle: x\n+++\nbody", Matter{}, "---\ntitle: x\n+++\nbody", false, false},
+		{"invalid date", "---\ndate: yesterday\n---\n", Matter{}, "", true, true},
+		{"invalid id", "---\nid: first\n---\n", Matter{}, "", true, true},
+		{"missing separator", "---\njust words\n---\n", Matter{}, "", true, true},
+	}
+
+	for _, test := range tests {
+		matter, body, found, err := Parse(test.content)
+
+		if (err != nil) != test.failed {
+			t.Errorf("%s: error %v, expected failure %v", test.name, err, test.failed)
+			continue
+		}
+		if found != test.found {
+			t.Errorf("%s: found %v, expected %v", test.name, found, test.found)
+		}
+		if body != test.body {
+			t.Errorf("%s: body %q, expected %q", test.name, body, test.body)
+		}
+		if !test.failed && describeMatter(matter) != describeMatter(test.matter) {
+			t.Errorf("%s: %s, expected %s", test.name, describeMatter(matter), describeMatter(test.matter))
+		}
+		if !test.failed && (matter.Tags == nil) != (test.matter.Tags == nil) {
+			t.Errorf("%s: tags %#v, expected %#v", test.name, matter.Tags, test.matter.Tags)
+		}
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	var matters = []Matter{
+		{ID: 1, Title: "plain", AddDate: time.Date(2024, 3, 13, 12, 10, 0, 0, time.UTC), ChangeDate: time.Date(2024, 3, 14, 8, 0, 0, 0, time.UTC), Version: 1, Tags: []string{}},
+		{ID: 42, Title: `"Quoted": with # and: colon`, AddDate: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), ChangeDate: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), Version: 7, Tags: []string{"a, b", `say "hi"`, "#tag"}},
+	}
+
+	for _, matter := range matters {
+		var buffer bytes.Buffer
+		if err := WriteYAML(&buffer, matter); err != nil {
+			t.Fatal(err)
+		}
+		buffer.WriteString("body")
+
+		parsed, body, found, err := Parse(buffer.String())
+		if err != nil || !found || body != "body" {
+			t.Errorf("%q: found %v, body %q, error %v", buffer.String(), found, body, err)
+			continue
+		}
+		if describeMatter(parsed) != describeMatter(matter) {
+			t.Errorf("read back %s, expected %s", describeMatter(parsed), describeMatter(matter))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/manager"
+	"fmt"
+	"metrics"
+	"net/http"
+	"router"
+	"strconv"
+	"time"
+)
+
+// The endpoints for monitoring are neither rate limited nor behind the
+// handlers of the notes, a busy server still answers them.
+const HEALTH_PATH = "/healthz"
+const READY_PATH = "/readyz"
+const METRICS_PATH = "/metrics"
+
+var registry *metrics.Registry = metrics.NewRegistry()
+
+var requestsTotal *metrics.Counter = registry.Counter("sharenotes_http_requests_total",
+	"HTTP requests by route, method and status code.", "route", "method", "code")
+var requestDuration *metrics.Histogram = registry.Histogram("sharenotes_http_request_duration_seconds",
+	"Time taken to answer HTTP requests by route.", metrics.REQUEST_BUCKETS, "route")
+var queryDuration *metrics.Histogram = registry.Histogram("sharenotes_db_query_duration_seconds",
+	"Time taken by the database by DatabaseManager method.", metrics.QUERY_BUCKETS, "method")
+var rateLimitedTotal *metrics.Counter = registry.Counter("sharenotes_rate_limited_total",
+	"Requests refused because of the rate limit.")
+var pasteBinFailuresTotal *metrics.Counter = registry.Counter("sharenotes_pastebin_failures_total",
+	"Notes that could not be published to dPaste.")
+
+func setUpMetrics() {
+	registry.GaugeFunc("sharenotes_notes", "Number of notes.", func() (float64, error) {
+		count, err := dbManager.CountNotes(context.Background())
+		return float64(count), err
+	})
+	registry.GaugeFunc("sharenotes_session_tokens", "Number of tokens in the token store.", func() (float64, error) {
+		return float64(sidManager.tokenCount()), nil
+	})
+
+	if sqlite, isSQLite := dbManager.(*manager.DatabaseManager); isSQLite {
+		sqlite.ObserveQueries(func(method string, duration time.Duration) {
+			queryDuration.Observe(duration.Seconds(), method)
+		})
+	}
+}
+
+// healthHandler answers as long as the process is running.
+func healthHandler(writer http.ResponseWriter, request *http.Request) {
+	fmt.Fprintln(writer, "ok")
+}
+
+// readyHandler answers 503 while the database cannot be used and once the
+// server shuts down.
+func readyHandler(writer http.ResponseWriter, request *http.Request) {
+	select {
+	case <-stopping:
+		http.Error(writer, "Shutting down.", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	err := storeFor(request).Ready(request.Context())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(writer, "ok")
+}
+
+// statusRecorder remembers the status code and the size of a response.
+// Flush is passed on for the event streams.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	written, err := r.ResponseWriter.Write(data)
+	r.bytes += written
+	return written, err
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, canFlush := r.ResponseWriter.(http.Flusher); canFlush {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrumentRequests counts and times requests by the name of their route,
+// so every note shares the route "note".
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var start time.Time = time.Now()
+		var recorder *statusRecorder = &statusRecorder{ResponseWriter: writer}
+
+		next.ServeHTTP(recorder, request)
+
+		var route string = "unmatched"
+		if matched := router.RouteOf(request); matched != nil && matched.Name() != "" {
+			route = matched.Name()
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		requestsTotal.Inc(route, request.Method, strconv.Itoa(recorder.status))
+		requestDuration.Observe(time.Since(start).Seconds(), route)
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"importer"
+	"os"
+)
+
+func importCommand(arguments []string) int {
+	var flags *flag.FlagSet = flag.NewFlagSet("import", flag.ExitOnError)
+	var format *string = flags.String("format", "", "simplenote, keep, enex or markdown (a directory of .md/.txt files)")
+	var dryRun *bool = flags.Bool("dry-run", false, "only report what would be imported")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: shareNotes import -format simplenote|keep|enex|markdown [-dry-run] <path>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+
+	if *format == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var report importer.Report
+
+	notes, err := importer.Read(*format, flags.Arg(0), &report)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = dbManager.Open(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer dbManager.Close()
+
+	err = importer.Import(context.Background(), dbManager, notes, importer.Options{DryRun: *dryRun}, &report)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *dryRun {
+		fmt.Print("Dry run, nothing was stored.\n")
+	}
+	fmt.Print(report.String())
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
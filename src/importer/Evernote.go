@@ -0,0 +1,77 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"note"
+	"time"
+)
+
+const ENEX_DATE_FORMAT = "20060102T150405Z"
+
+type enexNote struct {
+	Title   string   `xml:"title"`
+	Content string   `xml:"content"`
+	Created string   `xml:"created"`
+	Updated string   `xml:"updated"`
+	Tags    []string `xml:"tag"`
+}
+
+func parseENEXDate(value string) time.Time {
+	date, err := time.Parse(ENEX_DATE_FORMAT, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// ReadENEX reads an Evernote export. The file is decoded note by note, so
+// large notebooks with attachments do not have to fit into memory at once.
+func ReadENEX(reader io.Reader, source string, report *Report) ([]ImportedNote, error) {
+	var decoder *xml.Decoder = xml.NewDecoder(reader)
+	var notes []ImportedNote
+	var count int = 0
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return notes, fmt.Errorf("%s: %s", source, err)
+		}
+
+		start, isStart := token.(xml.StartElement)
+		if !isStart || start.Name.Local != "note" {
+			continue
+		}
+
+		var enex enexNote
+		err = decoder.DecodeElement(&enex, &start)
+		if err != nil {
+			return notes, fmt.Errorf("%s: %s", source, err)
+		}
+		count++
+
+		var imported ImportedNote = ImportedNote{
+			Source:     fmt.Sprintf("%s#%d", source, count),
+			Title:      enex.Title,
+			Text:       htmlToText(enex.Content),
+			Tags:       note.CleanTags(enex.Tags),
+			AddDate:    parseENEXDate(enex.Created),
+			ChangeDate: parseENEXDate(enex.Updated)}
+
+		if imported.ChangeDate.IsZero() {
+			imported.ChangeDate = imported.AddDate
+		}
+
+		if imported.Title == "" && imported.Text == "" {
+			report.skip(imported.Source, "empty")
+			continue
+		}
+
+		notes = append(notes, imported)
+	}
+
+	return notes, nil
+}
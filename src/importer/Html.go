@@ -0,0 +1,85 @@
+package importer
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+var blockElements = map[string]bool{
+	"div": true, "p": true, "br": true, "li": true, "tr": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true,
+	"en-note": true, "hr": true, "ul": true, "ol": true, "table": true,
+}
+
+// htmlToText turns the HTML of Keep and the ENML of Evernote into plain
+// text, keeping line breaks and checkboxes but dropping all markup.
+func htmlToText(markup string) string {
+	var decoder *xml.Decoder = xml.NewDecoder(strings.NewReader(markup))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var text strings.Builder
+	var skip int = 0
+
+	newline := func() {
+		if text.Len() > 0 && !strings.HasSuffix(text.String(), "\n") {
+			text.WriteString("\n")
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// Whatever could be read is better than nothing.
+			break
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			var name string = strings.ToLower(element.Name.Local)
+
+			if name == "script" || name == "style" || name == "head" || name == "title" {
+				skip++
+			} else if name == "br" {
+				text.WriteString("\n")
+			} else if name == "en-todo" {
+				var checked bool = false
+				for _, attribute := range element.Attr {
+					if attribute.Name.Local == "checked" && attribute.Value == "true" {
+						checked = true
+					}
+				}
+				if checked {
+					text.WriteString("[x] ")
+				} else {
+					text.WriteString("[ ] ")
+				}
+			} else if name == "li" {
+				newline()
+				text.WriteString("- ")
+			} else if blockElements[name] {
+				newline()
+			}
+		case xml.EndElement:
+			var name string = strings.ToLower(element.Name.Local)
+
+			if name == "script" || name == "style" || name == "head" || name == "title" {
+				if skip > 0 {
+					skip--
+				}
+			} else if name != "br" && blockElements[name] {
+				newline()
+			}
+		case xml.CharData:
+			if skip == 0 {
+				text.Write(element)
+			}
+		}
+	}
+
+	return strings.TrimSpace(strings.Replace(text.String(), "\u00a0", " ", -1))
+}
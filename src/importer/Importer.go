@@ -0,0 +1,249 @@
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"note"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const FORMAT_SIMPLENOTE = "simplenote"
+const FORMAT_KEEP = "keep"
+const FORMAT_ENEX = "enex"
+const FORMAT_MARKDOWN = "markdown"
+
+// An ImportedNote is a note read from another application, before it is
+// stored. Dates are the ones recorded by that application. Notes exported
+// from ShareNotes itself also know their id and the version they were
+// exported at.
+type ImportedNote struct {
+	Source     string
+	ID         int
+	Version    int
+	Title      string
+	Text       string
+	Tags       []string
+	AddDate    time.Time
+	ChangeDate time.Time
+}
+
+type NoteStore interface {
+	EachNote(ctx context.Context, function func(note.Note) error) error
+	GetNote(ctx context.Context, noteID int) (note.Note, error)
+	AddNote(ctx context.Context, n note.Note) error
+	UpdateNote(ctx context.Context, n note.Note) error
+}
+
+type Options struct {
+	DryRun bool
+}
+
+type Report struct {
+	Read       int
+	Imported   int
+	Updated    int
+	Unchanged  int
+	Duplicates int
+	Conflicts  int
+	Skipped    int
+	Failed     int
+	Messages   []string
+}
+
+func (r *Report) skip(source string, reason string) {
+	r.Skipped++
+	r.Messages = append(r.Messages, fmt.Sprintf("skipped %s: %s", source, reason))
+}
+
+func (r Report) String() string {
+	var summary string = fmt.Sprintf("%d read, %d imported, %d updated, %d unchanged, %d duplicates, %d conflicts, %d skipped, %d failed",
+		r.Read, r.Imported, r.Updated, r.Unchanged, r.Duplicates, r.Conflicts, r.Skipped, r.Failed)
+
+	if len(r.Messages) == 0 {
+		return summary + "\n"
+	}
+	return summary + "\n  " + strings.Join(r.Messages, "\n  ") + "\n"
+}
+
+func fingerprint(title string, text string) [sha256.Size]byte {
+	var normalized string = strings.TrimSpace(strings.Replace(title, "\r\n", "\n", -1)) + "\x00" +
+		strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1))
+
+	return sha256.Sum256([]byte(normalized))
+}
+
+func sameTags(a []string, b []string) bool {
+	return note.JoinTags(a) == note.JoinTags(b)
+}
+
+func firstLine(content string) (string, string) {
+	content = strings.TrimLeft(strings.Replace(content, "\r\n", "\n", -1), "\n")
+
+	var lines []string = strings.SplitN(content, "\n", 2)
+	if len(lines) == 1 {
+		return strings.TrimSpace(lines[0]), ""
+	}
+	return strings.TrimSpace(lines[0]), strings.TrimLeft(lines[1], "\n")
+}
+
+// Read reads the notes of an export in the given format. path is the
+// notes.json of Simplenote (or the directory containing it), the Keep
+// directory of a Takeout archive, an .enex file or a directory of them, or
+// a Markdown/text file or a directory tree of them.
+func Read(format string, path string, report *Report) ([]ImportedNote, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FORMAT_SIMPLENOTE:
+		if info.IsDir() {
+			path = filepath.Join(path, "source", "notes.json")
+			if _, err := os.Stat(path); err != nil {
+				path = filepath.Join(filepath.Dir(filepath.Dir(path)), "notes.json")
+			}
+		}
+		return readFile(path, ReadSimplenote, report)
+	case FORMAT_KEEP:
+		if !info.IsDir() {
+			if strings.HasSuffix(path, ".html") {
+				return readFile(path, ReadKeepHTML, report)
+			}
+			return readFile(path, ReadKeepJSON, report)
+		}
+		return ReadKeepDirectory(path, report)
+	case FORMAT_ENEX:
+		if !info.IsDir() {
+			return readFile(path, ReadENEX, report)
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var notes []ImportedNote
+		for _, entry := range entries {
+			if !strings.HasSuffix(strings.ToLower(entry.Name()), ".enex") {
+				continue
+			}
+
+			found, err := readFile(filepath.Join(path, entry.Name()), ReadENEX, report)
+			if err != nil {
+				return notes, err
+			}
+			notes = append(notes, found...)
+		}
+		return notes, nil
+	case FORMAT_MARKDOWN:
+		if !info.IsDir() {
+			return ReadMarkdownFile(path, report)
+		}
+		return ReadMarkdownDirectory(path, report)
+	}
+
+	return nil, fmt.Errorf("Unknown import format %q.", format)
+}
+
+func readFile(path string, read func(io.Reader, string, *Report) ([]ImportedNote, error), report *Report) ([]ImportedNote, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return read(file, path, report)
+}
+
+// Import stores notes that are not in the store yet. A note with an id
+// updates the note with that id, or is created with that id if there is
+// none, so a ShareNotes export can be edited and imported again. It is a
+// conflict if the note changed in the store since it was exported. Notes
+// without an id count as a duplicate if a note with the same title and text
+// exists or was already imported in this run. With DryRun nothing is
+// stored, but the report is the same.
+func Import(ctx context.Context, store NoteStore, notes []ImportedNote, options Options, report *Report) error {
+	var known map[[sha256.Size]byte]bool = make(map[[sha256.Size]byte]bool)
+
+	err := store.EachNote(ctx, func(n note.Note) error {
+		known[fingerprint(n.Title(), n.Text())] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, imported := range notes {
+		report.Read++
+
+		var key [sha256.Size]byte = fingerprint(imported.Title, imported.Text)
+
+		var addDate time.Time = imported.AddDate
+		var changeDate time.Time = imported.ChangeDate
+		if changeDate.IsZero() {
+			changeDate = time.Now()
+		}
+		if addDate.IsZero() {
+			addDate = changeDate
+		}
+
+		if imported.ID > 0 {
+			existing, err := store.GetNote(ctx, imported.ID)
+			if err == nil {
+				if existing.Title() == imported.Title && existing.Text() == imported.Text && sameTags(existing.Tags(), imported.Tags) {
+					report.Unchanged++
+					continue
+				}
+
+				if imported.Version > 0 && imported.Version < existing.Version() {
+					report.Conflicts++
+					report.Messages = append(report.Messages, fmt.Sprintf("conflict %s: note %d is at version %d, the file was exported at version %d",
+						imported.Source, imported.ID, existing.Version(), imported.Version))
+					continue
+				}
+
+				known[key] = true
+
+				if !options.DryRun {
+					err = store.UpdateNote(ctx, note.NewLocal(imported.ID, imported.Title, imported.Text, existing.AddDate(), changeDate, existing.Version(), imported.Tags))
+					if err != nil {
+						report.Failed++
+						report.Messages = append(report.Messages, fmt.Sprintf("failed %s: %s", imported.Source, err))
+						continue
+					}
+				}
+				report.Updated++
+				continue
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+		} else if known[key] {
+			report.Duplicates++
+			continue
+		}
+		known[key] = true
+
+		if options.DryRun {
+			report.Imported++
+			continue
+		}
+
+		err = store.AddNote(ctx, note.NewLocal(imported.ID, imported.Title, imported.Text, addDate, changeDate, 0, imported.Tags))
+		if err != nil {
+			report.Failed++
+			report.Messages = append(report.Messages, fmt.Sprintf("failed %s: %s", imported.Source, err))
+			continue
+		}
+		report.Imported++
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"note"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const SIMPLENOTE_EXPORT = `{
+  "activeNotes": [
+    {"id": "a1", "content": "Title line\nbody", "creationDate": "2024-03-13T12:10:00.000Z", "lastModified": "2024-03-14T12:10:00.000Z",
+     "tags": ["work", " work ", "a,b", ""]},
+    {"id": "a2", "content": "  \n"}
+  ],
+  "trashedNotes": [{"id": "t1", "content": "gone"}]
+}`
+
+const KEEP_JSON_EXPORT = `{"title": "Shopping", "textContent": "",
+  "listContent": [{"text": "milk", "isChecked": true}, {"text": "eggs", "isChecked": false}],
+  "createdTimestampUsec": 1710331800000000, "userEditedTimestampUsec": 1710331800000000,
+  "isTrashed": false, "labels": [{"name": "Home"}, {"name": "Errands, weekly"}]}`
+
+const KEEP_HTML_EXPORT = `<html><head><title>Plans</title></head><body><div class="note">
+<div class="heading">Mar 13, 2024, 12:10:00 PM</div>
+<div class="title">Plans</div>
+<div class="content">First<br>Second</div>
+<div class="labels"><span class="label"><span class="label-name">Home</span></span><span class="label"><span class="label-name">Travel</span></span></div>
+</div></body></html>`
+
+const ENEX_EXPORT = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export>
+<note><title>Todo</title>
+<content><![CDATA[<?xml version="1.0" encoding="UTF-8"?><en-note><div><en-todo checked="true"/>done</div><div><en-todo/>open</div></en-note>]]></content>
+<created>20240313T121000Z</created><updated>20240314T090000Z</updated>
+<tag>work</tag><tag>a, b</tag><tag>work</tag></note>
+<note><title></title><content><![CDATA[<en-note></en-note>]]></content></note>
+</en-export>`
+
+func describeImported(notes []ImportedNote) []string {
+	var described []string
+	for _, n := range notes {
+		described = append(described, n.Title+"|"+n.Text+"|"+strings.Join(n.Tags, ";"))
+	}
+	return described
+}
+
+func TestReaders(t *testing.T) {
+	var tests = []struct {
+		name    string
+		read    func(io.Reader, string, *Report) ([]ImportedNote, error)
+		input   string
+		notes   []string
+		skipped int
+		changed time.Time
+	}{
+		{"simplenote", ReadSimplenote, SIMPLENOTE_EXPORT,
+			[]string{"Title line|body|work;a b"}, 2, time.Date(2024, 3, 14, 12, 10, 0, 0, time.UTC)},
+		{"keep json", ReadKeepJSON, KEEP_JSON_EXPORT,
+			[]string{"Shopping|- [x] milk\n- [ ] eggs|Home;Errands weekly"}, 0, time.Unix(1710331800, 0)},
+		{"keep json in trash", ReadKeepJSON, `{"title": "gone", "isTrashed": true}`, nil, 1, time.Time{}},
+		{"keep html", ReadKeepHTML, KEEP_HTML_EXPORT,
+			[]string{"Plans|First\nSecond|Home;Travel"}, 0, time.Date(2024, 3, 13, 12, 10, 0, 0, time.Local)},
+		{"enex", ReadENEX, ENEX_EXPORT,
+			[]string{"Todo|[x] done\n[ ] open|work;a b"}, 1, time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		var report Report
+
+		notes, err := test.read(strings.NewReader(test.input), test.name, &report)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		var described []string = describeImported(notes)
+		if strings.Join(described, "\n---\n") != strings.Join(test.notes, "\n---\n") {
+			t.Errorf("%s: read %q, expected %q", test.name, described, test.notes)
+		}
+		if report.Skipped != test.skipped {
+			t.Errorf("%s: skipped %d, expected %d", test.name, report.Skipped, test.skipped)
+		}
+		if len(notes) > 0 && !notes[0].ChangeDate.Equal(test.changed) {
+			t.Errorf("%s: changed %s, expected %s", test.name, notes[0].ChangeDate, test.changed)
+		}
+	}
+}
+
+// A testStore keeps notes in memory.
+type testStore struct {
+	notes map[int]note.Note
+	next  int
+}
+
+func newTestStore() *testStore {
+	var store *testStore = &testStore{notes: make(map[int]note.Note), next: 2}
+	store.notes[1] = note.NewLocal(1, "existing", "text", time.Now(), time.Now(), 2, nil)
+	return store
+}
+
+func (s *testStore) EachNote(ctx context.Context, function func(note.Note) error) error {
+	var noteIDs []int
+	for noteID := range s.notes {
+		noteIDs = append(noteIDs, noteID)
+	}
+	sort.Ints(noteIDs)
+
+	for _, noteID := range noteIDs {
+		if err := function(s.notes[noteID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *testStore) GetNote(ctx context.Context, noteID int) (note.Note, error) {
+	n, found := s.notes[noteID]
+	if !found {
+		return note.Note{}, sql.ErrNoRows
+	}
+	return n, nil
+}
+
+func (s *testStore) AddNote(ctx context.Context, n note.Note) error {
+	var noteID int = n.NoteID()
+	if noteID == 0 {
+		noteID = s.next
+	}
+	if noteID >= s.next {
+		s.next = noteID + 1
+	}
+	s.notes[noteID] = note.NewLocal(noteID, n.Title(), n.Text(), n.AddDate(), n.ChangeDate(), 1, n.Tags())
+	return nil
+}
+
+func (s *testStore) UpdateNote(ctx context.Context, n note.Note) error {
+	s.notes[n.NoteID()] = note.NewLocal(n.NoteID(), n.Title(), n.Text(), n.AddDate(), n.ChangeDate(), n.Version()+1, n.Tags())
+	return nil
+}
+
+// TestImport imports into a store that holds note 1, "existing", at
+// version 2.
+func TestImport(t *testing.T) {
+	var tests = []struct {
+		name    string
+		notes   []ImportedNote
+		dryRun  bool
+		report  Report
+		noteIDs []int
+	}{
+		{"new note", []ImportedNote{{Title: "new", Text: "text"}},
+			false, Report{Read: 1, Imported: 1}, []int{1, 2}},
+		{"duplicate of a stored note", []ImportedNote{{Title: "existing", Text: "text\r\n"}},
+			false, Report{Read: 1, Duplicates: 1}, []int{1}},
+		{"duplicate within the import", []ImportedNote{{Title: "new", Text: "text"}, {Title: "new", Text: "text"}},
+			false, Report{Read: 2, Imported: 1, Duplicates: 1}, []int{1, 2}},
+		{"update by id", []ImportedNote{{ID: 1, Version: 2, Title: "existing", Text: "edited"}},
+			false, Report{Read: 1, Updated: 1}, []int{1}},
+		{"unchanged by id", []ImportedNote{{ID: 1, Version: 2, Title: "existing", Text: "text"}},
+			false, Report{Read: 1, Unchanged: 1}, []int{1}},
+		{"changed since the export", []ImportedNote{{ID: 1, Version: 1, Title: "existing", Text: "edited"}},
+			false, Report{Read: 1, Conflicts: 1}, []int{1}},
+		{"new note with an id", []ImportedNote{{ID: 7, Title: "exported", Text: "text"}},
+			false, Report{Read: 1, Imported: 1}, []int{1, 7}},
+		{"dry run", []ImportedNote{{Title: "new", Text: "text"}, {ID: 1, Version: 2, Title: "existing", Text: "edited"}},
+			true, Report{Read: 2, Imported: 1, Updated: 1}, []int{1}},
+	}
+
+	for _, test := range tests {
+		var store *testStore = newTestStore()
+		var report Report
+
+		err := Import(context.Background(), store, test.notes, Options{DryRun: test.dryRun}, &report)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		report.Messages = nil
+		if report.String() != test.report.String() {
+			t.Errorf("%s: %s, expected %s", test.name, strings.TrimSpace(report.String()), strings.TrimSpace(test.report.String()))
+		}
+
+		var noteIDs []int
+		for noteID := range store.notes {
+			noteIDs = append(noteIDs, noteID)
+		}
+		sort.Ints(noteIDs)
+		if len(noteIDs) != len(test.noteIDs) {
+			t.Errorf("%s: notes %v, expected %v", test.name, noteIDs, test.noteIDs)
+			continue
+		}
+		for i := range noteIDs {
+			if noteIDs[i] != test.noteIDs[i] {
+				t.Errorf("%s: notes %v, expected %v", test.name, noteIDs, test.noteIDs)
+				break
+			}
+		}
+	}
+}
+
+// TestReadMarkdownDirectory reads a folder like one an export was unpacked
+// to and edited in.
+func TestReadMarkdownDirectory(t *testing.T) {
+	var directory string = t.TempDir()
+	var files = map[string]string{
+		"5-exported.md":    "---\nid: 5\ntitle: \"Exported\"\nchangeDate: 2024-03-14T08:00:00Z\nversion: 3\ntags: [\"a\", \"b\"]\n---\nexported text\n",
+		"notes/plain.txt":  "plain text",
+		"notes/broken.md":  "---\ndate: yesterday\n---\n",
+		".git/HEAD.md":     "hidden",
+		"picture.png":      "not a note",
+		".hidden-draft.md": "hidden",
+	}
+	for name, content := range files {
+		var path string = filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var report Report
+	notes, err := ReadMarkdownDirectory(directory, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var described []string = describeImported(notes)
+	var expected []string = []string{"Exported|exported text\n|a;b", "plain|plain text|"}
+	if strings.Join(described, "\n---\n") != strings.Join(expected, "\n---\n") {
+		t.Errorf("read %q, expected %q", described, expected)
+	}
+	if report.Failed != 1 {
+		t.Errorf("%d files failed, expected 1", report.Failed)
+	}
+
+	// The file was written after the date in its front matter.
+	if len(notes) > 0 && (notes[0].ID != 5 || notes[0].Version != 3 || !notes[0].ChangeDate.After(time.Date(2024, 3, 14, 8, 0, 0, 0, time.UTC))) {
+		t.Errorf("exported note read as id %d, version %d, changed %s", notes[0].ID, notes[0].Version, notes[0].ChangeDate)
+	}
+}
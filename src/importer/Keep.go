@@ -0,0 +1,197 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"note"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const KEEP_HTML_DATE_FORMAT = "Jan 2, 2006, 3:04:05 PM"
+
+type keepListItem struct {
+	Text      string `json:"text"`
+	IsChecked bool   `json:"isChecked"`
+}
+
+type keepLabel struct {
+	Name string `json:"name"`
+}
+
+type keepNote struct {
+	Title                   string         `json:"title"`
+	TextContent             string         `json:"textContent"`
+	ListContent             []keepListItem `json:"listContent"`
+	CreatedTimestampUsec    int64          `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64          `json:"userEditedTimestampUsec"`
+	IsTrashed               bool           `json:"isTrashed"`
+	Labels                  []keepLabel    `json:"labels"`
+}
+
+var keepTitlePattern = regexp.MustCompile(`(?s)<div class="title">(.*?)</div>`)
+var keepContentPattern = regexp.MustCompile(`(?s)<div class="content">(.*?)</div>\s*(?:<div class="(?:labels|attachments|annotations)|</div>\s*</body>)`)
+var keepHeadingPattern = regexp.MustCompile(`(?s)<div class="heading">(.*?)</div>\s*<div class="(?:title|content)">`)
+var keepLabelPattern = regexp.MustCompile(`(?s)<span class="label-name">(.*?)</span>`)
+var keepTrashedPattern = regexp.MustCompile(`<span class="trashed">`)
+
+func fromMicroseconds(microseconds int64) time.Time {
+	if microseconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(microseconds/1000000, (microseconds%1000000)*1000)
+}
+
+func ReadKeepJSON(reader io.Reader, source string, report *Report) ([]ImportedNote, error) {
+	var keep keepNote
+
+	err := json.NewDecoder(reader).Decode(&keep)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+
+	if keep.IsTrashed {
+		report.skip(source, "in trash")
+		return nil, nil
+	}
+
+	var text string = keep.TextContent
+	if len(keep.ListContent) > 0 {
+		var items []string
+		for _, item := range keep.ListContent {
+			if item.IsChecked {
+				items = append(items, "- [x] "+item.Text)
+			} else {
+				items = append(items, "- [ ] "+item.Text)
+			}
+		}
+		text = strings.Join(items, "\n")
+	}
+
+	var title string = keep.Title
+	if title == "" {
+		title, text = firstLine(text)
+	}
+
+	if title == "" && text == "" {
+		report.skip(source, "empty")
+		return nil, nil
+	}
+
+	var labels []string
+	for _, label := range keep.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	return []ImportedNote{{
+		Source:     source,
+		Title:      title,
+		Text:       text,
+		Tags:       note.CleanTags(labels),
+		AddDate:    fromMicroseconds(keep.CreatedTimestampUsec),
+		ChangeDate: fromMicroseconds(keep.UserEditedTimestampUsec)}}, nil
+}
+
+// ReadKeepHTML reads the HTML that older Takeout exports contain instead of
+// JSON. It only knows the date of the last change.
+func ReadKeepHTML(reader io.Reader, source string, report *Report) ([]ImportedNote, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+
+	var page string = string(content)
+
+	if keepTrashedPattern.MatchString(page) {
+		report.skip(source, "in trash")
+		return nil, nil
+	}
+
+	var imported ImportedNote = ImportedNote{Source: source}
+
+	if match := keepTitlePattern.FindStringSubmatch(page); match != nil {
+		imported.Title = htmlToText(match[1])
+	}
+	if match := keepContentPattern.FindStringSubmatch(page); match != nil {
+		imported.Text = htmlToText(match[1])
+	}
+	var labels []string
+	for _, match := range keepLabelPattern.FindAllStringSubmatch(page, -1) {
+		labels = append(labels, htmlToText(match[1]))
+	}
+	imported.Tags = note.CleanTags(labels)
+
+	if match := keepHeadingPattern.FindStringSubmatch(page); match != nil {
+		changeDate, err := time.ParseInLocation(KEEP_HTML_DATE_FORMAT, strings.TrimSpace(htmlToText(match[1])), time.Local)
+		if err == nil {
+			imported.ChangeDate = changeDate
+			imported.AddDate = changeDate
+		}
+	}
+
+	if imported.Title == "" {
+		imported.Title, imported.Text = firstLine(imported.Text)
+	}
+
+	if imported.Title == "" && imported.Text == "" {
+		report.skip(source, "empty")
+		return nil, nil
+	}
+
+	return []ImportedNote{imported}, nil
+}
+
+// ReadKeepDirectory reads the Keep folder of a Google Takeout archive.
+// Takeout writes every note as JSON and as HTML; the JSON wins.
+func ReadKeepDirectory(directory string, report *Report) ([]ImportedNote, error) {
+	var notes []ImportedNote
+
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return notes, err
+	}
+
+	var hasJSON map[string]bool = make(map[string]bool)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			hasJSON[strings.TrimSuffix(entry.Name(), ".json")] = true
+		}
+	}
+
+	for _, entry := range entries {
+		var name string = entry.Name()
+		var read func(io.Reader, string, *Report) ([]ImportedNote, error)
+
+		if strings.HasSuffix(name, ".json") {
+			read = ReadKeepJSON
+		} else if strings.HasSuffix(name, ".html") && !hasJSON[strings.TrimSuffix(name, ".html")] {
+			read = ReadKeepHTML
+		} else {
+			continue
+		}
+
+		var path string = filepath.Join(directory, name)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return notes, err
+		}
+
+		found, err := read(file, path, report)
+		file.Close()
+		if err != nil {
+			report.Failed++
+			report.Messages = append(report.Messages, err.Error())
+			continue
+		}
+
+		notes = append(notes, found...)
+	}
+
+	return notes, nil
+}
@@ -0,0 +1,100 @@
+package importer
+
+import (
+	"fmt"
+	"frontmatter"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var MARKDOWN_EXTENSIONS = []string{".md", ".markdown", ".txt"}
+
+func isMarkdownFile(name string) bool {
+	for _, extension := range MARKDOWN_EXTENSIONS {
+		if strings.HasSuffix(strings.ToLower(name), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadMarkdownFile reads a text or Markdown file, optionally with YAML or
+// TOML front matter. Without a title in the front matter the file name is
+// the title, without dates the modification time of the file is used.
+func ReadMarkdownFile(path string, report *Report) ([]ImportedNote, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	matter, body, _, err := frontmatter.Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var imported ImportedNote = ImportedNote{
+		Source:     path,
+		ID:         matter.ID,
+		Version:    matter.Version,
+		Title:      matter.Title,
+		Text:       body,
+		Tags:       matter.Tags,
+		AddDate:    matter.AddDate,
+		ChangeDate: matter.ChangeDate}
+
+	if imported.Title == "" {
+		imported.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	// A file edited after it was exported is newer than its front matter.
+	if imported.ChangeDate.Before(info.ModTime().Truncate(time.Second)) {
+		imported.ChangeDate = info.ModTime()
+	}
+	if imported.AddDate.IsZero() {
+		imported.AddDate = imported.ChangeDate
+	}
+
+	return []ImportedNote{imported}, nil
+}
+
+// ReadMarkdownDirectory reads all text and Markdown files below directory.
+// Hidden files and directories, like .git, are left out.
+func ReadMarkdownDirectory(directory string, report *Report) ([]ImportedNote, error) {
+	var notes []ImportedNote
+
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path != directory && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() || !isMarkdownFile(info.Name()) {
+			return nil
+		}
+
+		found, err := ReadMarkdownFile(path, report)
+		if err != nil {
+			report.Failed++
+			report.Messages = append(report.Messages, err.Error())
+			return nil
+		}
+
+		notes = append(notes, found...)
+		return nil
+	})
+
+	return notes, err
+}
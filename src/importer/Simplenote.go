@@ -0,0 +1,57 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"note"
+	"time"
+)
+
+type simplenoteNote struct {
+	ID           string    `json:"id"`
+	Content      string    `json:"content"`
+	CreationDate time.Time `json:"creationDate"`
+	LastModified time.Time `json:"lastModified"`
+	Tags         []string  `json:"tags"`
+}
+
+type simplenoteExport struct {
+	ActiveNotes  []simplenoteNote `json:"activeNotes"`
+	TrashedNotes []simplenoteNote `json:"trashedNotes"`
+}
+
+// ReadSimplenote reads the notes.json of a Simplenote export. Simplenote
+// has no titles, the first line of a note is used as its title.
+func ReadSimplenote(reader io.Reader, source string, report *Report) ([]ImportedNote, error) {
+	var export simplenoteExport
+	var notes []ImportedNote
+
+	err := json.NewDecoder(reader).Decode(&export)
+	if err != nil {
+		return notes, fmt.Errorf("%s: %s", source, err)
+	}
+
+	for _, trashed := range export.TrashedNotes {
+		report.skip(fmt.Sprintf("%s#%s", source, trashed.ID), "in trash")
+	}
+
+	for _, active := range export.ActiveNotes {
+		title, text := firstLine(active.Content)
+
+		if title == "" && text == "" {
+			report.skip(fmt.Sprintf("%s#%s", source, active.ID), "empty")
+			continue
+		}
+
+		notes = append(notes, ImportedNote{
+			Source:     fmt.Sprintf("%s#%s", source, active.ID),
+			Title:      title,
+			Text:       text,
+			Tags:       note.CleanTags(active.Tags),
+			AddDate:    active.CreationDate,
+			ChangeDate: active.LastModified})
+	}
+
+	return notes, nil
+}
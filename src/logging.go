@@ -0,0 +1,116 @@
+package main
+
+import (
+	"config"
+	"context"
+	"crypto/rand"
+	"database/manager"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+// A request keeps the id a proxy in front sent in REQUEST_ID_HEADER, or gets
+// a new one. The id is sent back in the same header and is on every log
+// line written for the request.
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestLoggerKey struct{}
+
+// setUpLogging writes the log to stderr at the level and in the format of
+// the settings. Messages of the log package end up there as well, at info
+// level.
+func setUpLogging(logSettings config.Config) {
+	var options *slog.HandlerOptions = &slog.HandlerOptions{Level: logSettings.Level()}
+
+	var handler slog.Handler
+	if logSettings.LogFormat == config.LOG_FORMAT_JSON {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal ends the process after logging err.
+func fatal(err error) {
+	slog.Error("ShareNotes cannot continue.", "error", err)
+	os.Exit(1)
+}
+
+func newRequestID() string {
+	var id []byte = make([]byte, 8)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// loggerFor returns the logger of a request, which adds its id.
+func loggerFor(request *http.Request) *slog.Logger {
+	if logger, found := request.Context().Value(requestLoggerKey{}).(*slog.Logger); found {
+		return logger
+	}
+	return slog.Default()
+}
+
+// storeFor returns dbManager with the logger of the request, so the
+// database errors of a request can be told apart, and its context, so its
+// queries stop when the client goes away. The git storage takes neither.
+func storeFor(request *http.Request) noteStore {
+	if sqlite, isSQLite := dbManager.(*manager.DatabaseManager); isSQLite {
+		return sqlite.WithLogger(loggerFor(request))
+	}
+	return dbManager
+}
+
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// logRequests gives every request an id and writes an access log line for
+// it once it is answered. ShareNotes has no accounts; the user is the one a
+// proxy in front authenticated with basic auth, if any.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var start time.Time = time.Now()
+
+		var requestID string = request.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		writer.Header().Set(REQUEST_ID_HEADER, requestID)
+
+		var logger *slog.Logger = slog.Default().With("request_id", requestID)
+		var recorder *statusRecorder = &statusRecorder{ResponseWriter: writer}
+
+		next.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), requestLoggerKey{}, logger)))
+
+		user, _, hasUser := request.BasicAuth()
+		if !hasUser || user == "" {
+			user = "-"
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		logger.Info("request",
+			"method", request.Method,
+			"path", request.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"client_ip", clientIP(request),
+			"user", user)
+	})
+}
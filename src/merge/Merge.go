@@ -0,0 +1,229 @@
+package merge
+
+import (
+	"strings"
+)
+
+const LOCAL_MARKER = "<<<<<<< yours"
+const SEPARATOR_MARKER = "======="
+const REMOTE_MARKER = ">>>>>>> theirs"
+
+// A Hunk is a run of lines. Stable hunks are unchanged on both sides,
+// resolved hunks were changed on one side only (or identically on both),
+// conflicting hunks were changed differently on both sides.
+type Hunk struct {
+	Base     []string
+	Local    []string
+	Remote   []string
+	Merged   []string
+	Conflict bool
+}
+
+type Result struct {
+	Hunks     []Hunk
+	Conflicts int
+}
+
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// matchLines returns, for every line of a, the index of the line of b it is
+// paired with in a longest common subsequence, or -1.
+func matchLines(a []string, b []string) []int {
+	var matches []int = make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	var prefix int = 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		matches[prefix] = prefix
+		prefix++
+	}
+
+	var suffix int = 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		matches[len(a)-1-suffix] = len(b) - 1 - suffix
+		suffix++
+	}
+
+	var middleA []string = a[prefix : len(a)-suffix]
+	var middleB []string = b[prefix : len(b)-suffix]
+
+	var lengths [][]int = make([][]int, len(middleA)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(middleB)+1)
+	}
+
+	for i := len(middleA) - 1; i >= 0; i-- {
+		for j := len(middleB) - 1; j >= 0; j-- {
+			if middleA[i] == middleB[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	for i, j := 0, 0; i < len(middleA) && j < len(middleB); {
+		if middleA[i] == middleB[j] {
+			matches[prefix+i] = prefix + j
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return matches
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func resolve(base []string, local []string, remote []string) Hunk {
+	var hunk Hunk = Hunk{Base: base, Local: local, Remote: remote}
+
+	if equalLines(local, base) {
+		hunk.Merged = remote
+	} else if equalLines(remote, base) || equalLines(local, remote) {
+		hunk.Merged = local
+	} else {
+		hunk.Conflict = true
+	}
+
+	return hunk
+}
+
+// Merge combines the changes local and remote made to base, line by line.
+// Changes to different parts of the text are merged automatically; changes
+// to the same lines end up as conflicting hunks.
+func Merge(base string, local string, remote string) Result {
+	var baseLines []string = splitLines(base)
+	var localLines []string = splitLines(local)
+	var remoteLines []string = splitLines(remote)
+
+	var localMatches []int = matchLines(baseLines, localLines)
+	var remoteMatches []int = matchLines(baseLines, remoteLines)
+
+	var result Result
+	var i, a, b int = 0, 0, 0
+
+	for i < len(baseLines) || a < len(localLines) || b < len(remoteLines) {
+		var stable int = 0
+		for i+stable < len(baseLines) && localMatches[i+stable] == a+stable && remoteMatches[i+stable] == b+stable {
+			stable++
+		}
+
+		if stable > 0 {
+			var lines []string = baseLines[i : i+stable]
+			result.Hunks = append(result.Hunks, Hunk{Base: lines, Local: lines, Remote: lines, Merged: lines})
+			i += stable
+			a += stable
+			b += stable
+			continue
+		}
+
+		var next int = i
+		for next < len(baseLines) && (localMatches[next] < 0 || remoteMatches[next] < 0) {
+			next++
+		}
+
+		var hunk Hunk
+		if next == len(baseLines) {
+			hunk = resolve(baseLines[i:], localLines[a:], remoteLines[b:])
+			i, a, b = len(baseLines), len(localLines), len(remoteLines)
+		} else {
+			hunk = resolve(baseLines[i:next], localLines[a:localMatches[next]], remoteLines[b:remoteMatches[next]])
+			i, a, b = next, localMatches[next], remoteMatches[next]
+		}
+
+		if hunk.Conflict {
+			result.Conflicts++
+		}
+		result.Hunks = append(result.Hunks, hunk)
+	}
+
+	return result
+}
+
+// Text returns the merged text with conflict markers around every
+// conflicting hunk.
+func (r Result) Text() string {
+	var lines []string
+
+	for _, hunk := range r.Hunks {
+		if hunk.Conflict {
+			lines = append(lines, LOCAL_MARKER)
+			lines = append(lines, hunk.Local...)
+			lines = append(lines, SEPARATOR_MARKER)
+			lines = append(lines, hunk.Remote...)
+			lines = append(lines, REMOTE_MARKER)
+		} else {
+			lines = append(lines, hunk.Merged...)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// MergeLine is Merge for single line values such as titles.
+func MergeLine(base string, local string, remote string) (string, bool) {
+	if local == base || local == remote {
+		return remote, false
+	}
+	if remote == base {
+		return local, false
+	}
+	return local, true
+}
+
+// MergeSet is Merge for sets such as tags: the values local added to base
+// are added to remote, the ones local removed are removed from it. Sets do
+// not conflict.
+func MergeSet(base []string, local []string, remote []string) []string {
+	var inBase map[string]bool = make(map[string]bool)
+	var inLocal map[string]bool = make(map[string]bool)
+	var result []string
+	var seen map[string]bool = make(map[string]bool)
+
+	for _, value := range base {
+		inBase[value] = true
+	}
+	for _, value := range local {
+		inLocal[value] = true
+	}
+
+	for _, value := range remote {
+		if !seen[value] && (inLocal[value] || !inBase[value]) {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	for _, value := range local {
+		if !seen[value] && !inBase[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
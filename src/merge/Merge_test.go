@@ -0,0 +1,93 @@
+package merge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	var tests = []struct {
+		name      string
+		base      string
+		local     string
+		remote    string
+		merged    string
+		conflicts int
+	}{
+		{"non-overlapping edits", "a\nb\nc\nd\ne", "a\nB\nc\nd\ne", "a\nb\nc\nD\ne", "a\nB\nc\nD\ne", 0},
+		{"identical edits", "a\nb\nc", "a\nB\nc", "a\nB\nc", "a\nB\nc", 0},
+		{"overlapping edits", "a\nb\nc", "a\nX\nc", "a\nY\nc", "a\n" + LOCAL_MARKER + "\nX\n" + SEPARATOR_MARKER + "\nY\n" + REMOTE_MARKER + "\nc", 1},
+		{"adjacent edits", "a\nb\nc", "a\nB\nc", "a\nb\nC", "a\n" + LOCAL_MARKER + "\nB\nc\n" + SEPARATOR_MARKER + "\nb\nC\n" + REMOTE_MARKER, 1},
+		{"edit and delete", "a\nb\nc", "a\nB\nc", "a\nc", "a\n" + LOCAL_MARKER + "\nB\n" + SEPARATOR_MARKER + "\n" + REMOTE_MARKER + "\nc", 1},
+		{"insertions at the same point", "a\nb", "a\nX\nb", "a\nY\nb", "a\n" + LOCAL_MARKER + "\nX\n" + SEPARATOR_MARKER + "\nY\n" + REMOTE_MARKER + "\nb", 1},
+		{"same insertion at the same point", "a\nb", "a\nX\nb", "a\nX\nb", "a\nX\nb", 0},
+		{"insertions at different points", "a\nb\nc", "X\na\nb\nc", "a\nb\nc\nY", "X\na\nb\nc\nY", 0},
+		{"empty base, one side", "", "x\ny", "", "x\ny", 0},
+		{"empty base, both sides", "", "x", "y", LOCAL_MARKER + "\nx\n" + SEPARATOR_MARKER + "\ny\n" + REMOTE_MARKER, 1},
+		{"empty base, same text", "", "x", "x", "x", 0},
+		{"local unchanged", "a\nb\nc", "a\nb\nc", "a\nc\nd", "a\nc\nd", 0},
+		{"remote unchanged", "a\nb\nc", "z\na\nb", "a\nb\nc", "z\na\nb", 0},
+		{"both unchanged", "a\nb", "a\nb", "a\nb", "a\nb", 0},
+		{"windows line endings", "a\r\nb\r\nc", "a\r\nB\r\nc", "a\nb\nC", "a\n" + LOCAL_MARKER + "\nB\nc\n" + SEPARATOR_MARKER + "\nb\nC\n" + REMOTE_MARKER, 1},
+	}
+
+	for _, test := range tests {
+		var result Result = Merge(test.base, test.local, test.remote)
+		if result.Conflicts != test.conflicts {
+			t.Errorf("%s: %d conflicts, expected %d", test.name, result.Conflicts, test.conflicts)
+		}
+		if text := result.Text(); text != test.merged {
+			t.Errorf("%s: merged to %q, expected %q", test.name, text, test.merged)
+		}
+	}
+}
+
+func TestMergeLine(t *testing.T) {
+	var tests = []struct {
+		base     string
+		local    string
+		remote   string
+		merged   string
+		conflict bool
+	}{
+		{"title", "title", "title", "title", false},
+		{"title", "title", "remote", "remote", false},
+		{"title", "local", "title", "local", false},
+		{"title", "same", "same", "same", false},
+		{"title", "local", "remote", "local", true},
+		{"", "local", "remote", "local", true},
+	}
+
+	for _, test := range tests {
+		merged, conflict := MergeLine(test.base, test.local, test.remote)
+		if merged != test.merged || conflict != test.conflict {
+			t.Errorf("MergeLine(%q, %q, %q) = %q, %v, expected %q, %v", test.base, test.local, test.remote, merged, conflict, test.merged, test.conflict)
+		}
+	}
+}
+
+func TestMergeSet(t *testing.T) {
+	var tests = []struct {
+		name   string
+		base   []string
+		local  []string
+		remote []string
+		merged []string
+	}{
+		{"unchanged", []string{"a", "b"}, []string{"a", "b"}, []string{"a", "b"}, []string{"a", "b"}},
+		{"added locally", []string{"a"}, []string{"a", "b"}, []string{"a"}, []string{"a", "b"}},
+		{"added remotely", []string{"a"}, []string{"a"}, []string{"a", "c"}, []string{"a", "c"}},
+		{"added on both sides", []string{"a"}, []string{"a", "b"}, []string{"a", "c"}, []string{"a", "c", "b"}},
+		{"removed locally", []string{"a", "b"}, []string{"a"}, []string{"a", "b", "c"}, []string{"a", "c"}},
+		{"removed remotely", []string{"a", "b"}, []string{"a", "b"}, []string{"b"}, []string{"b"}},
+		{"removed and added", []string{"a", "b"}, []string{"b", "x"}, []string{"a", "y"}, []string{"y", "x"}},
+		{"no base", nil, []string{"a"}, []string{"b"}, []string{"b", "a"}},
+	}
+
+	for _, test := range tests {
+		var merged []string = MergeSet(test.base, test.local, test.remote)
+		if strings.Join(merged, ",") != strings.Join(test.merged, ",") {
+			t.Errorf("%s: merged to %v, expected %v", test.name, merged, test.merged)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Buckets in seconds for request latencies and for database queries, which
+// are a lot faster.
+var REQUEST_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+var QUERY_BUCKETS = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1}
+
+// A Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(writer io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mutex.Unlock()
+}
+
+// Write writes every metric, series sorted by their labels.
+func (r *Registry) Write(writer io.Writer) {
+	r.mutex.Lock()
+	var metrics []metric = append([]metric(nil), r.metrics...)
+	r.mutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(writer)
+	}
+}
+
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", CONTENT_TYPE)
+	r.Write(writer)
+}
+
+func writeHeader(writer io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// labels formats names and values as {name="value",...}, extra is appended
+// as it is.
+func labels(names []string, values []string, extra string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// series keeps the values of a metric apart by their label values.
+type series struct {
+	mutex      sync.Mutex
+	labelNames []string
+	values     map[string][]string
+}
+
+func newSeries(labelNames []string) series {
+	return series{labelNames: labelNames, values: make(map[string][]string)}
+}
+
+// key must be called with the mutex held.
+func (s *series) key(labelValues []string) string {
+	if len(labelValues) != len(s.labelNames) {
+		panic(fmt.Sprintf("metrics: %d label values for the labels %v", len(labelValues), s.labelNames))
+	}
+
+	var key string = strings.Join(labelValues, "\xff")
+	if _, known := s.values[key]; !known {
+		s.values[key] = append([]string(nil), labelValues...)
+	}
+	return key
+}
+
+func (s *series) sortedKeys() []string {
+	var keys []string
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type Counter struct {
+	series
+	name   string
+	help   string
+	counts map[string]float64
+}
+
+func (r *Registry) Counter(name string, help string, labelNames ...string) *Counter {
+	var c *Counter = &Counter{series: newSeries(labelNames), name: name, help: help, counts: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mutex.Lock()
+	c.counts[c.key(labelValues)] += value
+	c.mutex.Unlock()
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(writer io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	writeHeader(writer, c.name, c.help, "counter")
+	if len(c.labelNames) == 0 && len(c.counts) == 0 {
+		fmt.Fprintf(writer, "%s 0\n", c.name)
+	}
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(writer, "%s%s %s\n", c.name, labels(c.labelNames, c.values[key], ""), formatValue(c.counts[key]))
+	}
+}
+
+type histogramValue struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+type Histogram struct {
+	series
+	name       string
+	help       string
+	bounds     []float64
+	histograms map[string]*histogramValue
+}
+
+func (r *Registry) Histogram(name string, help string, bounds []float64, labelNames ...string) *Histogram {
+	var h *Histogram = &Histogram{series: newSeries(labelNames), name: name, help: help, bounds: bounds, histograms: make(map[string]*histogramValue)}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var key string = h.key(labelValues)
+	var v *histogramValue = h.histograms[key]
+	if v == nil {
+		v = &histogramValue{buckets: make([]uint64, len(h.bounds))}
+		h.histograms[key] = v
+	}
+
+	for i, bound := range h.bounds {
+		if value <= bound {
+			v.buckets[i]++
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *Histogram) write(writer io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	writeHeader(writer, h.name, h.help, "histogram")
+	for _, key := range h.sortedKeys() {
+		var v *histogramValue = h.histograms[key]
+		var labelValues []string = h.values[key]
+
+		for i, bound := range h.bounds {
+			fmt.Fprintf(writer, "%s_bucket%s %d\n", h.name, labels(h.labelNames, labelValues, `le="`+formatValue(bound)+`"`), v.buckets[i])
+		}
+		fmt.Fprintf(writer, "%s_bucket%s %d\n", h.name, labels(h.labelNames, labelValues, `le="+Inf"`), v.count)
+		fmt.Fprintf(writer, "%s_sum%s %s\n", h.name, labels(h.labelNames, labelValues, ""), formatValue(v.sum))
+		fmt.Fprintf(writer, "%s_count%s %d\n", h.name, labels(h.labelNames, labelValues, ""), v.count)
+	}
+}
+
+// A GaugeFunc is read when the metrics are written. If value fails the gauge
+// is left out.
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() (float64, error)
+}
+
+func (r *Registry) GaugeFunc(name string, help string, value func() (float64, error)) *GaugeFunc {
+	var g *GaugeFunc = &GaugeFunc{name: name, help: help, value: value}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(writer io.Writer) {
+	value, err := g.value()
+	if err != nil {
+		return
+	}
+
+	writeHeader(writer, g.name, g.help, "gauge")
+	fmt.Fprintf(writer, "%s %s\n", g.name, formatValue(value))
+}
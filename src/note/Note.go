@@ -10,6 +10,8 @@ type Note struct {
 	text       string
 	addDate    time.Time
 	changeDate time.Time
+	version    int
+	tags       []string
 }
 
 func New(title string, text string) Note {
@@ -17,8 +19,8 @@ func New(title string, text string) Note {
 	return n
 }
 
-func NewLocal(noteID int, title string, text string, addDate time.Time, changeDate time.Time) Note {
-	n := Note{noteID: noteID, title: title, text: text, addDate: addDate, changeDate: changeDate}
+func NewLocal(noteID int, title string, text string, addDate time.Time, changeDate time.Time, version int, tags []string) Note {
+	n := Note{noteID: noteID, title: title, text: text, addDate: addDate, changeDate: changeDate, version: version, tags: tags}
 	return n
 }
 
@@ -32,6 +34,11 @@ func (n *Note) SetText(text string) {
 	n.changeDate = time.Now()
 }
 
+func (n *Note) SetTags(tags []string) {
+	n.tags = tags
+	n.changeDate = time.Now()
+}
+
 func (n Note) NoteID() int {
 	return n.noteID
 }
@@ -51,3 +58,11 @@ func (n Note) AddDate() time.Time {
 func (n Note) ChangeDate() time.Time {
 	return n.changeDate
 }
+
+func (n Note) Version() int {
+	return n.version
+}
+
+func (n Note) Tags() []string {
+	return n.tags
+}
@@ -0,0 +1,56 @@
+package note
+
+import (
+	"strings"
+)
+
+const TAG_SEPARATOR = ","
+
+// SplitTags parses tags as they are typed into a form or stored in the
+// database: separated by commas, surrounding spaces and repeats removed.
+func SplitTags(tags string) []string {
+	var result []string
+	var seen map[string]bool = make(map[string]bool)
+
+	for _, tag := range strings.Split(tags, TAG_SEPARATOR) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	return result
+}
+
+func JoinTags(tags []string) string {
+	return strings.Join(SplitTags(strings.Join(tags, TAG_SEPARATOR)), TAG_SEPARATOR)
+}
+
+func (n Note) HasTag(tag string) bool {
+	for _, t := range n.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanTags prepares tags that come one by one, e.g. from an import. A comma
+// within a tag would split it when it is stored, so it becomes a space.
+func CleanTags(tags []string) []string {
+	var result []string
+	var seen map[string]bool = make(map[string]bool)
+
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.Replace(tag, TAG_SEPARATOR, " ", -1)), " ")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	return result
+}
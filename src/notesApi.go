@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/manager"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"note"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MAX_NOTE_REQUEST_BYTES = 4 << 20
+
+type apiNoteUpdate struct {
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+}
+
+func noteETag(n note.Note) string {
+	return fmt.Sprintf("\"%d\"", n.Version())
+}
+
+// parseIfMatch returns the note version a client expects. A "*" matches
+// whatever version is current.
+func parseIfMatch(header string, current note.Note) (int, error) {
+	header = strings.TrimSpace(header)
+
+	if header == "*" {
+		return current.Version(), nil
+	}
+
+	header = strings.TrimPrefix(header, "W/")
+
+	return strconv.Atoi(strings.Trim(header, "\""))
+}
+
+func (s *server) apiNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+	foundNote, err := storeFor(request).GetNote(request.Context(), noteID)
+	if err == sql.ErrNoRows {
+		writeJSONError(writer, http.StatusNotFound, "Note not found.")
+		return
+	} else if err != nil {
+		writeJSONError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if request.Method == "PUT" || request.Method == "DELETE" {
+		lease, err := s.leasedElsewhere(request, noteID)
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, err.Error())
+			return
+		} else if lease != nil {
+			writeJSONError(writer, http.StatusConflict, leaseHeldMessage(lease))
+			return
+		}
+	}
+
+	switch request.Method {
+	case "GET", "HEAD":
+		getApiNoteHandler(writer, request, foundNote)
+	case "PUT":
+		putApiNoteHandler(writer, request, foundNote)
+	case "DELETE":
+		deleteApiNoteHandler(writer, request, foundNote)
+	}
+}
+
+func getApiNoteHandler(writer http.ResponseWriter, request *http.Request, foundNote note.Note) {
+	writer.Header().Set("ETag", noteETag(foundNote))
+
+	if request.Header.Get("If-None-Match") == noteETag(foundNote) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, noteToApiNote(foundNote))
+}
+
+func writePreconditionFailed(writer http.ResponseWriter, current note.Note) {
+	writer.Header().Set("ETag", noteETag(current))
+	writeJSON(writer, http.StatusPreconditionFailed, noteToApiNote(current))
+}
+
+func putApiNoteHandler(writer http.ResponseWriter, request *http.Request, foundNote note.Note) {
+	if request.Header.Get("If-Match") == "" {
+		writeJSONError(writer, http.StatusPreconditionRequired, "An If-Match header with the note's ETag is required.")
+		return
+	}
+
+	version, err := parseIfMatch(request.Header.Get("If-Match"), foundNote)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, "If-Match must be an ETag returned by this server.")
+		return
+	}
+
+	if !strings.HasPrefix(request.Header.Get("Content-Type"), "application/json") {
+		writeJSONError(writer, http.StatusUnsupportedMediaType, "Expected an application/json body.")
+		return
+	}
+
+	var update apiNoteUpdate
+
+	err = json.NewDecoder(http.MaxBytesReader(writer, request.Body, MAX_NOTE_REQUEST_BYTES)).Decode(&update)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Leaving out "tags" keeps the tags the note has.
+	if update.Tags == nil {
+		update.Tags = foundNote.Tags()
+	}
+
+	err = storeFor(request).UpdateNote(request.Context(), note.NewLocal(foundNote.NoteID(), update.Title, update.Text, foundNote.AddDate(), time.Now(), version, update.Tags))
+	if err == manager.ErrVersionConflict {
+		current, err := storeFor(request).GetNote(request.Context(), foundNote.NoteID())
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writePreconditionFailed(writer, current)
+		return
+	} else if err != nil {
+		writeJSONError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	savedNote, err := storeFor(request).GetNote(request.Context(), foundNote.NoteID())
+	if err != nil {
+		writeJSONError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writer.Header().Set("ETag", noteETag(savedNote))
+	writeJSON(writer, http.StatusOK, noteToApiNote(savedNote))
+}
+
+func deleteApiNoteHandler(writer http.ResponseWriter, request *http.Request, foundNote note.Note) {
+	if request.Header.Get("If-Match") == "" {
+		writeJSONError(writer, http.StatusPreconditionRequired, "An If-Match header with the note's ETag is required.")
+		return
+	}
+
+	version, err := parseIfMatch(request.Header.Get("If-Match"), foundNote)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, "If-Match must be an ETag returned by this server.")
+		return
+	}
+
+	err = storeFor(request).DeleteNote(request.Context(), foundNote.NoteID(), version)
+	if err == manager.ErrVersionConflict {
+		current, err := storeFor(request).GetNote(request.Context(), foundNote.NoteID())
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writePreconditionFailed(writer, current)
+		return
+	} else if err == sql.ErrNoRows {
+		writeJSONError(writer, http.StatusNotFound, "Note not found.")
+		return
+	} else if err != nil {
+		writeJSONError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"config"
+	"database/manager"
+	"encryption"
+	"errors"
+	"net/http"
+	"net/url"
+	"router"
+	"strconv"
+	"time"
+)
+
+// The sizes the index offers; "?size=" takes any size up to
+// config.MAX_PAGE_SIZE.
+var PAGE_SIZES = []int{10, 25, 50, 100}
+
+var errBadPageSize = errors.New("The page size is not valid.")
+
+type apiNoteSummary struct {
+	NoteID     int       `json:"noteID"`
+	Title      string    `json:"title"`
+	Preview    string    `json:"preview"`
+	Truncated  bool      `json:"truncated"`
+	Encrypted  bool      `json:"encrypted"`
+	AddDate    time.Time `json:"addDate"`
+	ChangeDate time.Time `json:"changeDate"`
+	Version    int       `json:"version"`
+	Tags       []string  `json:"tags"`
+}
+
+type apiNotePage struct {
+	Notes    []apiNoteSummary `json:"notes"`
+	Next     string           `json:"next,omitempty"`
+	Previous string           `json:"previous,omitempty"`
+}
+
+// The preview of a note encrypted with a passphrase is ciphertext and left
+// out.
+func summaryToApiNoteSummary(s manager.NoteSummary) apiNoteSummary {
+	var tags []string = s.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	var summary apiNoteSummary = apiNoteSummary{
+		NoteID:     s.NoteID,
+		Title:      s.Title,
+		Preview:    s.Preview,
+		Truncated:  s.Truncated,
+		AddDate:    s.AddDate,
+		ChangeDate: s.ChangeDate,
+		Version:    s.Version,
+		Tags:       tags}
+
+	if encryption.IsEncrypted(s.Preview) {
+		summary.Preview = ""
+		summary.Truncated = false
+		summary.Encrypted = true
+	}
+
+	return summary
+}
+
+// pageQueryOf reads the page of the index a request asks for from "after"
+// or "before", a cursor, and "size".
+func (s *server) pageQueryOf(request *http.Request) (manager.PageQuery, error) {
+	var query manager.PageQuery = manager.PageQuery{Limit: s.settings.PageSize}
+	var values url.Values = request.URL.Query()
+
+	if size := values.Get("size"); size != "" {
+		limit, err := strconv.Atoi(size)
+		if err != nil || limit < 1 || limit > config.MAX_PAGE_SIZE {
+			return query, errBadPageSize
+		}
+		query.Limit = limit
+	}
+
+	if after := values.Get("after"); after != "" {
+		cursor, err := manager.ParsePageCursor(after)
+		if err != nil {
+			return query, err
+		}
+		query.After = &cursor
+	}
+
+	if before := values.Get("before"); before != "" {
+		if query.After != nil {
+			return query, manager.ErrBadCursor
+		}
+		cursor, err := manager.ParsePageCursor(before)
+		if err != nil {
+			return query, err
+		}
+		query.Before = &cursor
+	}
+
+	return query, nil
+}
+
+// pageURL links to another page of the index in the same format and size as
+// the request. The size is left out when it is the configured one.
+func (s *server) pageURL(request *http.Request, limit int, direction string, cursor *manager.PageCursor) string {
+	var values url.Values = url.Values{}
+	if cursor != nil {
+		values.Set(direction, cursor.String())
+	}
+	if limit != s.settings.PageSize {
+		values.Set("size", strconv.Itoa(limit))
+	}
+
+	var path string = routes.URL("index")
+	if route := router.RouteOf(request); route != nil && route.Name() == "indexAs" {
+		path = routes.URL("indexAs", router.Param(request, "format"))
+	}
+
+	if len(values) == 0 {
+		return path
+	}
+	return path + "?" + values.Encode()
+}
+
+// pageLinks returns the URLs of the pages around page, empty where there is
+// none.
+func (s *server) pageLinks(request *http.Request, query manager.PageQuery, page manager.NotePage) (string, string) {
+	var previous string
+	var next string
+
+	if page.HasPrevious && len(page.Notes) > 0 {
+		var cursor manager.PageCursor = page.Notes[0].Cursor()
+		previous = s.pageURL(request, query.Limit, "before", &cursor)
+	} else if page.HasPrevious {
+		previous = s.pageURL(request, query.Limit, "", nil)
+	}
+
+	if page.HasNext && len(page.Notes) > 0 {
+		var cursor manager.PageCursor = page.Notes[len(page.Notes)-1].Cursor()
+		next = s.pageURL(request, query.Limit, "after", &cursor)
+	}
+
+	return previous, next
+}
+
+// setPageLinks adds the pages around a page in Link headers, so clients of
+// every format can page through the index.
+func setPageLinks(writer http.ResponseWriter, previous string, next string) {
+	if previous != "" {
+		writer.Header().Add("Link", "<"+previous+`>; rel="prev"`)
+	}
+	if next != "" {
+		writer.Header().Add("Link", "<"+next+`>; rel="next"`)
+	}
+}
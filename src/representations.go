@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/manager"
+	"encoding/hex"
+	"encoding/json"
+	"export"
+	"fmt"
+	"mime"
+	"net/http"
+	"note"
+	"router"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notes and the index are served as FORMAT_HTML unless a suffix like
+// "/Note/1.json" or the Accept header asks for another format.
+const FORMAT_HTML = "html"
+const FORMAT_JSON = "json"
+const FORMAT_TEXT = "txt"
+const FORMAT_MARKDOWN = "md"
+
+const FORMAT_PATTERN = "{format:html|json|txt|md}"
+
+// The media types of the formats, in the order a format is picked when the
+// Accept header likes several of them the same.
+var FORMAT_MEDIA_TYPES = []struct {
+	format      string
+	mediaType   string
+	contentType string
+}{
+	{FORMAT_HTML, "text/html", "text/html; charset=utf-8"},
+	{FORMAT_JSON, "application/json", "application/json; charset=utf-8"},
+	{FORMAT_MARKDOWN, "text/markdown", "text/markdown; charset=utf-8"},
+	{FORMAT_TEXT, "text/plain", "text/plain; charset=utf-8"},
+}
+
+func contentType(format string) string {
+	for _, f := range FORMAT_MEDIA_TYPES {
+		if f.format == format {
+			return f.contentType
+		}
+	}
+	return ""
+}
+
+// acceptQuality returns how much accept likes mediaType, from 0 to 1.
+func acceptQuality(accept string, mediaType string) float64 {
+	var quality float64 = 0
+	var specificity int = -1
+
+	for _, item := range strings.Split(accept, ",") {
+		accepted, parameters, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+
+		var matches int
+		switch {
+		case accepted == mediaType:
+			matches = 2
+		case strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*")):
+			matches = 1
+		case accepted == "*/*":
+			matches = 0
+		default:
+			continue
+		}
+
+		// The most specific range that matches decides.
+		if matches > specificity {
+			specificity = matches
+			quality = 1
+			if q, found := parameters["q"]; found {
+				quality, err = strconv.ParseFloat(q, 64)
+				if err != nil {
+					quality = 0
+				}
+			}
+		}
+	}
+
+	return quality
+}
+
+// negotiate picks the format of a response: the suffix of the path, else the
+// format the Accept header likes best, else FORMAT_HTML.
+func negotiate(request *http.Request) string {
+	if format := router.Param(request, "format"); format != "" {
+		return format
+	}
+
+	var accept string = request.Header.Get("Accept")
+	if accept == "" {
+		return FORMAT_HTML
+	}
+
+	var best string = FORMAT_HTML
+	var bestQuality float64 = 0
+	for _, f := range FORMAT_MEDIA_TYPES {
+		if quality := acceptQuality(accept, f.mediaType); quality > bestQuality {
+			best = f.format
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+// serveRepresentation answers with content, or with 304 Not Modified if the
+// client has it already. The ETag differs by format, so caches keep each
+// one apart, as does the Vary header.
+func serveRepresentation(writer http.ResponseWriter, request *http.Request, format string, etag string, modified time.Time, content []byte) {
+	writer.Header().Set("Content-Type", contentType(format))
+	writer.Header().Set("ETag", etag)
+	writer.Header().Add("Vary", "Accept")
+
+	http.ServeContent(writer, request, "", modified, bytes.NewReader(content))
+}
+
+func representationETag(format string, parts ...interface{}) string {
+	var sum [sha256.Size]byte = sha256.Sum256([]byte(fmt.Sprint(parts...)))
+	return `"` + format + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// noteRepresentation renders a note in format; the HTML page is rendered by
+// the caller.
+func noteRepresentation(format string, n note.Note) ([]byte, error) {
+	var content bytes.Buffer
+	var err error
+
+	switch format {
+	case FORMAT_JSON:
+		err = json.NewEncoder(&content).Encode(noteToApiNote(n))
+	case FORMAT_TEXT:
+		_, err = content.WriteString(n.Text())
+	case FORMAT_MARKDOWN:
+		err = export.WriteMarkdown(&content, n)
+	}
+
+	return content.Bytes(), err
+}
+
+// indexRepresentation renders a page of the index in format: in JSON with
+// the links to the pages around it, as text one "id<tab>title" line per note
+// and in Markdown as a list of links.
+func indexRepresentation(format string, page manager.NotePage, previous string, next string) ([]byte, error) {
+	var content bytes.Buffer
+	var err error
+
+	switch format {
+	case FORMAT_JSON:
+		var apiPage apiNotePage = apiNotePage{Notes: []apiNoteSummary{}, Previous: previous, Next: next}
+		for _, s := range page.Notes {
+			apiPage.Notes = append(apiPage.Notes, summaryToApiNoteSummary(s))
+		}
+		err = json.NewEncoder(&content).Encode(apiPage)
+	case FORMAT_TEXT:
+		for _, s := range page.Notes {
+			fmt.Fprintf(&content, "%d\t%s\n", s.NoteID, s.Title)
+		}
+	case FORMAT_MARKDOWN:
+		for _, s := range page.Notes {
+			fmt.Fprintf(&content, "- [%s](%s)\n", strings.NewReplacer("[", `\[`, "]", `\]`).Replace(s.Title), routes.URL("noteAs", s.NoteID, FORMAT_MARKDOWN))
+		}
+	}
+
+	return content.Bytes(), err
+}
+
+// indexVersion identifies the state of a page of the index for its ETag.
+// The index has no Last-Modified: deleting a note or adding one with an old
+// date changes the page without making its latest change date any newer.
+func indexVersion(summaries []manager.NoteSummary) string {
+	var version strings.Builder
+
+	for _, s := range summaries {
+		fmt.Fprintf(&version, "%d.%d.%d,", s.NoteID, s.Version, s.ChangeDate.UnixNano())
+	}
+
+	return version.String()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"backup"
+	"config"
+	"context"
+	"database/manager"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"router"
+	"time"
+)
+
+const RESTORED_DB_FILE_NAME = "sndb-restored.db"
+const PREVIEW_ADDRESS = ":8081"
+
+var RESTORE_DATE_FORMATS = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// Pages that only lead to changes are closed in a read-only preview, along
+// with every request that is not a read.
+var READ_ONLY_CLOSED_ROUTES = []string{"addNote", "editNote", "deleteNote", "pasteBinNote"}
+
+var readOnly bool = false
+
+func readOnlyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var closed bool = request.Method != "GET" && request.Method != "HEAD" && request.Method != "OPTIONS" && request.Method != "PROPFIND"
+
+		if route := router.RouteOf(request); route != nil {
+			for _, name := range READ_ONLY_CLOSED_ROUTES {
+				if route.Name() == name {
+					closed = true
+				}
+			}
+		}
+
+		if closed {
+			http.Error(writer, "This is a read-only preview, nothing can be changed.", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func parseRestoreDate(value string) (time.Time, error) {
+	for _, format := range RESTORE_DATE_FORMATS {
+		date, err := time.ParseInLocation(format, value, time.Local)
+		if err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Cannot read the date %q, use e.g. \"2016-01-02 15:04\".", value)
+}
+
+func restoreCommand(arguments []string, settings config.Config) int {
+	var flags *flag.FlagSet = flag.NewFlagSet("restore", flag.ExitOnError)
+	var backupPath *string = flags.String("backup", "", "backup to start from, e.g. backups/sndb-20160102-120000.db.gz")
+	var at *string = flags.String("at", "", "restore the notes as they were at this time, e.g. \"2016-01-02 15:04\"")
+	var logPath *string = flags.String("log", settings.DatabaseFile, "database whose revisions are replayed on top of the backup")
+	var output *string = flags.String("o", RESTORED_DB_FILE_NAME, "file to write the restored database to")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: shareNotes restore -backup <file> -at <time> [-log "+settings.DatabaseFile+"] [-o "+RESTORED_DB_FILE_NAME+"]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+
+	if *backupPath == "" || *at == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	date, err := parseRestoreDate(*at)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	err = backup.Unpack(*backupPath, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	report, err := manager.ReplayRevisions(context.Background(), *output, *logPath, date)
+	if err != nil {
+		os.Remove(*output)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Restored %d notes as of %s into %s.\n", report.Notes, date.Format("2006-01-02 15:04:05"), *output)
+	fmt.Printf("The backup had changes up to %s, %d later change(s) were replayed from %s.\n", report.BackupDate.Format("2006-01-02 15:04:05"), report.Replayed, *logPath)
+	fmt.Printf("Look at it with \"shareNotes preview -db %s\" and swap it in with \"shareNotes swap -db %s\".\n", *output, *output)
+
+	return 0
+}
+
+func previewCommand(arguments []string, settings config.Config) int {
+	var flags *flag.FlagSet = flag.NewFlagSet("preview", flag.ExitOnError)
+	var path *string = flags.String("db", RESTORED_DB_FILE_NAME, "database to serve")
+	var address *string = flags.String("addr", PREVIEW_ADDRESS, "address to listen on")
+	flags.Parse(arguments)
+
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var preview manager.DatabaseManager = manager.NewFile(*path, true)
+	preview.UseKeys(settings.KeyFile, ENCRYPTION_KEY_VARIABLE)
+	dbManager = &preview
+	readOnly = true
+
+	var previewSettings config.Config = settings
+	previewSettings.Address = *address
+
+	fmt.Printf("Serving %s read-only on %s.\n", *path, *address)
+	runServer(previewSettings)
+
+	return 0
+}
+
+// renameDatabase moves a database together with the files SQLite keeps next
+// to it in WAL mode, which a server that did not shut down cleanly leaves
+// behind. A database must never meet the WAL file of another one.
+func renameDatabase(from string, to string) error {
+	err := os.Rename(from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, suffix := range []string{"-wal", "-shm"} {
+		err = os.Rename(from+suffix, to+suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// swapCommand puts a restored database in place of sndb.db. The server has
+// to be stopped, the database it had is kept under another name.
+func swapCommand(arguments []string, settings config.Config) int {
+	var flags *flag.FlagSet = flag.NewFlagSet("swap", flag.ExitOnError)
+	var path *string = flags.String("db", RESTORED_DB_FILE_NAME, "restored database to swap in")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: shareNotes swap [-db "+RESTORED_DB_FILE_NAME+"]  (stop the server first)")
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// The replaced database stays next to the database, rename works within
+	// one file system only.
+	var replaced string = filepath.Join(filepath.Dir(settings.DatabaseFile), "sndb-replaced-"+time.Now().Format("20060102-150405")+".db")
+
+	err := renameDatabase(settings.DatabaseFile, replaced)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = renameDatabase(*path, settings.DatabaseFile)
+	if err != nil {
+		renameDatabase(replaced, settings.DatabaseFile)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%s is now %s, the previous database was moved to %s.\n", *path, settings.DatabaseFile, replaced)
+
+	return 0
+}
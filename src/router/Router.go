@@ -0,0 +1,386 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A parameter without a regexp matches one path segment.
+const DEFAULT_PARAMETER_PATTERN = `[^/]+`
+
+type Middleware func(http.Handler) http.Handler
+
+// A Route matches a pattern like "/Note/{id:[0-9]+}" or
+// "/Note/{id:[0-9]+}.{format:json|txt}". A pattern ending in "*" matches
+// every path that starts with what comes before.
+type Route struct {
+	name       string
+	pattern    string
+	methods    map[string]bool
+	segments   []segment
+	wildcard   bool
+	handler    http.Handler
+	middleware []Middleware
+}
+
+// A segment is one part of the path between slashes. Segments with
+// parameters are matched by a regexp with a group per parameter.
+type segment struct {
+	pieces []piece
+	valid  *regexp.Regexp
+}
+
+type piece struct {
+	literal   string
+	parameter string
+}
+
+func (s segment) isLiteral() bool {
+	return len(s.pieces) == 1 && s.pieces[0].parameter == ""
+}
+
+func (s segment) literal() string {
+	if len(s.pieces) == 0 {
+		return ""
+	}
+	return s.pieces[0].literal
+}
+
+func (route *Route) Name() string {
+	return route.name
+}
+
+func (route *Route) Pattern() string {
+	return route.pattern
+}
+
+// Use adds middleware that runs for this route only, inside the middleware
+// of the router.
+func (route *Route) Use(middleware ...Middleware) *Route {
+	route.middleware = append(route.middleware, middleware...)
+	return route
+}
+
+func (route *Route) allows(method string) bool {
+	return len(route.methods) == 0 || route.methods[method] || (method == "HEAD" && route.methods["GET"])
+}
+
+// parseSegment splits part into literals and "{name:regexp}" parameters;
+// braces in the regexp have to be balanced.
+func parseSegment(part string) segment {
+	var s segment
+	var expression string = "^"
+
+	for part != "" {
+		var start int = strings.Index(part, "{")
+		if start < 0 {
+			start = len(part)
+		}
+		if start > 0 {
+			s.pieces = append(s.pieces, piece{literal: part[:start]})
+			expression += regexp.QuoteMeta(part[:start])
+			part = part[start:]
+			continue
+		}
+
+		var depth int = 0
+		var end int = -1
+		for i, c := range part {
+			if c == '{' {
+				depth++
+			} else if c == '}' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			panic(fmt.Sprintf("router: unbalanced braces in %q", part))
+		}
+
+		var fields []string = strings.SplitN(part[1:end], ":", 2)
+		var valid string = DEFAULT_PARAMETER_PATTERN
+		if len(fields) == 2 {
+			valid = fields[1]
+		}
+		s.pieces = append(s.pieces, piece{parameter: fields[0]})
+		expression += "(?P<" + fields[0] + ">" + valid + ")"
+		part = part[end+1:]
+	}
+
+	if !s.isLiteral() {
+		s.valid = regexp.MustCompile(expression + "$")
+	}
+	if len(s.pieces) == 0 {
+		s.pieces = []piece{{}}
+	}
+
+	return s
+}
+
+func parsePattern(pattern string) ([]segment, bool) {
+	var wildcard bool = strings.HasSuffix(pattern, "*")
+	var segments []segment
+
+	for _, part := range strings.Split(strings.TrimSuffix(pattern, "*"), "/") {
+		segments = append(segments, parseSegment(part))
+	}
+
+	return segments, wildcard
+}
+
+// match returns the parameters of path, or false if the route does not
+// match it.
+func (route *Route) match(path string) (map[string]string, bool) {
+	var parts []string = strings.Split(path, "/")
+	var parameters map[string]string = make(map[string]string)
+
+	if len(parts) < len(route.segments) || (!route.wildcard && len(parts) != len(route.segments)) {
+		return nil, false
+	}
+
+	for i, s := range route.segments {
+		var part string = parts[i]
+		var last bool = i == len(route.segments)-1
+
+		switch {
+		case !s.isLiteral():
+			var values []string = s.valid.FindStringSubmatch(part)
+			if values == nil {
+				return nil, false
+			}
+			for j, name := range s.valid.SubexpNames() {
+				if name != "" {
+					parameters[name] = values[j]
+				}
+			}
+		case last && route.wildcard:
+			if !strings.HasPrefix(part, s.literal()) {
+				return nil, false
+			}
+		case part != s.literal():
+			return nil, false
+		}
+	}
+
+	if route.wildcard {
+		var prefix string = strings.TrimSuffix(route.pattern, "*")
+		parameters["*"] = strings.TrimPrefix(path, prefix)
+	}
+
+	return parameters, true
+}
+
+// A Router sends requests to the first route that matches their path and
+// method. It can be mounted below a base path, e.g. behind a reverse proxy
+// that forwards "/notes/": the base path is stripped before matching and
+// added by URL.
+type Router struct {
+	basePath   string
+	routes     []*Route
+	names      map[string]*Route
+	middleware []Middleware
+
+	NotFound http.Handler
+}
+
+func New(basePath string) *Router {
+	return &Router{basePath: strings.TrimSuffix(basePath, "/"), names: make(map[string]*Route), NotFound: http.NotFoundHandler()}
+}
+
+func (r *Router) BasePath() string {
+	return r.basePath
+}
+
+// Use adds middleware that runs for every request, also for those answered
+// with 404 or 405.
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Handle adds a route for the methods, or any method if there are none. A
+// route without a name cannot be used with URL.
+func (r *Router) Handle(name string, pattern string, handler http.Handler, methods ...string) *Route {
+	segments, wildcard := parsePattern(pattern)
+
+	var route *Route = &Route{name: name, pattern: pattern, methods: make(map[string]bool), segments: segments, wildcard: wildcard, handler: handler}
+	for _, method := range methods {
+		route.methods[method] = true
+	}
+
+	if name != "" {
+		if _, taken := r.names[name]; taken {
+			panic(fmt.Sprintf("router: route %q is added twice", name))
+		}
+		r.names[name] = route
+	}
+	r.routes = append(r.routes, route)
+
+	return route
+}
+
+func (r *Router) HandleFunc(name string, pattern string, handler func(http.ResponseWriter, *http.Request), methods ...string) *Route {
+	return r.Handle(name, pattern, http.HandlerFunc(handler), methods...)
+}
+
+type matchKey struct{}
+
+type match struct {
+	route      *Route
+	parameters map[string]string
+}
+
+// Param returns a parameter of the route a request matched; "*" is the rest
+// of the path below a wildcard.
+func Param(request *http.Request, name string) string {
+	if m, found := request.Context().Value(matchKey{}).(match); found {
+		return m.parameters[name]
+	}
+	return ""
+}
+
+// RouteOf returns the route a request matched, or nil.
+func RouteOf(request *http.Request) *Route {
+	if m, found := request.Context().Value(matchKey{}).(match); found {
+		return m.route
+	}
+	return nil
+}
+
+func allowHeader(methods map[string]bool) string {
+	if methods["GET"] {
+		methods["HEAD"] = true
+	}
+	methods["OPTIONS"] = true
+
+	var allowed []string
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	return strings.Join(allowed, ", ")
+}
+
+func (r *Router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var path string = request.URL.Path
+
+	if r.basePath != "" {
+		if path == r.basePath {
+			http.Redirect(writer, request, r.basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(path, r.basePath+"/") {
+			r.NotFound.ServeHTTP(writer, request)
+			return
+		}
+
+		var stripped *http.Request = request.Clone(request.Context())
+		stripped.URL.Path = strings.TrimPrefix(path, r.basePath)
+		stripped.URL.RawPath = ""
+		request = stripped
+		path = request.URL.Path
+	}
+
+	var handler http.Handler = r.NotFound
+	var allowed map[string]bool
+
+	for _, route := range r.routes {
+		parameters, matches := route.match(path)
+		if !matches {
+			continue
+		}
+
+		if route.allows(request.Method) {
+			request = request.WithContext(context.WithValue(request.Context(), matchKey{}, match{route: route, parameters: parameters}))
+
+			handler = route.handler
+			for i := len(route.middleware) - 1; i >= 0; i-- {
+				handler = route.middleware[i](handler)
+			}
+			allowed = nil
+			break
+		}
+
+		if allowed == nil {
+			allowed = make(map[string]bool)
+		}
+		for method := range route.methods {
+			allowed[method] = true
+		}
+	}
+
+	if allowed != nil {
+		var allow string = allowHeader(allowed)
+		handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Allow", allow)
+			if request.Method == "OPTIONS" {
+				writer.WriteHeader(http.StatusNoContent)
+				return
+			}
+			http.Error(writer, "Method not allowed.", http.StatusMethodNotAllowed)
+		})
+	}
+
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+
+	handler.ServeHTTP(writer, request)
+}
+
+// URL returns the path of a named route, base path included, with the
+// parameters filled in in order. The parameter of a wildcard may hold
+// slashes. It panics on an unknown route or a wrong number of parameters,
+// like regexp.MustCompile on a bad pattern; templates get that as an error.
+func (r *Router) URL(name string, parameters ...interface{}) string {
+	route, found := r.names[name]
+	if !found {
+		panic(fmt.Sprintf("router: no route %q", name))
+	}
+
+	var parts []string
+	var next int = 0
+
+	for _, s := range route.segments {
+		var part string
+		for _, p := range s.pieces {
+			if p.parameter == "" {
+				part += p.literal
+				continue
+			}
+			if next >= len(parameters) {
+				panic(fmt.Sprintf("router: route %q needs more than %d parameters", name, len(parameters)))
+			}
+			part += url.PathEscape(fmt.Sprint(parameters[next]))
+			next++
+		}
+		parts = append(parts, part)
+	}
+
+	if route.wildcard {
+		if next >= len(parameters) {
+			panic(fmt.Sprintf("router: route %q needs more than %d parameters", name, len(parameters)))
+		}
+
+		var rest []string = strings.Split(fmt.Sprint(parameters[next]), "/")
+		for i := range rest {
+			rest[i] = url.PathEscape(rest[i])
+		}
+		parts[len(parts)-1] += strings.Join(rest, "/")
+		next++
+	}
+
+	if next != len(parameters) {
+		panic(fmt.Sprintf("router: route %q takes %d parameters, not %d", name, next, len(parameters)))
+	}
+
+	return r.basePath + strings.Join(parts, "/")
+}
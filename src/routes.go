@@ -0,0 +1,137 @@
+package main
+
+import (
+	"assets"
+	"config"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"router"
+	"strconv"
+)
+
+const NOTE_ID_PATTERN = "/{id:[0-9]+}"
+const FILTER_PATTERN = "/{term:[0-9a-zA-Z ]+}"
+
+// routes serves every page; its URL method gives the path of a page, base
+// path included, for redirects and, as "url", for the templates.
+var routes *router.Router
+
+func (s *server) rateLimited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if s.tooManyRequests() {
+			http.Error(writer, "Slow down, buddy!", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// adminOnly guards the /Admin pages, which hand out every note. With an
+// admin password they ask for it, without one they only answer requests made
+// on this machine; requests a proxy forwards do not count as such.
+func (s *server) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if s.settings.AdminPassword == "" {
+			var ip net.IP = net.ParseIP(clientIP(request))
+			var forwarded bool = request.Header.Get("X-Forwarded-For") != "" || request.Header.Get("Forwarded") != ""
+
+			if ip == nil || !ip.IsLoopback() || forwarded {
+				http.Error(writer, "The admin pages only answer requests from this machine unless admin-password is set.", http.StatusForbidden)
+				return
+			}
+		} else {
+			user, password, ok := request.BasicAuth()
+			var userMatches int = subtle.ConstantTimeCompare([]byte(user), []byte(config.ADMIN_USER))
+			var passwordMatches int = subtle.ConstantTimeCompare([]byte(password), []byte(s.settings.AdminPassword))
+
+			if !ok || userMatches&passwordMatches != 1 {
+				writer.Header().Set("WWW-Authenticate", `Basic realm="ShareNotes admin", charset="UTF-8"`)
+				http.Error(writer, "Unauthorized.", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func makeNoteIDHandler(function func(http.ResponseWriter, *http.Request, int)) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		id, err := strconv.Atoi(router.Param(request, "id"))
+		if err != nil {
+			http.NotFound(writer, request)
+			return
+		}
+		function(writer, request, id)
+	}
+}
+
+func makeFilterHandler(function func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		function(writer, request, router.Param(request, "term"))
+	}
+}
+
+// makePreparePostHandler shows the page urlName.html that leads to a change
+// of a note.
+func makePreparePostHandler(urlName string, function func(http.ResponseWriter, *http.Request, string, int)) http.HandlerFunc {
+	return makeNoteIDHandler(func(writer http.ResponseWriter, request *http.Request, noteID int) {
+		function(writer, request, urlName, noteID)
+	})
+}
+
+// setUpRoutes names every page. The pages of the notes are rate limited;
+// the static files, WebDAV, the monitoring endpoints and the event stream are
+// not, a browser does not open the stream again after a 429.
+func (s *server) setUpRoutes(theme *assets.Theme) *router.Router {
+	var r *router.Router = router.New(s.settings.BasePath)
+
+	r.Use(instrumentRequests)
+	if readOnly {
+		r.Use(readOnlyHandler)
+	}
+
+	r.HandleFunc("index", "/", s.indexHandler, "GET").Use(s.rateLimited)
+	r.HandleFunc("indexAs", "/index."+FORMAT_PATTERN, s.indexHandler, "GET").Use(s.rateLimited)
+	r.HandleFunc("addNote", "/AddNote/", addNoteHandler, "GET").Use(s.rateLimited)
+	r.HandleFunc("newNote", "/NewNote/", newNoteHandler, "POST").Use(s.rateLimited)
+	r.HandleFunc("events", "/Events/", eventsHandler, "GET")
+	r.HandleFunc("changes", "/api/v1/changes", s.changesHandler, "GET", "POST").Use(s.rateLimited)
+	r.Handle("apiNote", "/api/v1/notes"+NOTE_ID_PATTERN, makeNoteIDHandler(s.apiNoteHandler), "GET", "PUT", "DELETE").Use(s.rateLimited)
+
+	// Editing an encrypted note is asked for with its passphrase.
+	r.Handle("editNote", "/EditNote"+NOTE_ID_PATTERN, makePreparePostHandler("EditNote", s.preparePostHandler), "GET", "POST").Use(s.rateLimited)
+	r.Handle("deleteNote", "/DeleteNote"+NOTE_ID_PATTERN, makePreparePostHandler("DeleteNote", s.preparePostHandler), "GET").Use(s.rateLimited)
+	r.Handle("pasteBinNote", "/PasteBinNote"+NOTE_ID_PATTERN, makePreparePostHandler("PasteBinNote", s.preparePostHandler), "GET").Use(s.rateLimited)
+
+	r.Handle("note", "/Note"+NOTE_ID_PATTERN, makeNoteIDHandler(s.noteDetailsHandler), "GET").Use(s.rateLimited)
+	r.Handle("noteAs", "/Note"+NOTE_ID_PATTERN+"."+FORMAT_PATTERN, makeNoteIDHandler(s.noteDetailsHandler), "GET").Use(s.rateLimited)
+	r.Handle("saveNote", "/SaveNote"+NOTE_ID_PATTERN, makeNoteIDHandler(s.saveNoteHandler), "POST").Use(s.rateLimited)
+	r.Handle("confirmDeleteNote", "/ConfirmDeleteNote"+NOTE_ID_PATTERN, makeNoteIDHandler(s.deleteNoteHandler), "POST").Use(s.rateLimited)
+	r.Handle("confirmPasteBinNote", "/ConfirmPasteBinNote"+NOTE_ID_PATTERN, makeNoteIDHandler(s.pasteBinNoteHandler), "POST").Use(s.rateLimited)
+	r.Handle("renewLease", "/RenewLease"+NOTE_ID_PATTERN, makeNoteIDHandler(s.renewLeaseHandler), "POST").Use(s.rateLimited)
+	r.Handle("releaseLease", "/ReleaseLease"+NOTE_ID_PATTERN, makeNoteIDHandler(s.releaseLeaseHandler), "POST").Use(s.rateLimited)
+	r.Handle("breakLease", "/BreakLease"+NOTE_ID_PATTERN, makeNoteIDHandler(breakLeaseHandler), "POST").Use(s.rateLimited)
+	r.Handle("decryptNote", "/DecryptNote"+NOTE_ID_PATTERN, makeNoteIDHandler(decryptNoteHandler), "GET", "POST").Use(s.rateLimited)
+
+	r.Handle("titleFilter", "/TitleFilter"+FILTER_PATTERN, makeFilterHandler(titleFilterHandler), "GET").Use(s.rateLimited)
+	r.Handle("textFilter", "/TextFilter"+FILTER_PATTERN, makeFilterHandler(textFilterHandler), "GET").Use(s.rateLimited)
+	r.Handle("bothFilter", "/BothFilter"+FILTER_PATTERN, makeFilterHandler(bothFilterHandler), "GET").Use(s.rateLimited)
+
+	r.HandleFunc("export", "/Admin/Export/{format:json|zip|html}", exportHandler, "GET").Use(s.rateLimited, s.adminOnly)
+	r.HandleFunc("backups", "/Admin/Backups/", backupsHandler, "GET", "POST").Use(s.rateLimited, s.adminOnly)
+	r.HandleFunc("backup", "/Admin/Backups/{name}", downloadBackupHandler, "GET").Use(s.rateLimited, s.adminOnly)
+
+	r.Handle("static", assets.STATIC_PREFIX+"*", theme, "GET")
+
+	r.HandleFunc("dav", DAV_PREFIX, s.webdavHandler)
+	r.HandleFunc("davFile", DAV_PREFIX+"/*", s.webdavHandler)
+
+	r.HandleFunc("health", HEALTH_PATH, healthHandler, "GET")
+	r.HandleFunc("ready", READY_PATH, readyHandler, "GET")
+	r.Handle("metrics", METRICS_PATH, registry, "GET")
+
+	return r
+}
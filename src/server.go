@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"systemd"
+	"time"
+)
+
+// On SIGINT or SIGTERM requests that are running get SHUTDOWN_TIMEOUT to
+// finish. Background jobs are waited for until they stop, a warning is logged
+// if that takes longer.
+const SHUTDOWN_TIMEOUT = 20 * time.Second
+
+// stopping is closed when the server shuts down. Background jobs and event
+// streams end on it.
+var stopping chan struct{} = make(chan struct{})
+
+var backgroundJobs sync.WaitGroup
+
+// startBackgroundJob runs job until stopping is closed; the server waits for
+// it before it closes the database.
+func startBackgroundJob(job func(stop <-chan struct{})) {
+	backgroundJobs.Add(1)
+
+	go func() {
+		defer backgroundJobs.Done()
+		job(stopping)
+	}()
+}
+
+// listen takes the socket systemd passed at index, if there is one, and
+// listens on address otherwise.
+func listen(activated []net.Listener, index int, address string) (net.Listener, error) {
+	if index < len(activated) {
+		slog.Info("Using a socket from systemd.", "address", activated[index].Addr().String())
+		return activated[index], nil
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// serve answers requests until SIGINT or SIGTERM and then shuts down: no
+// new connections are accepted, running requests are finished and the
+// background jobs are stopped.
+func (s *server) serve(handler http.Handler) error {
+	var signals chan os.Signal = make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	activated, err := systemd.Listeners()
+	if err != nil {
+		return err
+	}
+
+	listener, err := listen(activated, 0, s.settings.Address)
+	if err != nil {
+		return err
+	}
+
+	var server *http.Server = &http.Server{Handler: handler}
+	var servers []*http.Server = []*http.Server{server}
+	var failed chan error = make(chan error, 2)
+
+	if s.settings.TLS {
+		server.Handler = hstsHandler(handler)
+		server.TLSConfig, err = s.tlsConfig()
+		if err != nil {
+			listener.Close()
+			return err
+		}
+
+		go func() {
+			failed <- server.ServeTLS(listener, "", "")
+		}()
+
+		if s.settings.RedirectAddress != "" {
+			redirectListener, err := listen(activated, 1, s.settings.RedirectAddress)
+			if err != nil {
+				server.Close()
+				return err
+			}
+
+			var redirect *http.Server = &http.Server{Handler: http.HandlerFunc(s.redirectHandler)}
+			servers = append(servers, redirect)
+
+			go func() {
+				failed <- redirect.Serve(redirectListener)
+			}()
+		}
+	} else {
+		go func() {
+			failed <- server.Serve(listener)
+		}()
+	}
+
+	systemd.Notify("READY=1")
+	slog.Info("ShareNotes initialized.", "address", listener.Addr().String())
+
+	select {
+	case received := <-signals:
+		slog.Info("Shutting down...", "signal", received.String())
+	case err = <-failed:
+		slog.Error("Serving failed, shutting down.", "error", err)
+	}
+
+	systemd.Notify("STOPPING=1")
+	close(stopping)
+
+	shutdown, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	for _, s := range servers {
+		shutdownErr := s.Shutdown(shutdown)
+		if shutdownErr != nil {
+			slog.Warn("Running requests did not finish in time, closing their connections.", "error", shutdownErr)
+			s.Close()
+		}
+	}
+
+	var jobsDone chan struct{} = make(chan struct{})
+	go func() {
+		backgroundJobs.Wait()
+		close(jobsDone)
+	}()
+
+	// A job stops after the step it is taking, e.g. a backup, however long that
+	// takes; the database is closed after this.
+	select {
+	case <-jobsDone:
+	case <-shutdown.Done():
+		slog.Warn("Background jobs did not stop in time, waiting for them to finish.")
+		<-jobsDone
+	}
+
+	return err
+}
@@ -1,45 +1,60 @@
 package main
 
 import (
+	"assets"
 	"bytes"
+	"config"
+	"context"
 	"database/manager"
+	"database/sql"
+	"encoding/json"
+	"encryption"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/mvdan/xurls"
 	"html/template"
-	"log"
+	"log/slog"
+	"merge"
 	"net/http"
 	"note"
+	"os"
 	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
         "math/rand"
         "math"
 )
 
-const TOO_MANY_REQUEST_TIME_SPAN_IN_NS = int64(1000)
-
 type synchronizedToken struct {
         ID          uint64
         TokenString string
 }
 
+// A sessionIDManager is used by every request, its mutex guards the tokens.
 type sessionIDManager struct {
+        mutex         sync.Mutex
         currentID     uint64
         sessionTokens map[uint64]string
 }
 
-var sidManager sessionIDManager = sessionIDManager{ currentID: 0, sessionTokens: make(map[uint64]string) }
+var sidManager *sessionIDManager = &sessionIDManager{ currentID: 0, sessionTokens: make(map[uint64]string) }
 
 const CHARACTERS = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 const CHARACTER_INDEX_BITS = 6                    
 const CHARACTER_INDEX_MASK = 1 << CHARACTER_INDEX_BITS - 1 
 const CHARACTER_INDEX_MAX  = 63 / CHARACTER_INDEX_BITS   
 
+// A rand.Source cannot be used by several goroutines at once.
 var src = rand.NewSource(time.Now().UnixNano())
+var srcMutex sync.Mutex
 
 func randomStringLength(n int) string {
+    srcMutex.Lock()
+    defer srcMutex.Unlock()
+
     var result []byte = make([]byte, n)
     var cache int64 = src.Int63()
     var remain int = CHARACTER_INDEX_MAX
@@ -61,6 +76,9 @@ func randomStringLength(n int) string {
 }
 
 func (sidm *sessionIDManager) generateSynchronizedToken() synchronizedToken {
+        sidm.mutex.Lock()
+        defer sidm.mutex.Unlock()
+
         var sessionToken synchronizedToken = synchronizedToken{ ID: sidm.currentID, TokenString: randomStringLength(100) }
         
         sidm.sessionTokens[sessionToken.ID] = sessionToken.TokenString
@@ -70,12 +88,26 @@ func (sidm *sessionIDManager) generateSynchronizedToken() synchronizedToken {
 }
 
 func (sidm *sessionIDManager) synchronizedTokenIsValid(token synchronizedToken) bool {
+        sidm.mutex.Lock()
+        defer sidm.mutex.Unlock()
+
         return sidm.sessionTokens[token.ID] == token.TokenString;
 }
 
+func (sidm *sessionIDManager) tokenCount() int {
+        sidm.mutex.Lock()
+        defer sidm.mutex.Unlock()
+
+        return len(sidm.sessionTokens)
+}
+
 type htmlTable struct {
-	Notes    []htmlNote
-	Filtered bool
+	Notes     []htmlNote
+	Filtered  bool
+	Previous  string
+	Next      string
+	PageSize  int
+	PageSizes []int
 }
 
 type htmlNote struct {
@@ -84,6 +116,13 @@ type htmlNote struct {
 	Text       template.HTML
 	AddDate    time.Time
 	ChangeDate time.Time
+	Tags       []string
+	Truncated  bool
+	EditedBy   *manager.Lease
+	Encrypted  bool
+	Decrypted  bool
+	Passphrase string
+	Error      string
 }
 
 func partialHtmlParser(text string) template.HTML {
@@ -137,41 +176,109 @@ func partialHtmlParser(text string) template.HTML {
 }
 
 func noteToHtmlNote(note note.Note) htmlNote {
+	// The ciphertext of an encrypted note is neither shown nor parsed.
+	if encryption.IsEncrypted(note.Text()) {
+		return htmlNote{
+			NoteID:     note.NoteID(),
+			Title:      note.Title(),
+			AddDate:    note.AddDate(),
+			ChangeDate: note.ChangeDate(),
+			Tags:       note.Tags(),
+			Encrypted:  true}
+	}
+
 	return htmlNote{
 		NoteID:     note.NoteID(),
 		Title:      note.Title(),
 		Text:       partialHtmlParser(note.Text()),
 		AddDate:    note.AddDate(),
-		ChangeDate: note.ChangeDate()}
+		ChangeDate: note.ChangeDate(),
+		Tags:       note.Tags()}
 }
 
-var dbManager = manager.New()
+// summaryToHtmlNote shows a note in the index with the start of its text.
+func summaryToHtmlNote(summary manager.NoteSummary) htmlNote {
+	if encryption.IsEncrypted(summary.Preview) {
+		return htmlNote{
+			NoteID:     summary.NoteID,
+			Title:      summary.Title,
+			AddDate:    summary.AddDate,
+			ChangeDate: summary.ChangeDate,
+			Tags:       summary.Tags,
+			Encrypted:  true}
+	}
+
+	return htmlNote{
+		NoteID:     summary.NoteID,
+		Title:      summary.Title,
+		Text:       partialHtmlParser(summary.Preview),
+		AddDate:    summary.AddDate,
+		ChangeDate: summary.ChangeDate,
+		Tags:       summary.Tags,
+		Truncated:  summary.Truncated}
+}
 
-var templates = template.Must(template.ParseFiles("index.html", "AddNote.html", "Note.html", "DeleteNote.html", "PasteBinNote.html", "EditNote.html"))
+// A server answers requests with the settings it was started with, read
+// once at startup from the defaults, the config file, the environment and
+// the flags.
+type server struct {
+	settings config.Config
 
-func indexHandler(writer http.ResponseWriter, request *http.Request) {
-	var err error
-	var notes []note.Note
-	notes, err = dbManager.LoadNotes()
+	lastRequestTime  int64
+	lastRequestMutex sync.Mutex
+}
 
+func newServer(settings config.Config) *server {
+	return &server{settings: settings, lastRequestTime: math.MinInt64}
+}
+
+var dbManager noteStore
+
+var templates *template.Template
+
+func (s *server) indexHandler(writer http.ResponseWriter, request *http.Request) {
+	query, err := s.pageQueryOf(request)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var htmlNotes []htmlNote
+	var page manager.NotePage
+	page, err = storeFor(request).ListNotes(request.Context(), query)
 
-	for _, n := range notes {
-		htmlNotes = append(htmlNotes, noteToHtmlNote(n))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	table := htmlTable{Notes: htmlNotes, Filtered: false}
+	var format string = negotiate(request)
+	previous, next := s.pageLinks(request, query, page)
+	var version string = indexVersion(page.Notes)
+	var content []byte
+
+	if format == FORMAT_HTML {
+		var htmlNotes []htmlNote
+
+		for _, s := range page.Notes {
+			htmlNotes = append(htmlNotes, summaryToHtmlNote(s))
+		}
+
+		table := htmlTable{Notes: htmlNotes, Filtered: false, Previous: previous, Next: next, PageSize: query.Limit, PageSizes: PAGE_SIZES}
+
+		var buffer bytes.Buffer
+		err = templates.ExecuteTemplate(&buffer, "index.html", table)
+		content = buffer.Bytes()
+	} else {
+		content, err = indexRepresentation(format, page, previous, next)
+	}
 
-	err = templates.ExecuteTemplate(writer, "index.html", table)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	setPageLinks(writer, previous, next)
+	serveRepresentation(writer, request, format, representationETag(format, version, previous, next), time.Time{}, content)
 }
 
 type addNoteData struct {
@@ -204,59 +311,156 @@ func newNoteHandler(writer http.ResponseWriter, request *http.Request) {
                 return
         }
 
-	err = dbManager.AddNote(note.New(title, text))
+	if passphrase := request.FormValue("passphrase"); passphrase != "" {
+		text, err = encryption.Encrypt(text, passphrase)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var newNote note.Note = note.New(title, text)
+	newNote.SetTags(note.SplitTags(request.FormValue("tags")))
+
+	err = storeFor(request).AddNote(request.Context(), newNote)
 
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(writer, request, "/", http.StatusFound)
+	http.Redirect(writer, request, routes.URL("index"), http.StatusFound)
 }
 
-func noteDetailsHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+func (s *server) noteDetailsHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
 	var err error
 	var foundNote note.Note
 
 	//fmt.Println("####\nGet Note "+strconv.Itoa(noteID)+"\n####")
 
-	foundNote, err = dbManager.GetNote(noteID)
+	foundNote, err = storeFor(request).GetNote(request.Context(), noteID)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = templates.ExecuteTemplate(writer, "Note.html", noteToHtmlNote(foundNote))
+	var format string = negotiate(request)
+	var etag string = representationETag(format, foundNote.NoteID(), foundNote.Version(), foundNote.ChangeDate().UnixNano())
+
+	if format != FORMAT_HTML {
+		content, err := noteRepresentation(format, foundNote)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serveRepresentation(writer, request, format, etag, foundNote.ChangeDate(), content)
+		return
+	}
+
+	var details htmlNote = noteToHtmlNote(foundNote)
+
+	if s.settings.EditLeases {
+		deviceID, _ := s.deviceOf(writer, request)
+
+		details.EditedBy, err = storeFor(request).GetLease(request.Context(), noteID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if details.EditedBy != nil && details.EditedBy.Holder == deviceID {
+			details.EditedBy = nil
+		}
+	}
+
+	// The page shows who is editing the note, which changes without a change
+	// of the note, so it is validated by its ETag alone.
+	var modified time.Time = foundNote.ChangeDate()
+	if s.settings.EditLeases {
+		modified = time.Time{}
+	}
+	if details.EditedBy != nil {
+		etag = representationETag(format, foundNote.NoteID(), foundNote.Version(), foundNote.ChangeDate().UnixNano(), details.EditedBy.Holder, details.EditedBy.ExpiryDate.UnixNano())
+	}
+
+	var page bytes.Buffer
+	err = templates.ExecuteTemplate(&page, "Note.html", details)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	serveRepresentation(writer, request, format, etag, modified, page.Bytes())
 }
 
 type confirmNoteData struct {
         Note note.Note
         Token synchronizedToken
+        Lease *manager.Lease
+        LeaseRenewMilliseconds int64
+        Passphrase string
 }
 
-func preparePostHandler(writer http.ResponseWriter, request *http.Request, urlName string, noteID int) {
+func (s *server) preparePostHandler(writer http.ResponseWriter, request *http.Request, urlName string, noteID int) {
 	var err error
 	var foundNote note.Note
 
-	foundNote, err = dbManager.GetNote(noteID)
+	foundNote, err = storeFor(request).GetNote(request.Context(), noteID)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = templates.ExecuteTemplate(writer, urlName + ".html", confirmNoteData{Note: foundNote, Token: sidManager.generateSynchronizedToken()})
+	var data confirmNoteData = confirmNoteData{Note: foundNote, Token: sidManager.generateSynchronizedToken()}
+
+	if urlName == "EditNote" && encryption.IsEncrypted(foundNote.Text()) {
+		// Encrypted notes are opened for editing from their decrypted page.
+		data.Passphrase = request.FormValue("passphrase")
+
+		text, err := decryptNote(request, noteID, foundNote.Text(), data.Passphrase)
+		if err == ErrTooManyAttempts {
+			http.Error(writer, err.Error(), decryptErrorStatus(writer, err))
+			return
+		} else if err != nil {
+			http.Redirect(writer, request, routes.URL("note", noteID), http.StatusFound)
+			return
+		}
+
+		// The form carries the passphrase and the decrypted text.
+		writer.Header().Set("Cache-Control", "no-store")
+
+		data.Note = note.NewLocal(noteID, foundNote.Title(), text, foundNote.AddDate(), foundNote.ChangeDate(), foundNote.Version(), foundNote.Tags())
+	}
+
+	if urlName == "EditNote" && s.settings.EditLeases {
+		deviceID, holderName := s.deviceOf(writer, request)
+
+		lease, err := storeFor(request).AcquireLease(request.Context(), noteID, deviceID, holderName, s.settings.EditLeaseDuration)
+		if err == manager.ErrLeaseHeld {
+			err = templates.ExecuteTemplate(writer, "NoteLocked.html", noteLockedData{Note: foundNote, Lease: lease, Token: data.Token})
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		} else if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data.Lease = &lease
+		data.LeaseRenewMilliseconds = int64(EDIT_LEASE_RENEW_INTERVAL / time.Millisecond)
+	}
+
+	err = templates.ExecuteTemplate(writer, urlName + ".html", data)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-func saveNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
-	foundNote, err := dbManager.GetNote(noteID)
+func (s *server) saveNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+	foundNote, err := storeFor(request).GetNote(request.Context(), noteID)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
@@ -279,104 +483,308 @@ func saveNoteHandler(writer http.ResponseWriter, request *http.Request, noteID i
                 return
         }
 
-	var dirtyBit bool = false
-	if foundNote.Title() != title {
-		dirtyBit = true
-		foundNote.SetTitle(title)
+	if s.refuseLeasedChange(writer, request, foundNote, text) {
+		return
+	}
+
+	version, err := strconv.Atoi(request.FormValue("version"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if foundNote.Text() != text {
-		dirtyBit = true
-		foundNote.SetText(text)
+	var tags []string = foundNote.Tags()
+	if _, found := request.Form["tags"]; found {
+		tags = note.SplitTags(request.FormValue("tags"))
 	}
 
-	if dirtyBit {
-		err = dbManager.UpdateNote(foundNote)
+	var encrypted bool = encryption.IsEncrypted(foundNote.Text())
+	var textChanged bool = foundNote.Text() != text
+	var passphrase string
+	var plainText string = text
+
+	if encrypted {
+		passphrase = request.FormValue("passphrase")
+
+		decrypted, err := decryptNote(request, noteID, foundNote.Text(), passphrase)
+		if err != nil {
+			http.Error(writer, err.Error(), decryptErrorStatus(writer, err))
+			return
+		}
+
+		textChanged = decrypted != text
+		if textChanged {
+			text, err = encryption.Encrypt(text, passphrase)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			text = foundNote.Text()
+		}
+	}
+
+	var editedNote note.Note = note.NewLocal(noteID, title, text, foundNote.AddDate(), time.Now(), version, tags)
+
+	if foundNote.Title() != title || textChanged || note.JoinTags(foundNote.Tags()) != note.JoinTags(tags) {
+		err = storeFor(request).UpdateNote(request.Context(), editedNote)
+		if err == manager.ErrVersionConflict {
+			// Ciphertexts cannot be merged line by line, the merge works on
+			// the plain texts.
+			s.mergeNoteHandler(writer, request, note.NewLocal(noteID, title, plainText, foundNote.AddDate(), time.Now(), version, tags), passphrase)
+			return
+		}
 		if err != nil {
 			http.Error(writer, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
-	http.Redirect(writer, request, fmt.Sprintf("/Note/%d", noteID), http.StatusFound)
+	s.releaseEditLease(writer, request, noteID)
+
+	http.Redirect(writer, request, routes.URL("note", noteID), http.StatusFound)
 }
 
-func deleteNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+const MAX_MERGE_ATTEMPTS = 3
+
+type conflictHunk struct {
+	Local      []string `json:"local"`
+	Remote     []string `json:"remote"`
+	Merged     []string `json:"merged"`
+	Conflict   bool     `json:"conflict"`
+	LocalText  string   `json:"-"`
+	RemoteText string   `json:"-"`
+	MergedText string   `json:"-"`
+}
+
+type conflictNoteData struct {
+	Local         note.Note
+	Remote        note.Note
+	Title         string
+	TitleConflict bool
+	Merged        string
+	Tags          []string
+	Hunks         []conflictHunk
+	Passphrase    string
+	Token         synchronizedToken
+}
+
+func toConflictHunks(result merge.Result) []conflictHunk {
+	var hunks []conflictHunk
+
+	for _, hunk := range result.Hunks {
+		hunks = append(hunks, conflictHunk{
+			Local:      hunk.Local,
+			Remote:     hunk.Remote,
+			Merged:     hunk.Merged,
+			Conflict:   hunk.Conflict,
+			LocalText:  strings.Join(hunk.Local, "\n"),
+			RemoteText: strings.Join(hunk.Remote, "\n"),
+			MergedText: strings.Join(hunk.Merged, "\n")})
+	}
+
+	return hunks
+}
+
+// mergeNoteHandler merges an edit that was based on an older version of the
+// note into the current version. Only overlapping changes are handed to the
+// user to resolve. The local note of an encrypted note holds the plain text,
+// the other versions are decrypted with the passphrase and the merged text is
+// encrypted with it again.
+func (s *server) mergeNoteHandler(writer http.ResponseWriter, request *http.Request, localNote note.Note, passphrase string) {
+	for attempt := 0; attempt < MAX_MERGE_ATTEMPTS; attempt++ {
+		remoteNote, err := storeFor(request).GetNote(request.Context(), localNote.NoteID())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		baseNote, err := storeFor(request).GetRevision(request.Context(), localNote.NoteID(), localNote.Version())
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		baseText, err := decryptForMerge(baseNote.Text(), passphrase)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+		remoteText, err := decryptForMerge(remoteNote.Text(), passphrase)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+
+		title, titleConflict := merge.MergeLine(baseNote.Title(), localNote.Title(), remoteNote.Title())
+		result := merge.Merge(baseText, localNote.Text(), remoteText)
+		tags := merge.MergeSet(baseNote.Tags(), localNote.Tags(), remoteNote.Tags())
+
+		if titleConflict || result.Conflicts > 0 {
+			writer.Header().Set("Cache-Control", "no-store")
+			writer.WriteHeader(http.StatusConflict)
+
+			err = templates.ExecuteTemplate(writer, "ConflictNote.html", conflictNoteData{
+				Local:         localNote,
+				Remote:        remoteNote,
+				Title:         title,
+				TitleConflict: titleConflict,
+				Merged:        result.Text(),
+				Tags:          tags,
+				Hunks:         toConflictHunks(result),
+				Passphrase:    passphrase,
+				Token:         sidManager.generateSynchronizedToken()})
+			if err != nil {
+				loggerFor(request).Error("Rendering the conflict page.", "error", err)
+			}
+			return
+		}
+
+		var mergedText string = result.Text()
+		if passphrase != "" {
+			mergedText, err = encryption.Encrypt(mergedText, passphrase)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		err = storeFor(request).UpdateNote(request.Context(), note.NewLocal(localNote.NoteID(), title, mergedText, remoteNote.AddDate(), time.Now(), remoteNote.Version(), tags))
+		if err == manager.ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.releaseEditLease(writer, request, localNote.NoteID())
+
+		http.Redirect(writer, request, routes.URL("note", localNote.NoteID()), http.StatusFound)
+		return
+	}
+
+	http.Error(writer, "The note keeps changing on another device, please try again.", http.StatusConflict)
+}
+
+// decryptForMerge opens a version of an encrypted note that is merged.
+// Versions that were saved without encryption are merged as they are.
+func decryptForMerge(text string, passphrase string) (string, error) {
+	if passphrase == "" || !encryption.IsEncrypted(text) {
+		return text, nil
+	}
+
+	plainText, err := encryption.Decrypt(text, passphrase)
+	if err == encryption.ErrWrongPassphrase {
+		return "", errors.New("The encrypted note was changed on another device with a different passphrase, please open it again.")
+	}
+	return plainText, err
+}
+
+func (s *server) deleteNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
 	tokenID := request.FormValue("share_note_token_id")
-        tokenString := request.FormValue("share_note_token_string")
-        
-        id, err := strconv.ParseUint(tokenID, 10, 64)
-        
-        if err != nil {
+	tokenString := request.FormValue("share_note_token_string")
+
+	id, err := strconv.ParseUint(tokenID, 10, 64)
+
+	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-        if !sidManager.synchronizedTokenIsValid(synchronizedToken{ID: id, TokenString: tokenString}) {
-                http.Error(writer, "Token was invlaid.", http.StatusInternalServerError)
-                return
-        }
-        
-        err = dbManager.DeleteNote(noteID)
+	if !sidManager.synchronizedTokenIsValid(synchronizedToken{ID: id, TokenString: tokenString}) {
+		http.Error(writer, "Token was invlaid.", http.StatusInternalServerError)
+		return
+	}
+
+	version, err := strconv.Atoi(request.FormValue("version"))
 	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if current, err := storeFor(request).GetNote(request.Context(), noteID); err == nil && s.refuseLeasedChange(writer, request, current, "") {
+		return
+	}
+
+	// A note that is gone already needs no deleting.
+	err = storeFor(request).DeleteNote(request.Context(), noteID, version)
+	if err == manager.ErrVersionConflict {
+		http.Error(writer, "The note was changed on another device, please look at it again before deleting it.", http.StatusConflict)
+		return
+	} else if err != nil && err != sql.ErrNoRows {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(writer, request, "/", http.StatusFound)
+	http.Redirect(writer, request, routes.URL("index"), http.StatusFound)
 }
 
-func pasteBinNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
+func (s *server) pasteBinNoteHandler(writer http.ResponseWriter, request *http.Request, noteID int) {
 	var err error
 	var foundNote note.Note
 
-        tokenID := request.FormValue("share_note_token_id")
-        tokenString := request.FormValue("share_note_token_string")
-        
-        id, err := strconv.ParseUint(tokenID, 10, 64)
-        
-        if err != nil {
+	tokenID := request.FormValue("share_note_token_id")
+	tokenString := request.FormValue("share_note_token_string")
+
+	id, err := strconv.ParseUint(tokenID, 10, 64)
+
+	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-        if !sidManager.synchronizedTokenIsValid(synchronizedToken{ID: id, TokenString: tokenString}) {
-                http.Error(writer, "Invalid post request..", http.StatusInternalServerError)
-                return
-        }
+	if !sidManager.synchronizedTokenIsValid(synchronizedToken{ID: id, TokenString: tokenString}) {
+		http.Error(writer, "Invalid post request..", http.StatusInternalServerError)
+		return
+	}
 
-	foundNote, err = dbManager.GetNote(noteID)
+	foundNote, err = storeFor(request).GetNote(request.Context(), noteID)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if encryption.IsEncrypted(foundNote.Text()) {
+		http.Error(writer, "Encrypted notes are not sent to dPaste.", http.StatusBadRequest)
+		return
+	}
+
 	shellCommand := exec.Command("curl",
 		"-s",
 		"-F", fmt.Sprintf("content=%s", foundNote.Text()),
 		"-F", fmt.Sprintf("title=\"%s (ID:%d)\"", foundNote.Title(), foundNote.NoteID()),
-		"http://dpaste.com/api/v2/")
+		s.settings.DPasteURL)
 
 	var output bytes.Buffer
 	shellCommand.Stdout = &output
 	err = shellCommand.Run()
 	if err != nil {
+		pasteBinFailuresTotal.Inc()
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-        log.Printf(output.String())
+	// dPaste answers with the URL of the paste, anything else is an error
+	// message.
+	if !strings.HasPrefix(output.String(), "http") {
+		pasteBinFailuresTotal.Inc()
+		http.Error(writer, "dPaste did not publish the note: "+output.String(), http.StatusBadGateway)
+		return
+	}
+
+	loggerFor(request).Info("Published a note to dPaste.", "note", noteID, "url", strings.TrimSpace(output.String()))
 
 	http.Redirect(writer, request, output.String(), http.StatusFound)
 }
 
-func filteredIndexHandler(writer http.ResponseWriter, request *http.Request, whereClause string, whereParameters ...string) {
+func filteredIndexHandler(writer http.ResponseWriter, request *http.Request, filter manager.NoteFilter) {
 	var err error
 	var notes []note.Note
-	notes, err = dbManager.LoadNotesWhere(whereClause, whereParameters...)
+	notes, err = storeFor(request).FilterNotes(request.Context(), filter)
 
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		http.Error(writer, err.Error(), storageErrorStatus(err))
 		return
 	}
 
@@ -396,138 +804,165 @@ func filteredIndexHandler(writer http.ResponseWriter, request *http.Request, whe
 }
 
 func titleFilterHandler(writer http.ResponseWriter, request *http.Request, filterInput string) {
-	filteredIndexHandler(writer, request, manager.SELECT_NOTES_WHERE_TITLE_QS, "%"+filterInput+"%")
+	filteredIndexHandler(writer, request, manager.NoteFilter{Field: manager.FILTER_TITLE, Pattern: filterInput})
 }
 
 func textFilterHandler(writer http.ResponseWriter, request *http.Request, filterInput string) {
-	filteredIndexHandler(writer, request, manager.SELECT_NOTES_WHERE_TEXT_QS, "%"+filterInput+"%")
+	filteredIndexHandler(writer, request, manager.NoteFilter{Field: manager.FILTER_TEXT, Pattern: filterInput})
 }
 
 func bothFilterHandler(writer http.ResponseWriter, request *http.Request, filterInput string) {
-	filteredIndexHandler(writer, request, manager.SELECT_NOTES_WHERE_BOTH_QS, "%"+filterInput+"%", "%"+filterInput+"%")
+	filteredIndexHandler(writer, request, manager.NoteFilter{Field: manager.FILTER_BOTH, Pattern: filterInput})
 }
 
-var validNotePath = regexp.MustCompile("^/(Note|ConfirmDeleteNote|SaveNote|ConfirmPasteBinNote)/([0-9]+)$")
+const EVENT_STREAM_KEEP_ALIVE = 30 * time.Second
 
-func makeNoteIDHandler(function func(http.ResponseWriter, *http.Request, int)) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-                if tooManyRequests() {
-                        http.Error(writer, "429 TooManyRequests: Slow down, buddy!", 429)
-                        return
-                }
-                
-		urlTokens := validNotePath.FindStringSubmatch(request.URL.Path)
-		if urlTokens == nil {
-			http.NotFound(writer, request)
-			return
-		}
-		id, _ := strconv.Atoi(urlTokens[2])
-		function(writer, request, id)
+func eventsHandler(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "Streaming is not supported.", http.StatusInternalServerError)
+		return
 	}
-}
 
-var validFilterPath = regexp.MustCompile("^/(Title|Text|Both)Filter/([0-9a-zA-Z ]+)$")
+	subscriber := dbManager.Events().Subscribe()
+	defer dbManager.Events().Unsubscribe(subscriber)
 
-func makeFilterHandler(function func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-                if tooManyRequests() {
-                        http.Error(writer, "Slow down, buddy!", 429)
-                        return
-                }
-                
-		urlTokens := validFilterPath.FindStringSubmatch(request.URL.Path)
-		if urlTokens == nil {
-			http.NotFound(writer, request)
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	fmt.Fprint(writer, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(EVENT_STREAM_KEEP_ALIVE)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
 			return
+		case <-stopping:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(writer, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, open := <-subscriber:
+			if !open {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				loggerFor(request).Error("Encoding a note event.", "error", err)
+				continue
+			}
+
+			fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
 		}
-		function(writer, request, urlTokens[2])
 	}
 }
 
-var validPreparePostPath = regexp.MustCompile("^/(DeleteNote|EditNote|PasteBinNote)/([0-9]+)$")
+func (s *server) tooManyRequests() bool {
+	if s.settings.RateLimit == 0 {
+		return false
+	}
+
+	s.lastRequestMutex.Lock()
+	defer s.lastRequestMutex.Unlock()
 
-func makePreparePostHandler(function func(http.ResponseWriter, *http.Request, string, int)) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-                if tooManyRequests() {
-                        http.Error(writer, "Slow down, buddy!", 429)
-                        return
-                }
-                
-		urlTokens := validPreparePostPath.FindStringSubmatch(request.URL.Path)
-		if urlTokens == nil {
-			http.NotFound(writer, request)
-			return
-		}
-		id, _ := strconv.Atoi(urlTokens[2])
-		function(writer, request, urlTokens[1], id)
+	var currentTime int64 = time.Now().UnixNano()
+
+	if s.lastRequestTime > math.MinInt64 && currentTime-s.lastRequestTime <= s.settings.RateLimit.Nanoseconds() {
+		rateLimitedTotal.Inc()
+		return true
 	}
+
+	s.lastRequestTime = currentTime
+	return false
 }
 
-var validPath = regexp.MustCompile("(^/(AddNote|NewNote)/$|/)")
+func main() {
+	var arguments []string = os.Args[1:]
+	var command string = ""
+
+	// Commands read the config file and the environment, their flags are
+	// their own.
+	if len(arguments) > 0 && !strings.HasPrefix(arguments[0], "-") {
+		command = arguments[0]
+		arguments = arguments[1:]
+	}
 
-func makeHandler(function func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-                if tooManyRequests() {
-                        http.Error(writer, "Slow down, buddy!", 429)
-                        return
-                }
-                
-		urlTokens := validPath.FindStringSubmatch(request.URL.Path)
-		if urlTokens == nil {
-			http.NotFound(writer, request)
-			return
-		}
-                
-		function(writer, request)
+	var settings config.Config = config.Default()
+	var err error
+	if command == "" {
+		settings, err = config.Load(arguments)
+	} else if command != "config" {
+		settings, err = config.Load(nil)
+	}
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-}
 
-var lastRequestTime int64 = math.MinInt64
+	setUpLogging(settings)
+	dbManager = newNoteStore(settings)
 
-func tooManyRequests() bool {
-        var currentTime int64 = time.Now().UnixNano()
-        
-        if lastRequestTime > math.MinInt64 && currentTime - lastRequestTime <= TOO_MANY_REQUEST_TIME_SPAN_IN_NS {
-                return true
-        }
-        
-        lastRequestTime = currentTime
-        return false
+	if command != "" {
+		os.Exit(runCommand(command, arguments, settings))
+	}
+
+	runServer(settings)
 }
 
-func main() {
-	http.HandleFunc("/", makeHandler(indexHandler))
-	http.HandleFunc("/AddNote/", makeHandler(addNoteHandler))
-	http.HandleFunc("/NewNote/", makeHandler(newNoteHandler))
-        
-        http.HandleFunc("/EditNote/", makePreparePostHandler(preparePostHandler))
-	http.HandleFunc("/DeleteNote/", makePreparePostHandler(preparePostHandler))
-	http.HandleFunc("/PasteBinNote/", makePreparePostHandler(preparePostHandler))
-        
-	http.HandleFunc("/Note/", makeNoteIDHandler(noteDetailsHandler))
-	http.HandleFunc("/SaveNote/", makeNoteIDHandler(saveNoteHandler))
-	http.HandleFunc("/ConfirmDeleteNote/", makeNoteIDHandler(deleteNoteHandler))
-	http.HandleFunc("/ConfirmPasteBinNote/", makeNoteIDHandler(pasteBinNoteHandler))
-        
-	http.HandleFunc("/TitleFilter/", makeFilterHandler(titleFilterHandler))
-	http.HandleFunc("/TextFilter/", makeFilterHandler(textFilterHandler))
-	http.HandleFunc("/BothFilter/", makeFilterHandler(bothFilterHandler))
+func runServer(settings config.Config) {
+	var s *server = newServer(settings)
+
+	var theme *assets.Theme = assets.New(settings.ThemeDirectory)
+	routes = s.setUpRoutes(theme)
+
+	var err error
+	templates, err = theme.Templates(template.FuncMap{"url": routes.URL})
+	if err != nil {
+		fatal(err)
+	}
+
+	setUpWebDAV()
+	setUpMetrics()
 
-	err := dbManager.Open()
+	err = dbManager.Open(context.Background())
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 		return
 	}
 
-	defer dbManager.Close()
+	if !readOnly {
+		if settings.EditLeases {
+			startBackgroundJob(sweepEditLeases)
+		}
 
-	log.Printf("ShareNotes initialized...")
+		if settings.SyncDirectory != "" {
+			s.startFolderSync()
+		}
 
-	err = http.ListenAndServe(":8080", nil)
-        
-        if err != nil {
-                log.Fatal(err)
-                return
-        }
+		if settings.BackupDirectory != "" {
+			s.startBackups()
+		}
+
+		startReencryption()
+	}
+
+	err = s.serve(logRequests(routes))
+
+	// Only closed once the requests are done and the background jobs have
+	// stopped, so no transaction is cut off.
+	dbManager.Close()
+
+	if err != nil {
+		fatal(err)
+	}
+
+	slog.Info("ShareNotes stopped.")
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"config"
+	"context"
+	"database/gitstore"
+	"database/manager"
+	"events"
+	"net/http"
+	"note"
+	"time"
+)
+
+// The titles and texts in sndb.db are encrypted with the master keys in the
+// key file of the settings, or else in the environment variable
+// ENCRYPTION_KEY_VARIABLE. Without keys the database is not encrypted. The
+// git storage keeps plain Markdown files; it has no change feed, so the sync
+// API answers 501 there and folder sync cannot be turned on.
+const ENCRYPTION_KEY_VARIABLE = "SHARENOTES_KEYS"
+
+type noteStore interface {
+	Open(ctx context.Context) error
+	Close()
+	Events() *events.Broker
+	Ready(ctx context.Context) error
+	CountNotes(ctx context.Context) (int, error)
+
+	LoadNotes(ctx context.Context) ([]note.Note, error)
+	ListNotes(ctx context.Context, query manager.PageQuery) (manager.NotePage, error)
+	FilterNotes(ctx context.Context, filter manager.NoteFilter) ([]note.Note, error)
+	EachNote(ctx context.Context, function func(note.Note) error) error
+	GetNote(ctx context.Context, noteID int) (note.Note, error)
+	GetRevision(ctx context.Context, noteID int, version int) (note.Note, error)
+	AddNote(ctx context.Context, n note.Note) error
+	CreateNote(ctx context.Context, n note.Note) (int, error)
+	UpdateNote(ctx context.Context, n note.Note) error
+	DeleteNote(ctx context.Context, noteID int, version int) error
+
+	GetLease(ctx context.Context, noteID int) (*manager.Lease, error)
+	AcquireLease(ctx context.Context, noteID int, holder string, holderName string, duration time.Duration) (manager.Lease, error)
+	ReleaseLease(ctx context.Context, noteID int, holder string) error
+	BreakLease(ctx context.Context, noteID int) error
+	SweepLeases(ctx context.Context) (int64, error)
+
+	Changes(ctx context.Context, since int64, limit int) ([]manager.Change, error)
+	ApplySyncItem(ctx context.Context, item manager.SyncItem) (manager.SyncResult, error)
+}
+
+func newNoteStore(storeSettings config.Config) noteStore {
+	if storeSettings.Storage == config.STORAGE_GIT {
+		return gitstore.New(storeSettings.GitDirectory)
+	}
+
+	var sqlite manager.DatabaseManager = manager.New(storeSettings.DatabaseFile)
+	sqlite.UseKeys(storeSettings.KeyFile, ENCRYPTION_KEY_VARIABLE)
+	return &sqlite
+}
+
+// keepsChanges tells whether the storage has the change feed the sync API and
+// folder sync are built on.
+func keepsChanges(storeSettings config.Config) bool {
+	return storeSettings.Storage != config.STORAGE_GIT
+}
+
+func storageErrorStatus(err error) int {
+	if err == gitstore.ErrNotSupported {
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/gitstore"
+	"database/manager"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"note"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_CHANGES_LIMIT = 100
+const MAX_CHANGES_LIMIT = 1000
+const MAX_SYNC_REQUEST_BYTES = 16 << 20
+const MAX_CLIENT_ID_LENGTH = 128
+
+const CHANGE_UPSERT = "upsert"
+const CHANGE_DELETE = "delete"
+
+type apiNote struct {
+	NoteID     int       `json:"noteID"`
+	Title      string    `json:"title"`
+	Text       string    `json:"text"`
+	AddDate    time.Time `json:"addDate"`
+	ChangeDate time.Time `json:"changeDate"`
+	Version    int       `json:"version"`
+	Tags       []string  `json:"tags"`
+}
+
+type apiChange struct {
+	Sequence   int64     `json:"sequence"`
+	Type       string    `json:"type"`
+	NoteID     int       `json:"noteID"`
+	ChangeDate time.Time `json:"changeDate"`
+	Note       *apiNote  `json:"note,omitempty"`
+}
+
+type apiChangesPage struct {
+	Changes []apiChange `json:"changes"`
+	Cursor  int64       `json:"cursor"`
+	More    bool        `json:"more"`
+}
+
+type apiSyncItem struct {
+	NoteID       int       `json:"noteID"`
+	BaseSequence int64     `json:"baseSequence"`
+	Deleted      bool      `json:"deleted"`
+	Title        string    `json:"title"`
+	Text         string    `json:"text"`
+	AddDate      time.Time `json:"addDate"`
+	ChangeDate   time.Time `json:"changeDate"`
+	Tags         []string  `json:"tags"`
+	ClientID     string    `json:"clientID"`
+}
+
+type apiSyncRequest struct {
+	Changes []apiSyncItem `json:"changes"`
+}
+
+// SYNC_LOCKED is the status of an item for a note that is being edited on
+// another device, the device uploads it again later.
+const SYNC_LOCKED = "locked"
+
+type apiSyncResult struct {
+	Index    int        `json:"index"`
+	Status   string     `json:"status"`
+	NoteID   int        `json:"noteID"`
+	ClientID string     `json:"clientID,omitempty"`
+	Sequence int64      `json:"sequence"`
+	Current  *apiChange `json:"current,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type apiSyncResponse struct {
+	Results []apiSyncResult `json:"results"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func noteToApiNote(n note.Note) *apiNote {
+	var tags []string = n.Tags()
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return &apiNote{
+		NoteID:     n.NoteID(),
+		Title:      n.Title(),
+		Text:       n.Text(),
+		AddDate:    n.AddDate(),
+		ChangeDate: n.ChangeDate(),
+		Version:    n.Version(),
+		Tags:       tags}
+}
+
+func changeToApiChange(change manager.Change) apiChange {
+	if change.Deleted {
+		return apiChange{
+			Sequence:   change.Sequence,
+			Type:       CHANGE_DELETE,
+			NoteID:     change.Note.NoteID(),
+			ChangeDate: change.Note.ChangeDate()}
+	}
+
+	return apiChange{
+		Sequence:   change.Sequence,
+		Type:       CHANGE_UPSERT,
+		NoteID:     change.Note.NoteID(),
+		ChangeDate: change.Note.ChangeDate(),
+		Note:       noteToApiNote(change.Note)}
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(status)
+
+	err := json.NewEncoder(writer).Encode(value)
+	if err != nil {
+		slog.Error("Encoding the JSON response.", "error", err)
+	}
+}
+
+func writeJSONError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, apiError{Error: message})
+}
+
+func (s *server) changesHandler(writer http.ResponseWriter, request *http.Request) {
+	if !keepsChanges(s.settings) {
+		writeJSONError(writer, http.StatusNotImplemented, gitstore.ErrNotSupported.Error())
+		return
+	}
+
+	switch request.Method {
+	case "GET", "HEAD":
+		listChangesHandler(writer, request)
+	case "POST":
+		s.uploadChangesHandler(writer, request)
+	}
+}
+
+func listChangesHandler(writer http.ResponseWriter, request *http.Request) {
+	var since int64 = 0
+	var limit int = DEFAULT_CHANGES_LIMIT
+	var err error
+
+	if value := request.FormValue("since"); value != "" {
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || since < 0 {
+			writeJSONError(writer, http.StatusBadRequest, "since must be a non-negative integer.")
+			return
+		}
+	}
+
+	if value := request.FormValue("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			writeJSONError(writer, http.StatusBadRequest, "limit must be a positive integer.")
+			return
+		}
+		if limit > MAX_CHANGES_LIMIT {
+			limit = MAX_CHANGES_LIMIT
+		}
+	}
+
+	// One extra row tells whether the client has to ask again.
+	changes, err := storeFor(request).Changes(request.Context(), since, limit+1)
+	if err != nil {
+		writeJSONError(writer, storageErrorStatus(err), err.Error())
+		return
+	}
+
+	var page apiChangesPage = apiChangesPage{Changes: []apiChange{}, Cursor: since}
+
+	if len(changes) > limit {
+		page.More = true
+		changes = changes[:limit]
+	}
+
+	for _, change := range changes {
+		page.Changes = append(page.Changes, changeToApiChange(change))
+		page.Cursor = change.Sequence
+	}
+
+	writeJSON(writer, http.StatusOK, page)
+}
+
+func (s *server) uploadChangesHandler(writer http.ResponseWriter, request *http.Request) {
+	// Browsers cannot send a cross-site JSON body without a preflight, which
+	// keeps this endpoint out of reach of forged form posts.
+	if !strings.HasPrefix(request.Header.Get("Content-Type"), "application/json") {
+		writeJSONError(writer, http.StatusUnsupportedMediaType, "Expected an application/json body.")
+		return
+	}
+
+	var syncRequest apiSyncRequest
+
+	err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, MAX_SYNC_REQUEST_BYTES)).Decode(&syncRequest)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var response apiSyncResponse = apiSyncResponse{Results: []apiSyncResult{}}
+
+	for index, item := range syncRequest.Changes {
+		var changeDate time.Time = item.ChangeDate
+		var addDate time.Time = item.AddDate
+
+		if changeDate.IsZero() {
+			changeDate = time.Now()
+		}
+		if addDate.IsZero() {
+			addDate = changeDate
+		}
+
+		if len(item.ClientID) > MAX_CLIENT_ID_LENGTH {
+			response.Results = append(response.Results, apiSyncResult{Index: index, Status: "error", Error: "clientID is too long."})
+			continue
+		}
+
+		if item.NoteID > 0 {
+			lease, err := s.leasedElsewhere(request, item.NoteID)
+			if err != nil {
+				response.Results = append(response.Results, apiSyncResult{Index: index, Status: "error", NoteID: item.NoteID, Error: err.Error()})
+				continue
+			} else if lease != nil {
+				response.Results = append(response.Results, apiSyncResult{Index: index, Status: SYNC_LOCKED, NoteID: item.NoteID, Error: leaseHeldMessage(lease)})
+				continue
+			}
+		}
+
+		result, err := storeFor(request).ApplySyncItem(request.Context(), manager.SyncItem{
+			Note:         note.NewLocal(item.NoteID, item.Title, item.Text, addDate, changeDate, 0, item.Tags),
+			BaseSequence: item.BaseSequence,
+			Deleted:      item.Deleted,
+			ClientID:     item.ClientID})
+		if err == gitstore.ErrNotSupported {
+			writeJSONError(writer, http.StatusNotImplemented, err.Error())
+			return
+		}
+
+		var apiResult apiSyncResult = apiSyncResult{
+			Index:    index,
+			Status:   result.Status,
+			NoteID:   result.NoteID,
+			ClientID: item.ClientID,
+			Sequence: result.Sequence}
+
+		if err != nil {
+			apiResult.Status = "error"
+			apiResult.Error = err.Error()
+		} else if result.Current != nil {
+			current := changeToApiChange(*result.Current)
+			apiResult.Current = &current
+		}
+
+		response.Results = append(response.Results, apiResult)
+	}
+
+	writeJSON(writer, http.StatusOK, response)
+}
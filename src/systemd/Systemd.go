@@ -0,0 +1,70 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Sockets passed by systemd start at this file descriptor.
+const LISTEN_FDS_START = 3
+
+// Listeners returns the sockets systemd opened for this process through
+// socket activation, in the order of the ListenStream lines of the socket
+// unit. Without socket activation there are none.
+func Listeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count == 0 {
+		return nil, nil
+	}
+
+	// Child processes must not take the sockets for theirs.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var listeners []net.Listener
+	for fd := LISTEN_FDS_START; fd < LISTEN_FDS_START+count; fd++ {
+		syscall.CloseOnExec(fd)
+
+		var file *os.File = os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return listeners, err
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// Notify tells systemd about the state of the service, e.g. "READY=1" or
+// "STOPPING=1", if it runs as a Type=notify service. It returns false when
+// it does not.
+func Notify(state string) (bool, error) {
+	var socket string = os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	connection, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer connection.Close()
+
+	_, err = connection.Write([]byte(state))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
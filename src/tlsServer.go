@@ -0,0 +1,98 @@
+package main
+
+import (
+	"certificate"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Without tls-cert and tls-key a self-signed certificate is generated into
+// these files on the first start and used from then on.
+const SELF_SIGNED_CERT_FILE = "sharenotes-selfsigned.crt"
+const SELF_SIGNED_KEY_FILE = "sharenotes-selfsigned.key"
+
+const HSTS_MAX_AGE = 180 * 24 * time.Hour
+
+func (s *server) loadCertificate() (*certificate.Reloader, error) {
+	var certFile string = s.settings.TLSCert
+	var keyFile string = s.settings.TLSKey
+
+	if certFile == "" {
+		certFile = SELF_SIGNED_CERT_FILE
+		keyFile = SELF_SIGNED_KEY_FILE
+
+		if _, err := os.Stat(certFile); os.IsNotExist(err) {
+			var hosts []string = certificate.Hosts()
+
+			err = certificate.GenerateSelfSigned(certFile, keyFile, hosts)
+			if err != nil {
+				return nil, err
+			}
+			slog.Warn("Generated a self-signed certificate, browsers will warn about it until it is trusted.", "file", certFile, "hosts", hosts)
+		}
+	}
+
+	reloader, err := certificate.NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	go reloadCertificateOnHangup(reloader, certFile)
+
+	return reloader, nil
+}
+
+func reloadCertificateOnHangup(reloader *certificate.Reloader, certFile string) {
+	var hangups chan os.Signal = make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		err := reloader.Reload()
+		if err != nil {
+			slog.Error("Reloading the certificate, keeping the one in use.", "error", err)
+			continue
+		}
+		slog.Info("Reloaded the certificate.", "file", certFile)
+	}
+}
+
+func hstsHandler(next http.Handler) http.Handler {
+	var value string = fmt.Sprintf("max-age=%d", int(HSTS_MAX_AGE.Seconds()))
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// redirectHandler sends plain HTTP requests to the same path on the HTTPS
+// port.
+func (s *server) redirectHandler(writer http.ResponseWriter, request *http.Request) {
+	host, _, err := net.SplitHostPort(request.Host)
+	if err != nil {
+		host = request.Host
+	}
+
+	_, port, _ := net.SplitHostPort(s.settings.Address)
+	if port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	http.Redirect(writer, request, "https://"+host+request.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+func (s *server) tlsConfig() (*tls.Config, error) {
+	reloader, err := s.loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{GetCertificate: reloader.GetCertificate, MinVersion: tls.VersionTLS12}, nil
+}